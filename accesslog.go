@@ -0,0 +1,98 @@
+package gosocks
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// An AccessLog writes one JSON object per line (NDJSON) for each finished
+// client connection. The entries are buffered and flushed periodically.
+type AccessLog struct {
+	mu   sync.Mutex
+	w    *bufio.Writer
+	done chan struct{}
+}
+
+// AccessLogEntry is the record written for each connection.
+type AccessLogEntry struct {
+	Time         time.Time `json:"time"`
+	RequestID    string    `json:"request_id"`
+	ClientAddr   string    `json:"client_addr"`
+	Version      string    `json:"version"`
+	Command      string    `json:"command"`
+	TargetAddr   string    `json:"target_addr"`
+	BytesSent    int64     `json:"bytes_sent"`
+	BytesRecv    int64     `json:"bytes_recv"`
+	DialDuration float64   `json:"dial_duration_seconds,omitempty"`
+	Duration     float64   `json:"duration_seconds"`
+	Outcome      string    `json:"outcome"`
+}
+
+// NewAccessLog returns an AccessLog writing to w, flushed every interval.
+func NewAccessLog(w io.Writer, interval time.Duration) *AccessLog {
+	l := &AccessLog{w: bufio.NewWriter(w), done: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				l.Flush()
+			case <-l.done:
+				return
+			}
+		}
+	}()
+	return l
+}
+
+// Write appends an entry to the log.
+func (l *AccessLog) Write(entry *AccessLogEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(append(line, '\n'))
+	return err
+}
+
+// Flush writes the buffered entries out.
+func (l *AccessLog) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Flush()
+}
+
+// Close stops the periodic flush and flushes the remaining entries. It does
+// not close the underlying writer.
+func (l *AccessLog) Close() error {
+	close(l.done)
+	return l.Flush()
+}
+
+func (s *Server) logAccess(sess *session) {
+	if s.AccessLog == nil {
+		return
+	}
+	err := s.AccessLog.Write(&AccessLogEntry{
+		Time:         sess.start,
+		RequestID:    RequestID(sess.ctx),
+		ClientAddr:   sess.clientAddr,
+		Version:      sess.version,
+		Command:      sess.command,
+		TargetAddr:   sess.target,
+		BytesSent:    sess.sent.Load(),
+		BytesRecv:    sess.recv.Load(),
+		DialDuration: sess.dialDuration.Seconds(),
+		Duration:     time.Since(sess.start).Seconds(),
+		Outcome:      sess.outcome,
+	})
+	if err != nil {
+		sess.log.Error("Failed to write the access log", "remote_addr", sess.clientAddr, "error", err)
+	}
+}
@@ -0,0 +1,95 @@
+package gosocks_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestAccessLog checks that a finished connection gets an NDJSON entry with
+// the fields of the request, correctly typed.
+func TestAccessLog(t *testing.T) {
+	var out syncBuffer
+	accessLog := gosocks.NewAccessLog(&out, 10*time.Millisecond)
+	defer accessLog.Close()
+	srv := gosockstest.NewServer(t, func(s *gosocks.Server) {
+		s.AccessLog = accessLog
+	})
+	target := gosockstest.NewEchoServer(t)
+	conn, err := client.Dial(srv.Addr(), target)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	echo(t, conn, "hello")
+	conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.HasSuffix(out.String(), "\n") {
+		if time.Now().After(deadline) {
+			t.Fatal("no access log entry was flushed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("access log = %q, want a single entry", lines)
+	}
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("access log entry %s: %v", lines[0], err)
+	}
+
+	wantStrings := map[string]string{
+		"version":     "socks5",
+		"command":     "connect",
+		"target_addr": target,
+		"outcome":     "success",
+		"client_addr": conn.LocalAddr().String(),
+	}
+	for field, want := range wantStrings {
+		if got, ok := entry[field].(string); !ok || got != want {
+			t.Errorf("%s = %#v, want %q", field, entry[field], want)
+		}
+	}
+	for _, field := range []string{"bytes_sent", "bytes_recv"} {
+		if got, ok := entry[field].(float64); !ok || got != 5 {
+			t.Errorf("%s = %#v, want 5", field, entry[field])
+		}
+	}
+	if d, ok := entry["duration_seconds"].(float64); !ok || d <= 0 {
+		t.Errorf("duration_seconds = %#v, want a positive number", entry["duration_seconds"])
+	}
+	if s, ok := entry["time"].(string); !ok {
+		t.Errorf("time = %#v, want a string", entry["time"])
+	} else if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+		t.Errorf("time = %q: %v", s, err)
+	}
+	if _, ok := entry["request_id"].(string); !ok {
+		t.Errorf("request_id = %#v, want a string", entry["request_id"])
+	}
+}
@@ -0,0 +1,259 @@
+package gosocks
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strings"
+)
+
+// ACL restricts which clients may use the server and which targets they may
+// connect to. A nil *ACL allows everything.
+type ACL struct {
+	// AllowClients lists the networks the clients must come from. An empty
+	// list allows every client.
+	AllowClients []*net.IPNet
+
+	// DenyTargets lists the networks the resolved targets must not be in.
+	DenyTargets []*net.IPNet
+
+	// DenyHosts lists the glob patterns, as understood by path.Match, the
+	// requested host names must not match.
+	DenyHosts []string
+
+	// Allowlist denies the targets which are neither in AllowTargets nor
+	// match AllowHosts. DenyTargets and DenyHosts still apply to the listed
+	// ones.
+	Allowlist    bool
+	AllowTargets []*net.IPNet
+	AllowHosts   []string
+
+	// AllowIdentities lists the authenticated identities, such as the
+	// usernames or the GSSAPI principals, which may use the server. An empty
+	// list allows everyone.
+	AllowIdentities []string
+
+	// parent also has to allow what a allows, see within.
+	parent *ACL
+}
+
+// within returns a copy of a which only allows what parent allows too, so
+// that the ACL of a Listener cannot be looser than the one of the Server,
+// whichever of them is replaced.
+func (a *ACL) within(parent *ACL) *ACL {
+	if parent == nil {
+		return a
+	}
+	acl := *a
+	acl.parent = parent
+	return &acl
+}
+
+// AddAllowClient parses a CIDR, or a single IP address, and appends it to
+// AllowClients.
+func (a *ACL) AddAllowClient(value string) error {
+	ipNet, err := parseCIDR(value)
+	if err != nil {
+		return err
+	}
+	a.AllowClients = append(a.AllowClients, ipNet)
+	return nil
+}
+
+// AddDenyTarget appends value to DenyTargets if it is a CIDR or a single IP
+// address, and to DenyHosts otherwise.
+func (a *ACL) AddDenyTarget(value string) error {
+	return addTarget(&a.DenyTargets, &a.DenyHosts, value)
+}
+
+// AddAllowTarget appends value to AllowTargets if it is a CIDR or a single
+// IP address, and to AllowHosts otherwise. It does not set Allowlist.
+func (a *ACL) AddAllowTarget(value string) error {
+	return addTarget(&a.AllowTargets, &a.AllowHosts, value)
+}
+
+func addTarget(ipNets *[]*net.IPNet, hosts *[]string, value string) error {
+	if ipNet, err := parseCIDR(value); err == nil {
+		*ipNets = append(*ipNets, ipNet)
+		return nil
+	}
+	pattern := strings.ToLower(value)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid host pattern '%s': %v", value, err)
+	}
+	*hosts = append(*hosts, pattern)
+	return nil
+}
+
+// LoadAllowlist replaces AllowTargets and AllowHosts with the CIDRs and the
+// host name globs of a file, one per line, and sets Allowlist. Empty lines
+// and lines starting with '#' are ignored. a is left untouched on error.
+func (a *ACL) LoadAllowlist(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var ipNets []*net.IPNet
+	var hosts []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		err = addTarget(&ipNets, &hosts, line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, i+1, err)
+		}
+	}
+	a.Allowlist, a.AllowTargets, a.AllowHosts = true, ipNets, hosts
+	return nil
+}
+
+// aclJSON is the JSON form of an ACL, with the values AddAllowClient and
+// AddDenyTarget accept.
+type aclJSON struct {
+	AllowClients    []string `json:"allow_clients"`
+	DenyTargets     []string `json:"deny_targets"`
+	Allowlist       bool     `json:"allowlist,omitempty"`
+	AllowTargets    []string `json:"allow_targets,omitempty"`
+	AllowIdentities []string `json:"allow_identities"`
+}
+
+func (a *ACL) MarshalJSON() ([]byte, error) {
+	var j aclJSON
+	for _, ipNet := range a.AllowClients {
+		j.AllowClients = append(j.AllowClients, ipNet.String())
+	}
+	for _, ipNet := range a.DenyTargets {
+		j.DenyTargets = append(j.DenyTargets, ipNet.String())
+	}
+	j.DenyTargets = append(j.DenyTargets, a.DenyHosts...)
+	j.Allowlist = a.Allowlist
+	for _, ipNet := range a.AllowTargets {
+		j.AllowTargets = append(j.AllowTargets, ipNet.String())
+	}
+	j.AllowTargets = append(j.AllowTargets, a.AllowHosts...)
+	j.AllowIdentities = a.AllowIdentities
+	return json.Marshal(j)
+}
+
+func (a *ACL) UnmarshalJSON(data []byte) error {
+	var j aclJSON
+	err := json.Unmarshal(data, &j)
+	if err != nil {
+		return err
+	}
+	*a = ACL{AllowIdentities: j.AllowIdentities, Allowlist: j.Allowlist}
+	for _, value := range j.AllowClients {
+		err = a.AddAllowClient(value)
+		if err != nil {
+			return err
+		}
+	}
+	for _, value := range j.DenyTargets {
+		err = a.AddDenyTarget(value)
+		if err != nil {
+			return err
+		}
+	}
+	for _, value := range j.AllowTargets {
+		err = a.AddAllowTarget(value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AllowClient reports whether a client connecting from ip may use the
+// server.
+func (a *ACL) AllowClient(ip net.IP) bool {
+	if a == nil {
+		return true
+	}
+	if !a.parent.AllowClient(ip) {
+		return false
+	}
+	return len(a.AllowClients) == 0 || containsIP(a.AllowClients, ip)
+}
+
+// AllowIdentity reports whether a client authenticated as identity, empty if
+// it did not authenticate, may use the server.
+func (a *ACL) AllowIdentity(identity string) bool {
+	if a == nil {
+		return true
+	}
+	if !a.parent.AllowIdentity(identity) {
+		return false
+	}
+	if len(a.AllowIdentities) == 0 {
+		return true
+	}
+	for _, allowed := range a.AllowIdentities {
+		if allowed == identity {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowTarget reports whether a connection to ip may be opened. host is the
+// requested host name, empty if the client requested an IP address. ip is
+// nil when checking host before resolving it, in which case an Allowlist
+// only refuses it once ip is known.
+func (a *ACL) AllowTarget(host string, ip net.IP) bool {
+	if a == nil {
+		return true
+	}
+	if !a.parent.AllowTarget(host, ip) {
+		return false
+	}
+	if ip != nil && containsIP(a.DenyTargets, ip) {
+		return false
+	}
+	if host != "" {
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		if matchesHost(a.DenyHosts, host) {
+			return false
+		}
+	}
+	if !a.Allowlist || ip == nil || (host != "" && matchesHost(a.AllowHosts, host)) {
+		return true
+	}
+	return containsIP(a.AllowTargets, ip)
+}
+
+func matchesHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, host); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIP(ipNets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range ipNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCIDR(value string) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address '%s'", value)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(value)
+	return ipNet, err
+}
@@ -0,0 +1,122 @@
+package gosocks_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+func newACL(t *testing.T, clients, denied, allowed []string) *gosocks.ACL {
+	t.Helper()
+	acl := &gosocks.ACL{Allowlist: len(allowed) > 0}
+	for _, c := range clients {
+		if err := acl.AddAllowClient(c); err != nil {
+			t.Fatalf("AddAllowClient(%q): %v", c, err)
+		}
+	}
+	for _, d := range denied {
+		if err := acl.AddDenyTarget(d); err != nil {
+			t.Fatalf("AddDenyTarget(%q): %v", d, err)
+		}
+	}
+	for _, a := range allowed {
+		if err := acl.AddAllowTarget(a); err != nil {
+			t.Fatalf("AddAllowTarget(%q): %v", a, err)
+		}
+	}
+	return acl
+}
+
+func TestACLAllowClient(t *testing.T) {
+	acl := newACL(t, []string{"10.0.0.0/8", "10.1.0.0/16", "192.0.2.7", "2001:db8::/32", "2001:db8:1::/48"}, nil, nil)
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"10.2.0.1", true},
+		{"192.0.2.7", true},
+		{"192.0.2.8", false},
+		{"::ffff:10.1.2.3", true},
+		{"2001:db8:1::1", true},
+		{"2001:db8:2::1", true},
+		{"2001:db9::1", false},
+		{"::1", false},
+	}
+	for _, tt := range tests {
+		if got := acl.AllowClient(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("AllowClient(%v) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	var none *gosocks.ACL
+	if !none.AllowClient(net.ParseIP("192.0.2.8")) {
+		t.Error("a nil ACL refused a client")
+	}
+}
+
+func TestACLAllowTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		denied  []string
+		allowed []string
+		host    string
+		ip      string
+		want    bool
+	}{
+		{"no rule", nil, nil, "", "192.0.2.1", true},
+		{"denied network", []string{"192.168.0.0/16"}, nil, "", "192.168.1.1", false},
+		{"denied overlapping networks", []string{"192.168.0.0/16", "192.168.1.0/24"}, nil, "", "192.168.2.1", false},
+		{"outside the denied networks", []string{"192.168.0.0/16"}, nil, "", "192.169.0.1", true},
+		{"denied IPv6 network", []string{"fd00::/8"}, nil, "", "fd12::1", false},
+		{"IPv4 mapped IPv6", []string{"192.168.0.0/16"}, nil, "", "::ffff:192.168.1.1", false},
+		{"denied host", []string{"*.example.com"}, nil, "www.Example.com.", "192.0.2.1", false},
+		{"other host", []string{"*.example.com"}, nil, "example.org", "192.0.2.1", true},
+		{"host before its resolution", []string{"*.example.com"}, nil, "www.example.com", "", false},
+		{"allowed network", nil, []string{"10.0.0.0/8"}, "", "10.1.1.1", true},
+		{"not allowed network", nil, []string{"10.0.0.0/8"}, "", "11.1.1.1", false},
+		{"denied within allowed", []string{"10.1.0.0/16"}, []string{"10.0.0.0/8"}, "", "10.1.1.1", false},
+		{"allowed within denied", []string{"10.0.0.0/8"}, []string{"10.1.0.0/16"}, "", "10.1.1.1", false},
+		{"allowed host", nil, []string{"*.example.com"}, "www.example.com", "192.0.2.1", true},
+		{"allowlist before the resolution", nil, []string{"10.0.0.0/8"}, "www.example.com", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acl := newACL(t, nil, tt.denied, tt.allowed)
+			if got := acl.AllowTarget(tt.host, net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("AllowTarget(%q, %v) = %v, want %v", tt.host, tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestACLReply checks that the requests the ACL refuses get the reply 0x02.
+func TestACLReply(t *testing.T) {
+	target := gosockstest.NewEchoServer(t)
+	tests := []struct {
+		name    string
+		clients []string
+		denied  []string
+		rep     byte
+	}{
+		{"allowed", []string{"127.0.0.0/8"}, []string{"192.0.2.0/24"}, 0x00},
+		{"client not allowed", []string{"192.0.2.0/24", "2001:db8::/32"}, nil, 0x02},
+		{"target denied", nil, []string{"127.0.0.0/8"}, 0x02},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acl := newACL(t, tt.clients, tt.denied, nil)
+			srv := gosockstest.NewServer(t, func(s *gosocks.Server) {
+				s.ACL = acl
+			})
+			conn := dialProxy(t, srv)
+			negotiate(t, conn, 0x00)
+			rep, _, _ := request(t, conn, 0x01, socksAddr(t, target))
+			if rep != tt.rep {
+				t.Errorf("reply = %#x, want %#x", rep, tt.rep)
+			}
+		})
+	}
+}
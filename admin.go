@@ -0,0 +1,166 @@
+package gosocks
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// ConnectionInfo describes a connection being relayed, as listed by the admin
+// API. The byte counts are updated every 64 KiB. DialDuration is how long the
+// request took to reach its target, and Duration the lifetime of the
+// connection so far.
+type ConnectionInfo struct {
+	ID           string    `json:"id"`
+	ClientAddr   string    `json:"client_addr"`
+	Version      string    `json:"version"`
+	Command      string    `json:"command"`
+	TargetAddr   string    `json:"target_addr"`
+	Identity     string    `json:"identity,omitempty"`
+	BytesSent    int64     `json:"bytes_sent"`
+	BytesRecv    int64     `json:"bytes_recv"`
+	Started      time.Time `json:"started"`
+	DialDuration float64   `json:"dial_duration_seconds"`
+	Duration     float64   `json:"duration_seconds"`
+}
+
+// Connections lists the connections being relayed, oldest first.
+func (s *Server) Connections() []ConnectionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infos := make([]ConnectionInfo, 0, len(s.sessions))
+	for id, sess := range s.sessions {
+		infos = append(infos, ConnectionInfo{
+			ID:           id,
+			ClientAddr:   sess.clientAddr,
+			Version:      sess.version,
+			Command:      sess.command,
+			TargetAddr:   sess.target,
+			Identity:     sess.identity,
+			BytesSent:    sess.sent.Load(),
+			BytesRecv:    sess.recv.Load(),
+			Started:      sess.start,
+			DialDuration: sess.dialDuration.Seconds(),
+			Duration:     time.Since(sess.start).Seconds(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Started.Before(infos[j].Started)
+	})
+	return infos
+}
+
+// CloseConnection cancels the connection with the given ID, and reports
+// whether it was being relayed.
+func (s *Server) CloseConnection(id string) bool {
+	s.mu.Lock()
+	sess, ok := s.sessions[id]
+	s.mu.Unlock()
+	if ok {
+		sess.cancel()
+	}
+	return ok
+}
+
+// NewAdminHandler returns the JSON HTTP API which inspects and controls s at
+// runtime:
+//
+//	GET    /connections         lists the connections being relayed
+//	DELETE /connections/{id}    closes one of them
+//	GET    /stats/destinations  returns s.DestinationStats
+//	POST   /dns/flush           empties the DNSCache, if s.Resolver is one
+//	GET    /acl                 returns s.ACL
+//	PUT    /acl                 replaces s.ACL
+//	GET    /circuits            lists the circuits of s.Breaker
+//	GET    /dashboard           shows the traffic in real time, from /events
+//	GET    /events              streams a DashboardStats every second
+//	GET    /proxy.pac           returns s.PAC, see servePAC
+//
+// If token is not empty, the requests must carry it as a bearer token, or as
+// the token query parameter since a browser cannot add headers to an
+// EventSource.
+func NewAdminHandler(s *Server, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /connections", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Connections())
+	})
+	mux.HandleFunc("DELETE /connections/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if !s.CloseConnection(r.PathValue("id")) {
+			writeJSONError(w, http.StatusNotFound, "no such connection")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /stats/destinations", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.DestinationStats())
+	})
+	mux.HandleFunc("POST /dns/flush", func(w http.ResponseWriter, r *http.Request) {
+		cache, ok := s.Resolver.(*DNSCache)
+		if !ok {
+			writeJSONError(w, http.StatusConflict, "the DNS cache is disabled")
+			return
+		}
+		cache.Flush()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /acl", func(w http.ResponseWriter, r *http.Request) {
+		acl := s.acl()
+		if acl == nil {
+			acl = &ACL{}
+		}
+		writeJSON(w, http.StatusOK, acl)
+	})
+	mux.HandleFunc("PUT /acl", func(w http.ResponseWriter, r *http.Request) {
+		acl := &ACL{}
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(acl)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.SetACL(acl)
+		writeJSON(w, http.StatusOK, acl)
+	})
+	mux.HandleFunc("GET /circuits", func(w http.ResponseWriter, r *http.Request) {
+		if s.Breaker == nil {
+			writeJSONError(w, http.StatusConflict, "the circuit breaker is disabled")
+			return
+		}
+		writeJSON(w, http.StatusOK, s.Breaker.Circuits())
+	})
+	mux.HandleFunc("GET /dashboard", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, dashboardFS, "dashboard.html")
+	})
+	mux.HandleFunc("GET /events", func(w http.ResponseWriter, r *http.Request) {
+		s.serveEvents(w, r, time.Second)
+	})
+	mux.HandleFunc("GET /proxy.pac", s.servePAC)
+
+	if token == "" {
+		return mux
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if auth == "" && r.URL.Query().Has("token") {
+			auth = "Bearer " + r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(auth), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
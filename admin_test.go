@@ -0,0 +1,128 @@
+package gosocks_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// adminRequest sends a request with the bearer token to the admin API
+// served by ts, and returns the status and the body of the response.
+func adminRequest(t *testing.T, ts *httptest.Server, token, method, path, body string) (int, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if len(b) > 0 && resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("%s %s: Content-Type = %q, want JSON", method, path, resp.Header.Get("Content-Type"))
+	}
+	return resp.StatusCode, b
+}
+
+func TestAdminAPI(t *testing.T) {
+	srv := gosockstest.NewServer(t, func(s *gosocks.Server) {
+		s.Resolver = gosocks.NewDNSCache(nil, time.Minute, 16)
+	})
+	ts := httptest.NewServer(gosocks.NewAdminHandler(srv.Config, "secret"))
+	defer ts.Close()
+
+	for _, token := range []string{"", "wrong"} {
+		if code, _ := adminRequest(t, ts, token, "GET", "/connections", ""); code != http.StatusUnauthorized {
+			t.Errorf("GET /connections with the token %q = %d, want 401", token, code)
+		}
+	}
+
+	target := gosockstest.NewEchoServer(t)
+	conn, err := client.Dial(srv.Addr(), target)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	echo(t, conn, "hello")
+
+	code, body := adminRequest(t, ts, "secret", "GET", "/connections", "")
+	var conns []map[string]any
+	if err := json.Unmarshal(body, &conns); code != http.StatusOK || err != nil || len(conns) != 1 {
+		t.Fatalf("GET /connections = %d %s, want one connection (%v)", code, body, err)
+	}
+	info := conns[0]
+	for field, want := range map[string]string{
+		"client_addr": conn.LocalAddr().String(),
+		"version":     "socks5",
+		"command":     "connect",
+		"target_addr": target,
+	} {
+		if got, ok := info[field].(string); !ok || got != want {
+			t.Errorf("%s = %#v, want %q", field, info[field], want)
+		}
+	}
+	id, _ := info["id"].(string)
+	if !uuidPattern.MatchString(id) {
+		t.Errorf("id = %#v, want a UUID", info["id"])
+	}
+	for _, field := range []string{"bytes_sent", "bytes_recv", "dial_duration_seconds", "duration_seconds"} {
+		if _, ok := info[field].(float64); !ok {
+			t.Errorf("%s = %#v, want a number", field, info[field])
+		}
+	}
+	if s, ok := info["started"].(string); !ok {
+		t.Errorf("started = %#v, want a string", info["started"])
+	} else if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+		t.Errorf("started = %q: %v", s, err)
+	}
+
+	if code, _ := adminRequest(t, ts, "secret", "POST", "/dns/flush", ""); code != http.StatusNoContent {
+		t.Errorf("POST /dns/flush = %d, want 204", code)
+	}
+
+	code, body = adminRequest(t, ts, "secret", "PUT", "/acl", `{"deny_targets": ["127.0.0.0/8"]}`)
+	var acl map[string]any
+	if err := json.Unmarshal(body, &acl); code != http.StatusOK || err != nil {
+		t.Fatalf("PUT /acl = %d %s (%v)", code, body, err)
+	}
+	if deny, _ := acl["deny_targets"].([]any); len(deny) != 1 || deny[0] != "127.0.0.0/8" {
+		t.Errorf("deny_targets = %#v, want [127.0.0.0/8]", acl["deny_targets"])
+	}
+	if code, _ := adminRequest(t, ts, "secret", "PUT", "/acl", `{"deny_targets": [`); code != http.StatusBadRequest {
+		t.Errorf("PUT /acl with a truncated body = %d, want 400", code)
+	}
+	if denied, err := client.Dial(srv.Addr(), target); err == nil {
+		denied.Close()
+		t.Error("Dial succeeded past the ACL set with PUT /acl")
+	}
+
+	if code, _ := adminRequest(t, ts, "secret", "DELETE", "/connections/"+id, ""); code != http.StatusNoContent {
+		t.Errorf("DELETE /connections/%s = %d, want 204", id, code)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read = %d, %v after DELETE, want EOF", n, err)
+	}
+	if code, _ := adminRequest(t, ts, "secret", "DELETE", "/connections/"+id, ""); code != http.StatusNotFound {
+		t.Errorf("DELETE of a closed connection = %d, want 404", code)
+	}
+}
@@ -0,0 +1,195 @@
+package gosocks
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Authenticator validates the credentials sent by a client using the
+// username/password method (RFC 1929), and returns the identity the client is
+// known by.
+type Authenticator interface {
+	Authenticate(username, password string) (identity string, err error)
+}
+
+var ErrAuthFailed = errors.New("gosocks: invalid username or password")
+
+// Credentials is an in-memory Authenticator. It implements flag.Value so that
+// it can be populated by a repeated 'username:password' flag. A password
+// starting with '$2a$', '$2b$' or '$2y$' is a bcrypt hash, as printed by
+// HashPassword.
+type Credentials map[string]string
+
+func (c Credentials) String() string {
+	users := make([]string, 0, len(c))
+	for user := range c {
+		users = append(users, user)
+	}
+	return strings.Join(users, ",")
+}
+
+func (c Credentials) Set(value string) error {
+	i := strings.Index(value, ":")
+	if i <= 0 {
+		return fmt.Errorf("expected 'username:password', got '%s'", value)
+	}
+	c[value[:i]] = value[i+1:]
+	return nil
+}
+
+// dummyHash is compared with the passwords of the unknown users, so that
+// they take as long to refuse as the users with a bcrypt hash.
+const dummyHash = "$2a$12$MOcK8/Vsc4FyAviShNJwn.V1HcFp6mhjdpedP1yJQOSF94fIMmkA6"
+
+func (c Credentials) Authenticate(username, password string) (string, error) {
+	expected, ok := c[username]
+	if !ok {
+		bcrypt.CompareHashAndPassword([]byte(dummyHash), []byte(password))
+		return "", ErrAuthFailed
+	}
+	if isBcryptHash(expected) {
+		if bcrypt.CompareHashAndPassword([]byte(expected), []byte(password)) != nil {
+			return "", ErrAuthFailed
+		}
+	} else if subtle.ConstantTimeCompare([]byte(expected), []byte(password)) != 1 {
+		return "", ErrAuthFailed
+	}
+	return username, nil
+}
+
+// HashPassword returns the bcrypt hash of password to use in Credentials.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	return string(hash), err
+}
+
+func isBcryptHash(s string) bool {
+	return strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$")
+}
+
+func (s *Server) authenticate(client net.Conn, sess *session) bool {
+	addr := client.RemoteAddr().String()
+
+	var header [2]byte
+	_, err := io.ReadFull(client, header[:])
+	if err != nil {
+		sess.log.Warn("Failed to read the authentication header", "remote_addr", addr, "error", err)
+		return false
+	}
+	if header[0] != 0x01 {
+		sess.log.Warn("Unknown authentication version", "remote_addr", addr, "version", header[0])
+		return false
+	}
+
+	user := make([]byte, header[1])
+	_, err = io.ReadFull(client, user)
+	if err != nil {
+		sess.log.Warn("Failed to read the username", "remote_addr", addr, "error", err)
+		return false
+	}
+
+	var passwordLen [1]byte
+	_, err = io.ReadFull(client, passwordLen[:])
+	if err != nil {
+		sess.log.Warn("Failed to read the password len", "remote_addr", addr, "error", err)
+		return false
+	}
+	password := make([]byte, passwordLen[0])
+	_, err = io.ReadFull(client, password)
+	if err != nil {
+		sess.log.Warn("Failed to read the password", "remote_addr", addr, "error", err)
+		return false
+	}
+
+	reply := [2]byte{0x01, 0x00}
+	identity, err := sess.auth.Authenticate(string(user), string(password))
+	ok := err == nil
+	if !ok {
+		reply[1] = 0x01
+	}
+	_, err = client.Write(reply[:])
+	if err != nil {
+		sess.log.Warn("Failed to write authentication reply", "remote_addr", addr, "error", err)
+		return false
+	}
+	if !ok {
+		sess.outcome = outcomeAuthFail
+		sess.log.Warn("Authentication failed", "remote_addr", addr, "user", string(user))
+		return false
+	}
+	sess.identity = identity
+	return true
+}
+
+// LoadCredentials reads a file of 'username:password' lines. Empty lines and
+// lines starting with '#' are ignored.
+func LoadCredentials(path string) (Credentials, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := Credentials{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		err = c.Set(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, i+1, err)
+		}
+	}
+	return c, nil
+}
+
+// CredentialsFile is an Authenticator checking the credentials of a file of
+// LoadCredentials, which Reload reads again. It is safe for concurrent use:
+// the lookups in flight during a Reload use either the old or the new
+// credentials.
+type CredentialsFile struct {
+	path        string
+	credentials atomic.Pointer[Credentials]
+}
+
+// NewCredentialsFile loads the credentials of the file at path.
+func NewCredentialsFile(path string) (*CredentialsFile, error) {
+	f := &CredentialsFile{path: path}
+	err := f.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Path returns the path of the file.
+func (f *CredentialsFile) Path() string {
+	return f.path
+}
+
+// Reload reads the file again, and replaces the credentials with its own
+// unless it is invalid, in which case the current ones are kept.
+func (f *CredentialsFile) Reload() error {
+	credentials, err := LoadCredentials(f.path)
+	if err != nil {
+		return err
+	}
+	f.credentials.Store(&credentials)
+	return nil
+}
+
+// Len returns the number of users.
+func (f *CredentialsFile) Len() int {
+	return len(*f.credentials.Load())
+}
+
+func (f *CredentialsFile) Authenticate(username, password string) (string, error) {
+	return f.credentials.Load().Authenticate(username, password)
+}
@@ -0,0 +1,72 @@
+package gosocks
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimitedWriter waits for every byte written to be allowed by all of its
+// token buckets.
+type rateLimitedWriter struct {
+	ctx      context.Context
+	w        io.Writer
+	limiters []*rate.Limiter
+}
+
+func (w *rateLimitedWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		chunk := len(b)
+		for _, limiter := range w.limiters {
+			if burst := limiter.Burst(); chunk > burst {
+				chunk = burst
+			}
+		}
+		for _, limiter := range w.limiters {
+			err := limiter.WaitN(w.ctx, chunk)
+			if err != nil {
+				return written, err
+			}
+		}
+		n, err := w.w.Write(b[:chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[chunk:]
+	}
+	return written, nil
+}
+
+// newBandwidthLimiter returns a token bucket of bytesPerSec, holding one
+// second worth of bytes.
+func newBandwidthLimiter(bytesPerSec int64) *rate.Limiter {
+	burst := int(bytesPerSec)
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSec), burst)
+}
+
+// limitWriter applies MaxBandwidthPerConn, with a bucket of its own, and
+// MaxBandwidthTotal, with the bucket shared by the whole server, to w.
+func (s *Server) limitWriter(ctx context.Context, w io.Writer) io.Writer {
+	var limiters []*rate.Limiter
+	if s.MaxBandwidthPerConn > 0 {
+		limiters = append(limiters, newBandwidthLimiter(s.MaxBandwidthPerConn))
+	}
+	if s.MaxBandwidthTotal > 0 {
+		s.mu.Lock()
+		if s.totalBandwidth == nil {
+			s.totalBandwidth = newBandwidthLimiter(s.MaxBandwidthTotal)
+		}
+		limiters = append(limiters, s.totalBandwidth)
+		s.mu.Unlock()
+	}
+	if len(limiters) == 0 {
+		return w
+	}
+	return &rateLimitedWriter{ctx: ctx, w: w, limiters: limiters}
+}
@@ -0,0 +1,75 @@
+package gosocks_test
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// TestBandwidthLimits checks that the throughput the targets receive stays
+// within 110% of the limits, once the burst of a second worth of bytes has
+// gone through.
+func TestBandwidthLimits(t *testing.T) {
+	const limit = 1 << 20
+	tests := []struct {
+		name  string
+		opt   gosockstest.Option
+		conns int
+	}{
+		{"per connection", func(s *gosocks.Server) { s.MaxBandwidthPerConn = limit }, 1},
+		{"total", func(s *gosocks.Server) { s.MaxBandwidthTotal = limit }, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := make(chan int64, tt.conns)
+			target := gosockstest.NewTarget(t, func(conn net.Conn) {
+				n, _ := io.Copy(io.Discard, conn)
+				received <- n
+			})
+			srv := gosockstest.NewServer(t, tt.opt)
+
+			// Each connection sends half a second worth of bytes past
+			// the burst.
+			size := (limit + limit/2) / tt.conns
+			start := time.Now()
+			var wg sync.WaitGroup
+			for range tt.conns {
+				conn, err := client.Dial(srv.Addr(), target)
+				if err != nil {
+					t.Fatalf("Dial: %v", err)
+				}
+				defer conn.Close()
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					conn.Write(make([]byte, size))
+					conn.(*net.TCPConn).CloseWrite()
+				}()
+			}
+			var total int64
+			for range tt.conns {
+				select {
+				case n := <-received:
+					total += n
+				case <-time.After(10 * time.Second):
+					t.Fatal("the target did not receive everything")
+				}
+			}
+			elapsed := time.Since(start)
+			wg.Wait()
+
+			if total != int64(size*tt.conns) {
+				t.Fatalf("received %d bytes, want %d", total, size*tt.conns)
+			}
+			if rate := float64(total-limit) / elapsed.Seconds(); rate > 1.1*limit {
+				t.Errorf("throughput past the burst = %.0f B/s, want at most 110%% of %d", rate, limit)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package gosocks_test
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// BenchmarkConnect measures the setup of a relayed connection: the TCP
+// connection to the proxy, the SOCKS5 handshake and the connection to the
+// target.
+func BenchmarkConnect(b *testing.B) {
+	srv := gosockstest.NewServer(b)
+	target := gosockstest.NewEchoServer(b)
+	for i := 0; i < b.N; i++ {
+		conn, err := client.Dial(srv.Addr(), target)
+		if err != nil {
+			b.Fatalf("Dial: %v", err)
+		}
+		conn.Close()
+	}
+}
+
+// BenchmarkLargeTransfer measures the throughput of the relay, sending 1 GiB
+// to a target which discards it.
+func BenchmarkLargeTransfer(b *testing.B) {
+	const size = 1 << 30
+	srv := gosockstest.NewServer(b)
+	target := gosockstest.NewTarget(b, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+	buf := make([]byte, 64*1024)
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn, err := client.Dial(srv.Addr(), target)
+		if err != nil {
+			b.Fatalf("Dial: %v", err)
+		}
+		for sent := 0; sent < size; sent += len(buf) {
+			_, err = conn.Write(buf)
+			if err != nil {
+				b.Fatalf("Write: %v", err)
+			}
+		}
+		// The target closes its side once it has read everything.
+		conn.(*net.TCPConn).CloseWrite()
+		_, err = io.Copy(io.Discard, conn)
+		if err != nil {
+			b.Fatalf("Read: %v", err)
+		}
+		conn.Close()
+	}
+}
+
+// BenchmarkManyShortConnections measures the connection setup overhead by
+// opening and closing 10,000 connections, one after the other, per
+// operation.
+func BenchmarkManyShortConnections(b *testing.B) {
+	const conns = 10000
+	srv := gosockstest.NewServer(b)
+	target := gosockstest.NewEchoServer(b)
+	msg := []byte("x")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < conns; j++ {
+			conn, err := client.Dial(srv.Addr(), target)
+			if err != nil {
+				b.Fatalf("Dial %d: %v", j, err)
+			}
+			_, err = conn.Write(msg)
+			if err == nil {
+				_, err = io.ReadFull(conn, msg)
+			}
+			if err != nil {
+				b.Fatalf("echo %d: %v", j, err)
+			}
+			conn.Close()
+		}
+	}
+	b.ReportMetric(float64(b.N*conns)/b.Elapsed().Seconds(), "conns/s")
+}
+
+// BenchmarkDNSResolution measures the requests for a host name, which the
+// proxy resolves with the system resolver or a DNSCache.
+func BenchmarkDNSResolution(b *testing.B) {
+	target := gosockstest.NewEchoServer(b)
+	_, port, _ := net.SplitHostPort(target)
+	target = net.JoinHostPort("localhost", port)
+
+	resolvers := []struct {
+		name     string
+		resolver gosocks.Resolver
+	}{
+		{"system", nil},
+		{"cache", gosocks.NewDNSCache(nil, time.Minute, 10)},
+	}
+	for _, r := range resolvers {
+		b.Run(r.name, func(b *testing.B) {
+			srv := gosockstest.NewServer(b, func(s *gosocks.Server) {
+				s.Resolver = r.resolver
+			})
+			for i := 0; i < b.N; i++ {
+				conn, err := client.Dial(srv.Addr(), target)
+				if err != nil {
+					b.Fatalf("Dial: %v", err)
+				}
+				conn.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package gosocks
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"time"
+
+	socks5 "github.com/glacjay/gosocks/client"
+)
+
+func (s *Server) handleBind(client net.Conn, expected *net.TCPAddr, sess *session) {
+	addr := client.RemoteAddr().String()
+
+	localIP := addrIP(client.LocalAddr())
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: localIP})
+	if err != nil {
+		sess.log.Error("Failed to listen for the BIND request", "remote_addr", addr, "error", err)
+		writeReply(client, socks5.ReplyGeneralFailure, nil, 0)
+		return
+	}
+	defer listener.Close()
+
+	bound := listener.Addr().(*net.TCPAddr)
+	err = writeReply(client, socks5.ReplySucceeded, bound.IP, bound.Port)
+	if err != nil {
+		sess.log.Warn("Failed to write the first reply", "remote_addr", addr, "error", err)
+		return
+	}
+	sess.log.Info("BIND listening", "remote_addr", addr, "bind_addr", bound.String())
+	client.SetDeadline(time.Time{})
+
+	listener.SetDeadline(time.Now().Add(s.bindTimeout()))
+	stop := context.AfterFunc(sess.ctx, func() {
+		listener.SetDeadline(time.Now())
+	})
+	// A client which hangs up ends the wait too. What it sends meanwhile,
+	// up to the size of br, stays there for the relay.
+	br := bufio.NewReader(client)
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		for br.Buffered() < br.Size() {
+			_, err := br.Peek(br.Buffered() + 1)
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				return
+			}
+			if err != nil {
+				listener.SetDeadline(time.Now())
+				return
+			}
+		}
+	}()
+	remote, err := listener.AcceptTCP()
+	stop()
+	client.SetReadDeadline(time.Now())
+	<-watched
+	client.SetReadDeadline(time.Time{})
+	if err != nil {
+		sess.outcome = outcomeBindFail
+		sess.log.Warn("Failed to accept the inbound connection", "remote_addr", addr, "error", err)
+		rep := socks5.ReplyGeneralFailure
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			rep = socks5.ReplyTTLExpired
+		}
+		writeReply(client, rep, nil, 0)
+		return
+	}
+	listener.Close()
+	defer remote.Close()
+
+	remoteAddress := remote.RemoteAddr().(*net.TCPAddr)
+	if !expected.IP.IsUnspecified() && !expected.IP.Equal(remoteAddress.IP) {
+		sess.log.Warn("Inbound connection from unexpected address", "remote_addr", addr, "target_addr", remoteAddress.String())
+		writeReply(client, socks5.ReplyNotAllowed, nil, 0)
+		return
+	}
+
+	err = writeReply(client, socks5.ReplySucceeded, remoteAddress.IP, remoteAddress.Port)
+	if err != nil {
+		sess.log.Warn("Failed to write the second reply", "remote_addr", addr, "error", err)
+		return
+	}
+	sess.log.Info("BIND accepted inbound connection", "remote_addr", addr, "target_addr", remoteAddress.String())
+
+	if br.Buffered() > 0 {
+		client = &bufferedConn{Conn: client, r: br}
+	}
+	s.relay(client, remote, sess)
+}
@@ -0,0 +1,85 @@
+package gosocks_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+func boundAddr(bound []byte) string {
+	ip := net.IP(bound[:len(bound)-2])
+	port := binary.BigEndian.Uint16(bound[len(bound)-2:])
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+}
+
+// TestBind checks that the inbound connection is relayed, with what the
+// client sent before it was accepted.
+func TestBind(t *testing.T) {
+	srv := gosockstest.NewServer(t)
+	conn := dialProxy(t, srv)
+	negotiate(t, conn, 0x00)
+	rep, _, bound := request(t, conn, 0x02, socksAddr(t, "127.0.0.1:0"))
+	if rep != 0x00 {
+		t.Fatalf("first reply = %#x, want 0x00", rep)
+	}
+	_, err := conn.Write([]byte("early"))
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	inbound, err := net.Dial("tcp", boundAddr(bound))
+	if err != nil {
+		t.Fatalf("Dial bound address: %v", err)
+	}
+	defer inbound.Close()
+	inbound.SetDeadline(time.Now().Add(5 * time.Second))
+	rep, _, from := readReply(t, conn)
+	if rep != 0x00 {
+		t.Fatalf("second reply = %#x, want 0x00", rep)
+	}
+	if got, want := boundAddr(from), inbound.LocalAddr().String(); got != want {
+		t.Errorf("second reply address = %v, want %v", got, want)
+	}
+
+	got := make([]byte, len("early"))
+	_, err = io.ReadFull(inbound, got)
+	if err != nil || string(got) != "early" {
+		t.Fatalf("inbound read %q, %v, want %q", got, err, "early")
+	}
+	_, err = inbound.Write([]byte("late"))
+	if err != nil {
+		t.Fatalf("Write inbound: %v", err)
+	}
+	got = make([]byte, len("late"))
+	_, err = io.ReadFull(conn, got)
+	if err != nil || string(got) != "late" {
+		t.Fatalf("client read %q, %v, want %q", got, err, "late")
+	}
+}
+
+// TestBindHangUp checks that the proxy stops waiting for the inbound
+// connection when the client hangs up, instead of waiting for BindTimeout.
+func TestBindHangUp(t *testing.T) {
+	srv := gosockstest.NewServer(t)
+	conn := dialProxy(t, srv)
+	negotiate(t, conn, 0x00)
+	rep, _, _ := request(t, conn, 0x02, socksAddr(t, "127.0.0.1:0"))
+	if rep != 0x00 {
+		t.Fatalf("first reply = %#x, want 0x00", rep)
+	}
+	conn.Write([]byte("early"))
+	conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := srv.Config.Shutdown(ctx)
+	if err != nil {
+		t.Fatalf("Shutdown: %v, the BIND is still waiting after the client hung up", err)
+	}
+}
@@ -0,0 +1,180 @@
+package gosocks
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by the dials to a destination whose circuit is
+// open. The SOCKS5 clients get the reply 0x04 (host unreachable).
+var ErrCircuitOpen = errors.New("gosocks: circuit open, the destination failed repeatedly")
+
+// A CircuitBreaker stops dialing a destination after it failed Failures
+// times in a row within Window. Once HalfOpenTimeout has elapsed, a single
+// dial is let through: its success closes the circuit, its failure opens it
+// again.
+type CircuitBreaker struct {
+	// Failures is how many consecutive failures open the circuit, 5 if
+	// zero.
+	Failures int
+
+	// Window is the time in which the failures must happen, 1 minute if
+	// zero.
+	Window time.Duration
+
+	// HalfOpenTimeout is how long the circuit stays open before a dial is
+	// tried again, 30 seconds if zero.
+	HalfOpenTimeout time.Duration
+
+	mu        sync.Mutex
+	circuits  map[string]*circuit
+	lastPrune time.Time
+}
+
+type circuit struct {
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time // zero while the circuit is closed
+	probing      bool
+}
+
+// CircuitInfo describes the circuit of a destination which failed recently,
+// as listed by the admin API.
+type CircuitInfo struct {
+	Addr     string    `json:"addr"`
+	State    string    `json:"state"`
+	Failures int       `json:"failures"`
+	OpenedAt time.Time `json:"opened_at,omitzero"`
+}
+
+func (b *CircuitBreaker) failures() int {
+	if b.Failures > 0 {
+		return b.Failures
+	}
+	return 5
+}
+
+func (b *CircuitBreaker) window() time.Duration {
+	if b.Window > 0 {
+		return b.Window
+	}
+	return time.Minute
+}
+
+func (b *CircuitBreaker) halfOpenTimeout() time.Duration {
+	if b.HalfOpenTimeout > 0 {
+		return b.HalfOpenTimeout
+	}
+	return 30 * time.Second
+}
+
+// allow reports whether addr may be dialed. When the circuit is open and the
+// timeout has elapsed, only the first caller is allowed, as the probe.
+func (b *CircuitBreaker) allow(addr string) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.circuits[addr]
+	if c == nil || c.openedAt.IsZero() {
+		return true
+	}
+	if c.probing || time.Since(c.openedAt) < b.halfOpenTimeout() {
+		return false
+	}
+	c.probing = true
+	return true
+}
+
+// record updates the circuit of addr with the result of a dial.
+func (b *CircuitBreaker) record(addr string, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.prune(now)
+	if err == nil {
+		delete(b.circuits, addr)
+		return
+	}
+
+	if b.circuits == nil {
+		b.circuits = make(map[string]*circuit)
+	}
+	c := b.circuits[addr]
+	if c == nil {
+		c = &circuit{}
+		b.circuits[addr] = c
+	}
+	if !c.openedAt.IsZero() {
+		// The probe failed.
+		c.failures++
+		c.openedAt = now
+		c.probing = false
+		return
+	}
+	if c.failures == 0 || now.Sub(c.firstFailure) > b.window() {
+		c.failures = 0
+		c.firstFailure = now
+	}
+	c.failures++
+	if c.failures >= b.failures() {
+		c.openedAt = now
+	}
+}
+
+// abort releases the probe of addr, if any, whose dial was canceled before
+// it could tell whether the destination recovered.
+func (b *CircuitBreaker) abort(addr string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c := b.circuits[addr]; c != nil {
+		c.probing = false
+	}
+}
+
+// prune forgets the closed circuits whose failures are older than the
+// window, and the open ones nobody tried since, at most once per window.
+func (b *CircuitBreaker) prune(now time.Time) {
+	if now.Sub(b.lastPrune) < b.window() {
+		return
+	}
+	b.lastPrune = now
+	for addr, c := range b.circuits {
+		if c.openedAt.IsZero() && now.Sub(c.firstFailure) > b.window() ||
+			!c.openedAt.IsZero() && !c.probing && now.Sub(c.openedAt) > b.halfOpenTimeout()+b.window() {
+			delete(b.circuits, addr)
+		}
+	}
+}
+
+// Circuits lists the destinations which failed recently, sorted by address.
+// Their state is "closed", "open" or "half_open".
+func (b *CircuitBreaker) Circuits() []CircuitInfo {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	infos := make([]CircuitInfo, 0, len(b.circuits))
+	for addr, c := range b.circuits {
+		info := CircuitInfo{Addr: addr, State: "closed", Failures: c.failures, OpenedAt: c.openedAt}
+		switch {
+		case c.openedAt.IsZero():
+		case c.probing || time.Since(c.openedAt) >= b.halfOpenTimeout():
+			info.State = "half_open"
+		default:
+			info.State = "open"
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Addr < infos[j].Addr
+	})
+	return infos
+}
@@ -0,0 +1,76 @@
+package gosocks_test
+
+import (
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// countingDialer counts its dials, which are refused while fail is set and
+// connect to an echo server otherwise.
+type countingDialer struct {
+	dials atomic.Int32
+	fail  atomic.Bool
+}
+
+func (d *countingDialer) Dial(network, addr string) (net.Conn, error) {
+	d.dials.Add(1)
+	if d.fail.Load() {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: syscall.ECONNREFUSED}
+	}
+	return echoPipe(), nil
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	dialer := &countingDialer{}
+	dialer.fail.Store(true)
+	breaker := &gosocks.CircuitBreaker{Failures: 3, HalfOpenTimeout: 100 * time.Millisecond}
+	srv := gosockstest.NewServer(t, gosockstest.WithDialer(dialer), func(s *gosocks.Server) {
+		s.Breaker = breaker
+	})
+	target := socksAddr(t, "192.0.2.1:80")
+	connect := func(t *testing.T, rep byte, dials int32) {
+		t.Helper()
+		conn := dialProxy(t, srv)
+		negotiate(t, conn, 0x00)
+		if got, _, _ := request(t, conn, 0x01, target); got != rep {
+			t.Errorf("reply = %#x, want %#x", got, rep)
+		}
+		if got := dialer.dials.Load(); got != dials {
+			t.Errorf("%d dials, want %d", got, dials)
+		}
+	}
+	state := func(t *testing.T, want string) {
+		t.Helper()
+		circuits := breaker.Circuits()
+		if len(circuits) != 1 || circuits[0].State != want {
+			t.Errorf("circuits = %+v, want one %s", circuits, want)
+		}
+	}
+
+	for i := int32(1); i <= 3; i++ {
+		connect(t, 0x05, i)
+	}
+	state(t, "open")
+	connect(t, 0x04, 3)
+
+	// The probe fails and opens the circuit again.
+	time.Sleep(150 * time.Millisecond)
+	state(t, "half_open")
+	connect(t, 0x05, 4)
+	connect(t, 0x04, 4)
+
+	// The probe succeeds and closes the circuit.
+	time.Sleep(150 * time.Millisecond)
+	dialer.fail.Store(false)
+	connect(t, 0x00, 5)
+	if circuits := breaker.Circuits(); len(circuits) != 0 {
+		t.Errorf("circuits = %+v, want none", circuits)
+	}
+	connect(t, 0x00, 6)
+}
@@ -0,0 +1,71 @@
+package gosocks
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+)
+
+// A CertAuthenticator authenticates the SOCKS5 clients by the certificate
+// they presented during the TLS handshake, which verified it against the
+// ClientCAs of the TLS configuration, and returns the identity the client is
+// known by.
+type CertAuthenticator interface {
+	AuthenticateCert(cert *x509.Certificate) (identity string, err error)
+}
+
+var ErrNoCertIdentity = errors.New("gosocks: the client certificate has no identity")
+
+// MTLSAuthenticator is a CertAuthenticator whose identity is the common name
+// of the subject of the certificate, or its first email address if UseEmail
+// is set. The other one is used if the preferred one is empty.
+type MTLSAuthenticator struct {
+	UseEmail bool
+}
+
+func (a MTLSAuthenticator) AuthenticateCert(cert *x509.Certificate) (string, error) {
+	var email string
+	if len(cert.EmailAddresses) > 0 {
+		email = cert.EmailAddresses[0]
+	}
+	identities := []string{cert.Subject.CommonName, email}
+	if a.UseEmail {
+		identities[0], identities[1] = identities[1], identities[0]
+	}
+	for _, identity := range identities {
+		if identity != "" {
+			return identity, nil
+		}
+	}
+	return "", ErrNoCertIdentity
+}
+
+// authenticateCert authenticates the client of sess with s.CertAuth if it
+// presented a verified certificate.
+func (s *Server) authenticateCert(client net.Conn, sess *session) {
+	if s.CertAuth == nil {
+		return
+	}
+	conn, ok := client.(interface{ ConnectionState() tls.ConnectionState })
+	if !ok {
+		return
+	}
+	state := conn.ConnectionState()
+	if len(state.VerifiedChains) == 0 {
+		return
+	}
+	cert := state.VerifiedChains[0][0]
+	identity, err := s.CertAuth.AuthenticateCert(cert)
+	if err != nil {
+		sess.log.Warn("Certificate authentication failed", "remote_addr", client.RemoteAddr().String(), "subject", cert.Subject.String(), "error", err)
+		s.publish(sess, func(info EventInfo) Event {
+			return AuthFailed{EventInfo: info, Method: "certificate"}
+		})
+		return
+	}
+	sess.log.Info("Authenticated by certificate", "remote_addr", client.RemoteAddr().String(), "user", identity)
+	sess.identity = identity
+	sess.certAuthenticated = true
+	s.publishAuth(sess, "certificate", true)
+}
@@ -0,0 +1,159 @@
+package gosocks_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"log/slog"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// testCA issues the certificates of a test, signed by its own key.
+type testCA struct {
+	t      *testing.T
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	serial int64
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	ca := &testCA{t: t}
+	ca.cert, ca.key = ca.issue(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "gosocks test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	return ca
+}
+
+// issue signs template with the key of the CA, or with its own key if the
+// CA has none yet.
+func (ca *testCA) issue(template *x509.Certificate) (*x509.Certificate, *ecdsa.PrivateKey) {
+	ca.t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		ca.t.Fatalf("GenerateKey: %v", err)
+	}
+	ca.serial++
+	template.SerialNumber = big.NewInt(ca.serial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	parent, signer := template, key
+	if ca.cert != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		ca.t.Fatalf("CreateCertificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		ca.t.Fatalf("ParseCertificate: %v", err)
+	}
+	return cert, key
+}
+
+func (ca *testCA) tlsCert(template *x509.Certificate) tls.Certificate {
+	cert, key := ca.issue(template)
+	return tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key}
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// TestCertAuth checks that a client with a certificate of the CA gets the
+// method 0x00 although passwords are required, and is known by the identity
+// of its certificate in the logs and the context of the connection.
+func TestCertAuth(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert := ca.tlsCert(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "proxy"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	clientUsage := []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	tests := []struct {
+		name     string
+		useEmail bool
+		cert     *x509.Certificate
+		identity string
+	}{
+		{"common name", false, &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}, EmailAddresses: []string{"alice@example.com"}, ExtKeyUsage: clientUsage}, "alice"},
+		{"email", true, &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}, EmailAddresses: []string{"alice@example.com"}, ExtKeyUsage: clientUsage}, "alice@example.com"},
+		{"email without common name", false, &x509.Certificate{EmailAddresses: []string{"bob@example.com"}, ExtKeyUsage: clientUsage}, "bob@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs syncBuffer
+			identities := make(chan string, 1)
+			srv := gosockstest.NewServer(t, gosockstest.WithAuth("user", "pass"), func(s *gosocks.Server) {
+				s.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+				s.CertAuth = gosocks.MTLSAuthenticator{UseEmail: tt.useEmail}
+				s.Hook = hookFunc(func(ctx context.Context, info gosocks.ConnInfo) error {
+					identities <- gosocks.Identity(ctx)
+					return nil
+				})
+			})
+			// The TLS layer is added in front of the listener of srv.
+			l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+				Certificates: []tls.Certificate{serverCert},
+				ClientCAs:    ca.pool(),
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+			})
+			if err != nil {
+				t.Fatalf("Listen: %v", err)
+			}
+			defer l.Close()
+			go func() {
+				for {
+					conn, err := l.Accept()
+					if err != nil {
+						return
+					}
+					go srv.Config.ServeConn(context.Background(), conn)
+				}
+			}()
+
+			conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{
+				RootCAs:      ca.pool(),
+				Certificates: []tls.Certificate{ca.tlsCert(tt.cert)},
+			})
+			if err != nil {
+				t.Fatalf("tls.Dial: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			if method := negotiate(t, conn, 0x00, 0x02); method != 0x00 {
+				t.Fatalf("method = %#x, want 0x00 for a client with a certificate", method)
+			}
+			rep, _, _ := request(t, conn, 0x01, socksAddr(t, gosockstest.NewEchoServer(t)))
+			if rep != 0x00 {
+				t.Fatalf("reply = %#x, want 0x00", rep)
+			}
+			echo(t, conn, "hello")
+
+			if got := <-identities; got != tt.identity {
+				t.Errorf("Identity = %q, want %q", got, tt.identity)
+			}
+			if want := "user=" + tt.identity; !strings.Contains(logs.String(), want) {
+				t.Errorf("the logs do not contain %s:\n%s", want, logs.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package gosocks
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// A CertReloader serves a certificate and key pair which it loads again when
+// their files change, so that the new TLS handshakes use the new certificate
+// while the established connections keep the old one. Set its
+// GetCertificate method as the one of a tls.Config.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// NewCertReloader loads the pair for the first time.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	_, err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Reload loads the pair again, and reports whether it changed. The current
+// certificate is kept if the files cannot be loaded.
+func (r *CertReloader) Reload() (bool, error) {
+	certPEM, err := os.ReadFile(r.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyPEM, err := os.ReadFile(r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	r.mu.RLock()
+	same := bytes.Equal(certPEM, r.certPEM) && bytes.Equal(keyPEM, r.keyPEM)
+	r.mu.RUnlock()
+	if same {
+		return false, nil
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.certPEM, r.keyPEM = &cert, certPEM, keyPEM
+	return true, nil
+}
+
+// Watch reloads the pair when fsnotify reports a change in the directories
+// of its files, and also every interval if it is not zero, until ctx is
+// done. Polling is the only way if fsnotify is not available.
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration, log Logger) {
+	var events chan fsnotify.Event
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		defer watcher.Close()
+		for _, dir := range []string{filepath.Dir(r.certFile), filepath.Dir(r.keyFile)} {
+			if e := watcher.Add(dir); e != nil {
+				err = e
+			}
+		}
+		events = watcher.Events
+	}
+	if err != nil {
+		log.Warn("Failed to watch the TLS certificate files", "cert_file", r.certFile, "error", err)
+	}
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			// Renaming the files in place, as most certificate tools do, is
+			// seen on the directory.
+			name := filepath.Clean(event.Name)
+			if name != filepath.Clean(r.certFile) && name != filepath.Clean(r.keyFile) {
+				continue
+			}
+		case <-tick:
+		}
+		changed, err := r.Reload()
+		if err != nil {
+			log.Warn("Failed to reload the TLS certificate", "cert_file", r.certFile, "error", err)
+		} else if changed {
+			log.Info("Reloaded the TLS certificate", "cert_file", r.certFile)
+		}
+	}
+}
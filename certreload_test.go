@@ -0,0 +1,113 @@
+package gosocks_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+)
+
+// writeCertFiles writes cert and its key as PEM files in dir, replacing the
+// ones already there by renaming, as the certificate tools do.
+func writeCertFiles(t *testing.T, dir string, cert tls.Certificate) (certFile, keyFile string) {
+	t.Helper()
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	files := []struct {
+		path  string
+		block *pem.Block
+	}{
+		{keyFile, &pem.Block{Type: "PRIVATE KEY", Bytes: key}},
+		{certFile, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}},
+	}
+	for _, f := range files {
+		path := f.path
+		err := os.WriteFile(path+".tmp", pem.EncodeToMemory(f.block), 0600)
+		if err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		err = os.Rename(path+".tmp", path)
+		if err != nil {
+			t.Fatalf("Rename: %v", err)
+		}
+	}
+	return certFile, keyFile
+}
+
+// TestCertReloader checks that once the certificate files are replaced, the
+// new TLS connections get the new certificate, while an established one
+// keeps working.
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	oldCert, newCert := selfSignedCert(t), selfSignedCert(t)
+	certFile, keyFile := writeCertFiles(t, dir, oldCert)
+	reloader, err := gosocks.NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertReloader: %v", err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Watch(ctx, 0, logger)
+
+	s := &gosocks.Server{
+		Listeners: []gosocks.Listener{{Addr: "127.0.0.1:0"}},
+		TLSConfig: &tls.Config{GetCertificate: reloader.GetCertificate},
+		Logger:    logger,
+	}
+	go s.ListenAndServe()
+	defer func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		s.Shutdown(ctx)
+	}()
+	if err := s.WaitReady(5 * time.Second); err != nil {
+		t.Fatalf("WaitReady: %v", err)
+	}
+	addr := s.ListenAddrs()[0].String()
+	dial := func() *tls.Conn {
+		t.Helper()
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("tls.Dial: %v", err)
+		}
+		return conn
+	}
+
+	established := dial()
+	defer established.Close()
+	if got := established.ConnectionState().PeerCertificates[0].Raw; !bytes.Equal(got, oldCert.Certificate[0]) {
+		t.Fatal("the first connection did not get the first certificate")
+	}
+
+	writeCertFiles(t, dir, newCert)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn := dial()
+		got := conn.ConnectionState().PeerCertificates[0].Raw
+		conn.Close()
+		if bytes.Equal(got, newCert.Certificate[0]) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the new connections still get the old certificate")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The established connection was not dropped.
+	established.SetDeadline(time.Now().Add(5 * time.Second))
+	negotiate(t, established, 0x00)
+}
@@ -0,0 +1,173 @@
+// Package client implements the client side of the SOCKS5 protocol.
+package client
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// Dial connects to targetAddr through the SOCKS5 proxy at proxyAddr, without
+// authentication.
+func Dial(proxyAddr, targetAddr string) (net.Conn, error) {
+	return DialWithAuth(proxyAddr, "", "", targetAddr)
+}
+
+// DialWithAuth connects to targetAddr through the SOCKS5 proxy at proxyAddr,
+// authenticating with username and password if the proxy asks for it.
+func DialWithAuth(proxyAddr, username, password, targetAddr string) (net.Conn, error) {
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	err = Handshake(conn, username, password, targetAddr)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("socks5 proxy %s: %w", proxyAddr, err)
+	}
+	return conn, nil
+}
+
+// A Dialer connects through a SOCKS5 proxy. Its Dial method can be plugged
+// into http.Transport.Dial.
+type Dialer struct {
+	ProxyAddr string
+	Username  string
+	Password  string
+}
+
+func (d *Dialer) Dial(network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("socks5: unsupported network '%s'", network)
+	}
+	return DialWithAuth(d.ProxyAddr, d.Username, d.Password, addr)
+}
+
+// Handshake performs the SOCKS5 negotiation on conn, which must be connected
+// to the proxy, and asks it to CONNECT to targetAddr. The username/password
+// method is offered if username is not empty. After it returns successfully,
+// conn transparently forwards reads and writes to the target. If the proxy
+// refuses the authentication or the request, the error is a *ReplyError.
+func Handshake(conn net.Conn, username, password, targetAddr string) error {
+	host, portStr, err := net.SplitHostPort(targetAddr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 0xFFFF {
+		return fmt.Errorf("invalid port '%s'", portStr)
+	}
+
+	methods := []byte{0x05, 0x01, 0x00}
+	if username != "" {
+		methods = []byte{0x05, 0x02, 0x00, 0x02}
+	}
+	_, err = conn.Write(methods)
+	if err != nil {
+		return err
+	}
+
+	var versionMethod [2]byte
+	_, err = io.ReadFull(conn, versionMethod[:])
+	if err != nil {
+		return err
+	}
+	if versionMethod[0] != 0x05 {
+		return fmt.Errorf("unexpected version: %X", versionMethod[0])
+	}
+	switch versionMethod[1] {
+	case 0x00:
+	case 0x02:
+		if username == "" {
+			return fmt.Errorf("username/password method selected without credentials")
+		}
+		err = authenticate(conn, username, password)
+		if err != nil {
+			return err
+		}
+	default:
+		return &ReplyError{Code: ReplyNotAllowed, Reason: "no acceptable authentication method"}
+	}
+
+	request := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			request = append(request, 0x01)
+			request = append(request, ip4...)
+		} else {
+			request = append(request, 0x04)
+			request = append(request, ip.To16()...)
+		}
+		request = append(request, byte(port>>8), byte(port%256))
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long: %s", host)
+		}
+		request = append(request, 0x03, byte(len(host)))
+		request = append(request, host...)
+		request = append(request, byte(port>>8), byte(port%256))
+	}
+	_, err = conn.Write(request)
+	if err != nil {
+		return err
+	}
+
+	var replyHeader [4]byte
+	_, err = io.ReadFull(conn, replyHeader[:])
+	if err != nil {
+		return err
+	}
+	if code := ReplyCode(replyHeader[1]); code != ReplySucceeded {
+		return &ReplyError{Code: code}
+	}
+	return skipAddr(conn, replyHeader[3])
+}
+
+func authenticate(conn net.Conn, username, password string) error {
+	if len(username) > 255 || len(password) > 255 {
+		return fmt.Errorf("username or password too long")
+	}
+	request := []byte{0x01, byte(len(username))}
+	request = append(request, username...)
+	request = append(request, byte(len(password)))
+	request = append(request, password...)
+	_, err := conn.Write(request)
+	if err != nil {
+		return err
+	}
+
+	var reply [2]byte
+	_, err = io.ReadFull(conn, reply[:])
+	if err != nil {
+		return err
+	}
+	if reply[1] != 0x00 {
+		return &ReplyError{Code: ReplyNotAllowed, Reason: "authentication failed"}
+	}
+	return nil
+}
+
+// skipAddr reads and discards the BND.ADDR and BND.PORT of a reply.
+func skipAddr(r io.Reader, addrType byte) error {
+	var n int
+	switch addrType {
+	case 0x01:
+		n = net.IPv4len + 2
+	case 0x04:
+		n = net.IPv6len + 2
+	case 0x03:
+		var hostLen [1]byte
+		_, err := io.ReadFull(r, hostLen[:])
+		if err != nil {
+			return err
+		}
+		n = int(hostLen[0]) + 2
+	default:
+		return fmt.Errorf("unknown address type: %X", addrType)
+	}
+	_, err := io.ReadFull(r, make([]byte, n))
+	return err
+}
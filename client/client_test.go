@@ -0,0 +1,117 @@
+package client_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+func echo(t *testing.T, conn net.Conn, message string) {
+	t.Helper()
+	_, err := conn.Write([]byte(message))
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got := make([]byte, len(message))
+	_, err = io.ReadFull(conn, got)
+	if err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if string(got) != message {
+		t.Fatalf("echoed %q, want %q", got, message)
+	}
+}
+
+func TestDial(t *testing.T) {
+	srv := gosockstest.NewServer(t)
+	target := gosockstest.NewEchoServer(t)
+
+	conn, err := client.Dial(srv.Addr(), target)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	echo(t, conn, "hello")
+}
+
+func TestDialerHostName(t *testing.T) {
+	srv := gosockstest.NewServer(t)
+	_, port, _ := net.SplitHostPort(gosockstest.NewEchoServer(t))
+
+	d := &client.Dialer{ProxyAddr: srv.Addr()}
+	conn, err := d.Dial("tcp", net.JoinHostPort("localhost", port))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	echo(t, conn, "hello")
+
+	_, err = d.Dial("udp", net.JoinHostPort("localhost", port))
+	if err == nil {
+		t.Fatal("Dial udp succeeded")
+	}
+}
+
+func TestDialWithAuth(t *testing.T) {
+	srv := gosockstest.NewServer(t, gosockstest.WithAuth("alice", "secret"))
+	target := gosockstest.NewEchoServer(t)
+
+	conn, err := client.DialWithAuth(srv.Addr(), "alice", "secret", target)
+	if err != nil {
+		t.Fatalf("DialWithAuth: %v", err)
+	}
+	defer conn.Close()
+	echo(t, conn, "hello")
+
+	tests := []struct {
+		name, username, password, reason string
+	}{
+		{"wrong password", "alice", "guess", "authentication failed"},
+		{"no credentials", "", "", "no acceptable authentication method"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.DialWithAuth(srv.Addr(), tt.username, tt.password, target)
+			var replyErr *client.ReplyError
+			if !errors.As(err, &replyErr) {
+				t.Fatalf("DialWithAuth error = %v, want a *ReplyError", err)
+			}
+			if replyErr.Code != client.ReplyNotAllowed || replyErr.Reason != tt.reason {
+				t.Errorf("ReplyError = %+v, want code %v and reason %q", replyErr, client.ReplyNotAllowed, tt.reason)
+			}
+		})
+	}
+}
+
+func TestDialReplyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code client.ReplyCode
+	}{
+		{syscall.ECONNREFUSED, client.ReplyConnectionRefused},
+		{syscall.ENETUNREACH, client.ReplyNetworkUnreachable},
+		{syscall.EHOSTUNREACH, client.ReplyHostUnreachable},
+		{errors.New("boom"), client.ReplyGeneralFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code.String(), func(t *testing.T) {
+			srv := gosockstest.NewServer(t, gosockstest.WithDialer(gosockstest.DialerFunc(func(network, addr string) (net.Conn, error) {
+				return nil, &net.OpError{Op: "dial", Net: network, Err: tt.err}
+			})))
+
+			_, err := client.Dial(srv.Addr(), "192.0.2.1:80")
+			var replyErr *client.ReplyError
+			if !errors.As(err, &replyErr) {
+				t.Fatalf("Dial error = %v, want a *ReplyError", err)
+			}
+			if replyErr.Code != tt.code {
+				t.Errorf("reply code = %v, want %v", replyErr.Code, tt.code)
+			}
+		})
+	}
+}
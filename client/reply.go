@@ -0,0 +1,57 @@
+package client
+
+import (
+	"fmt"
+)
+
+// A ReplyCode is the REP field of a SOCKS5 reply (RFC 1928, section 6).
+type ReplyCode uint8
+
+const (
+	ReplySucceeded               ReplyCode = 0x00
+	ReplyGeneralFailure          ReplyCode = 0x01
+	ReplyNotAllowed              ReplyCode = 0x02
+	ReplyNetworkUnreachable      ReplyCode = 0x03
+	ReplyHostUnreachable         ReplyCode = 0x04
+	ReplyConnectionRefused       ReplyCode = 0x05
+	ReplyTTLExpired              ReplyCode = 0x06
+	ReplyCommandNotSupported     ReplyCode = 0x07
+	ReplyAddressTypeNotSupported ReplyCode = 0x08
+)
+
+var replyNames = [...]string{
+	ReplySucceeded:               "succeeded",
+	ReplyGeneralFailure:          "general SOCKS server failure",
+	ReplyNotAllowed:              "connection not allowed by ruleset",
+	ReplyNetworkUnreachable:      "network unreachable",
+	ReplyHostUnreachable:         "host unreachable",
+	ReplyConnectionRefused:       "connection refused",
+	ReplyTTLExpired:              "TTL expired",
+	ReplyCommandNotSupported:     "command not supported",
+	ReplyAddressTypeNotSupported: "address type not supported",
+}
+
+func (c ReplyCode) String() string {
+	if int(c) < len(replyNames) {
+		return replyNames[c]
+	}
+	return fmt.Sprintf("unassigned reply code %#02x", uint8(c))
+}
+
+// ReplyError is returned when the proxy refuses a request. Use errors.As to
+// get it from the errors of Dial and Handshake.
+type ReplyError struct {
+	Code ReplyCode
+
+	// Reason explains a refusal which is not a SOCKS5 reply: the proxy
+	// accepted none of the offered methods, or rejected the credentials.
+	// Code is then ReplyNotAllowed.
+	Reason string
+}
+
+func (e *ReplyError) Error() string {
+	if e.Reason != "" {
+		return "socks5: " + e.Reason
+	}
+	return "socks5: " + e.Code.String()
+}
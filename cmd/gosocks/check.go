@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/glacjay/gosocks"
+)
+
+// runCheck implements 'gosocks check host:port': it connects to target
+// through the proxy at proxyURL, sends an HTTP HEAD request, over TLS if
+// useTLS is set, and prints "OK (NNNms)" if an HTTP response comes back. It
+// prints its failures itself and returns errExit then.
+func runCheck(args []string, proxyURL string, useTLS bool, timeout time.Duration) error {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: gosocks [flags] check host:port")
+		return errExit
+	}
+	start := time.Now()
+	err := check(args[0], proxyURL, useTLS, timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAILED: %v\n", err)
+		return errExit
+	}
+	fmt.Printf("OK (%dms)\n", time.Since(start).Milliseconds())
+	return nil
+}
+
+func check(target, proxyURL string, useTLS bool, timeout time.Duration) error {
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		return err
+	}
+	dialer, err := gosocks.ParseUpstream(proxyURL, gosocks.DirectDialer{Timeout: timeout})
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var conn net.Conn
+	if cd, ok := dialer.(gosocks.ContextDialer); ok {
+		conn, err = cd.DialContext(ctx, "tcp", target)
+	} else {
+		conn, err = dialer.Dial("tcp", target)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if useTLS {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+		err = tlsConn.Handshake()
+		if err != nil {
+			return fmt.Errorf("TLS handshake with %s: %w", target, err)
+		}
+		conn = tlsConn
+	}
+
+	_, err = fmt.Fprintf(conn, "HEAD / HTTP/1.0\r\nHost: %s\r\n\r\n", host)
+	if err != nil {
+		return err
+	}
+	status, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("reading the response of %s: %w", target, err)
+	}
+	if !strings.HasPrefix(status, "HTTP/") {
+		return errors.New("the response of " + target + " is not HTTP")
+	}
+	return nil
+}
+
+// checkProxyURL returns the default -check-proxy, the first TCP listener of
+// the server on the loopback interface.
+func checkProxyURL(listeners []gosocks.Listener, port int) string {
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	for _, lc := range listeners {
+		if strings.Contains(lc.Addr, "://") {
+			continue
+		}
+		host, port, err := net.SplitHostPort(lc.Addr)
+		if err != nil {
+			continue
+		}
+		if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+			host = "127.0.0.1"
+		}
+		addr = net.JoinHostPort(host, port)
+		break
+	}
+	return "socks5://" + addr
+}
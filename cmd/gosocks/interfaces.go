@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"text/tabwriter"
+)
+
+// listInterfaces prints the table of -list-interfaces, one line per address
+// of each interface. The ADDRESS column is what -bind-addr takes.
+func listInterfaces(w io.Writer) error {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tFLAGS\tADDRESS\tNETWORK")
+	for _, iface := range ifaces {
+		flags := strings.ToUpper(strings.ReplaceAll(iface.Flags.String(), "|", ","))
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return fmt.Errorf("%s: %w", iface.Name, err)
+		}
+		if len(addrs) == 0 {
+			fmt.Fprintf(tw, "%s\t%s\t-\t-\n", iface.Name, flags)
+		}
+		for _, addr := range addrs {
+			ip, network, err := net.ParseCIDR(addr.String())
+			if err != nil {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t-\n", iface.Name, flags, addr)
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", iface.Name, flags, ip, network)
+		}
+	}
+	return tw.Flush()
+}
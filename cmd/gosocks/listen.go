@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/glacjay/gosocks"
+)
+
+// parseListen parses a -listen value: a "[host]:port" address or a
+// "unix:///path" Unix domain socket, optionally followed by a query string
+// giving the policy of that address, as in
+// "[::]:1080?auth=users.txt&allow-client=10.0.0.0/8&deny-target=*.internal".
+// The auth files are reloaded on SIGHUP.
+func parseListen(value string) (gosocks.Listener, error) {
+	addr, rawQuery, _ := strings.Cut(value, "?")
+	lc := gosocks.Listener{Addr: addr}
+	if rawQuery == "" {
+		return lc, nil
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return lc, fmt.Errorf("invalid options in '%s': %v", value, err)
+	}
+	var acl *gosocks.ACL
+	for key, values := range query {
+		for _, v := range values {
+			switch key {
+			case "auth":
+				credentials, err := gosocks.NewCredentialsFile(v)
+				if err != nil {
+					return lc, err
+				}
+				lc.Auth = credentials
+			case "allow-client", "deny-target":
+				if acl == nil {
+					acl = &gosocks.ACL{}
+				}
+				add := acl.AddAllowClient
+				if key == "deny-target" {
+					add = acl.AddDenyTarget
+				}
+				err = add(v)
+				if err != nil {
+					return lc, err
+				}
+			default:
+				return lc, fmt.Errorf("unknown option '%s' in '%s'", key, value)
+			}
+		}
+	}
+	lc.ACL = acl
+	return lc, nil
+}
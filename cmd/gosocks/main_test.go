@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// TestMain runs main with the arguments of the test binary instead of the
+// tests when GOSOCKS_TEST_MAIN is set, so that the tests may check its exit
+// status.
+func TestMain(m *testing.M) {
+	if os.Getenv("GOSOCKS_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs main with args in a new process, and returns its exit status
+// and its output.
+func runMain(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "GOSOCKS_TEST_MAIN=1")
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), string(out)
+	}
+	if err != nil {
+		t.Fatalf("running main: %v", err)
+	}
+	return 0, string(out)
+}
+
+// TestDryRun checks that -dry-run exits with status 1, naming the invalid
+// field, when the config file is malformed, and prints "Config OK" when it
+// is not.
+func TestDryRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		status int
+		output string
+	}{
+		{"valid", "[server]\nlisten = [\"127.0.0.1:0\"]\n", 0, "Config OK"},
+		{"invalid CIDR", "[acl]\nallow_clients = [\"10.0.0.0/33\"]\n", 1, "allow-client"},
+		{"wrong type", "[server]\nport = \"1080\"\n", 1, "server.port"},
+		{"unknown key", "[dns]\nttl_seconds = 60\n", 1, "dns.ttl_seconds"},
+		{"invalid listen address", "[server]\nlisten = [\"127.0.0.1:http2\"]\n", 1, "127.0.0.1:http2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "gosocks.toml")
+			err := os.WriteFile(path, []byte(tt.config), 0644)
+			if err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+			status, out := runMain(t, "-dry-run", "-config", path)
+			if status != tt.status || !strings.Contains(out, tt.output) {
+				t.Errorf("-dry-run exited with %d:\n%s\nwant %d and %q", status, out, tt.status, tt.output)
+			}
+		})
+	}
+}
+
+// TestRunPortInUse checks that run returns an error when its port is taken,
+// and that it may run again.
+func TestRunPortInUse(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+
+	for i := range 2 {
+		err := run([]string{"-listen", l.Addr().String(), "-log-level", "error"})
+		if !errors.Is(err, syscall.EADDRINUSE) {
+			t.Fatalf("run %d = %v, want the address in use", i, err)
+		}
+	}
+}
+
+// TestReloadAllowlist checks that a reloaded allowlist applies to the
+// listeners with an ACL of their own.
+func TestReloadAllowlist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allowlist.txt")
+	err := os.WriteFile(path, []byte("127.0.0.1\n"), 0644)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	lc, err := parseListen("127.0.0.1:0?deny-target=192.0.2.1")
+	if err != nil {
+		t.Fatalf("parseListen: %v", err)
+	}
+	acl := &gosocks.ACL{}
+	err = acl.LoadAllowlist(path)
+	if err != nil {
+		t.Fatalf("LoadAllowlist: %v", err)
+	}
+	server := &gosocks.Server{
+		Listeners: []gosocks.Listener{lc},
+		ACL:       acl,
+		Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	go server.ListenAndServe()
+	defer func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		server.Shutdown(ctx)
+	}()
+	err = server.WaitReady(5 * time.Second)
+	if err != nil {
+		t.Fatalf("WaitReady: %v", err)
+	}
+	addr := server.ListenAddrs()[0].String()
+	target := gosockstest.NewEchoServer(t)
+
+	conn, err := client.Dial(addr, target)
+	if err != nil {
+		t.Fatalf("Dial of a listed target: %v", err)
+	}
+	conn.Close()
+
+	err = os.WriteFile(path, []byte("10.0.0.0/8\n"), 0644)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	signals := make(chan os.Signal, 1)
+	signals <- syscall.SIGHUP
+	close(signals)
+	reloadAllowlist(server, path, signals)
+
+	conn, err = client.Dial(addr, target)
+	if err == nil {
+		conn.Close()
+		t.Fatal("Dial of a target no longer listed succeeded")
+	}
+}
+
+// lineWriter sends what is written to it, the lines of a slog handler, on
+// the channel.
+type lineWriter chan string
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+// TestReloadCredentials checks that after the credentials file is rewritten
+// and the process gets SIGHUP, the new credentials are accepted for the new
+// connections, that an established one keeps working, and that an invalid
+// file keeps the credentials.
+func TestReloadCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.txt")
+	write := func(content string) {
+		t.Helper()
+		err := os.WriteFile(path, []byte(content), 0600)
+		if err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	write("alice:old\n")
+	credentials, err := gosocks.NewCredentialsFile(path)
+	if err != nil {
+		t.Fatalf("NewCredentialsFile: %v", err)
+	}
+	logs := make(lineWriter, 16)
+	srv := gosockstest.NewServer(t, func(s *gosocks.Server) {
+		s.Auth = credentials
+	})
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go reloadCredentials(slog.New(slog.NewTextHandler(logs, nil)), []*gosocks.CredentialsFile{credentials}, hup)
+	target := gosockstest.NewEchoServer(t)
+	dial := func(password string) error {
+		conn, err := client.DialWithAuth(srv.Addr(), "alice", password, target)
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+	reload := func(want string) {
+		t.Helper()
+		err := syscall.Kill(os.Getpid(), syscall.SIGHUP)
+		if err != nil {
+			t.Fatalf("Kill: %v", err)
+		}
+		select {
+		case line := <-logs:
+			if !strings.Contains(line, want) {
+				t.Fatalf("logged %q after SIGHUP, want %q", line, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reload after SIGHUP")
+		}
+	}
+
+	established, err := client.DialWithAuth(srv.Addr(), "alice", "old", target)
+	if err != nil {
+		t.Fatalf("DialWithAuth: %v", err)
+	}
+	defer established.Close()
+
+	write("alice:new\n")
+	reload("Reloaded the credentials")
+	if err := dial("new"); err != nil {
+		t.Errorf("Dial with the new password: %v", err)
+	}
+	if err := dial("old"); err == nil {
+		t.Error("Dial with the old password succeeded after the reload")
+	}
+	established.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := established.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if _, err := io.ReadFull(established, make([]byte, 5)); err != nil {
+		t.Errorf("the established connection was disrupted: %v", err)
+	}
+
+	write("alice\n")
+	reload("Failed to reload the credentials")
+	if err := dial("new"); err != nil {
+		t.Errorf("Dial after an invalid file: %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// newPusher returns the pusher of -pushgateway, which groups the metrics by
+// job and by the host name as instance, so that several instances of the
+// same job do not overwrite each other.
+func newPusher(url, job string) *push.Pusher {
+	pusher := push.New(url, job).Gatherer(prometheus.DefaultGatherer)
+	if hostname, err := os.Hostname(); err == nil {
+		pusher = pusher.Grouping("instance", hostname)
+	}
+	return pusher
+}
+
+// pushMetrics pushes the metrics with pusher every interval until ctx is
+// done, then one last time.
+func pushMetrics(ctx context.Context, pusher *push.Pusher, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			pushOnce(pusher)
+			return
+		}
+		pushOnce(pusher)
+	}
+}
+
+func pushOnce(pusher *push.Pusher) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := pusher.AddContext(ctx)
+	if err != nil {
+		log.Printf("Failed to push the metrics: %v", err)
+	}
+}
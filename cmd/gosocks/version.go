@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Set at build time with -ldflags "-X main.version=... -X main.commit=...
+// -X main.date=...", see the Makefile.
+var (
+	version string
+	commit  string
+	date    string
+)
+
+// versionInfo returns the line printed by -version. The fields which were
+// not set at build time come from the module and VCS information embedded by
+// go build, or are "(devel)".
+func versionInfo() string {
+	v, c, d := version, commit, date
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && c == "":
+				c = setting.Value
+			case setting.Key == "vcs.time" && d == "":
+				d = setting.Value
+			}
+		}
+	}
+	if len(c) > 7 {
+		c = c[:7]
+	}
+	return fmt.Sprintf("gosocks %s (commit %s, built %s)", orDevel(v), orDevel(c), orDevel(d))
+}
+
+func orDevel(s string) string {
+	if s == "" {
+		return "(devel)"
+	}
+	return s
+}
@@ -0,0 +1,120 @@
+package gosocks
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+//go:embed dashboard.html
+var dashboardFS embed.FS
+
+// DashboardStats is an event of the /events stream of the admin API. The
+// rates are computed from the byte counts of ConnectionInfo, so they move by
+// steps of 64 KiB per connection.
+type DashboardStats struct {
+	Time            time.Time          `json:"time"`
+	Active          int                `json:"active"`
+	BytesInPerSec   float64            `json:"bytes_in_per_sec"`
+	BytesOutPerSec  float64            `json:"bytes_out_per_sec"`
+	TopDestinations []DestinationStats `json:"top_destinations"`
+}
+
+// DestinationStats is the traffic of the active connections to a target.
+type DestinationStats struct {
+	TargetAddr string `json:"target_addr"`
+	Conns      int    `json:"conns"`
+	Bytes      int64  `json:"bytes"`
+}
+
+// trafficTotals returns the bytes relayed since the server started, from the
+// clients to the targets and back, along with the active sessions.
+func (s *Server) trafficTotals() (sent, recv int64, active []ConnectionInfo) {
+	active = s.Connections()
+	s.mu.Lock()
+	sent, recv = s.finishedSent, s.finishedRecv
+	for _, sess := range s.sessions {
+		sent += sess.sent.Load()
+		recv += sess.recv.Load()
+	}
+	s.mu.Unlock()
+	return sent, recv, active
+}
+
+func topDestinations(conns []ConnectionInfo, n int) []DestinationStats {
+	byTarget := make(map[string]*DestinationStats)
+	for _, c := range conns {
+		d := byTarget[c.TargetAddr]
+		if d == nil {
+			d = &DestinationStats{TargetAddr: c.TargetAddr}
+			byTarget[c.TargetAddr] = d
+		}
+		d.Conns++
+		d.Bytes += c.BytesSent + c.BytesRecv
+	}
+	top := make([]DestinationStats, 0, len(byTarget))
+	for _, d := range byTarget {
+		top = append(top, *d)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Bytes != top[j].Bytes {
+			return top[i].Bytes > top[j].Bytes
+		}
+		return top[i].TargetAddr < top[j].TargetAddr
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// serveEvents streams a DashboardStats every interval as Server-Sent Events,
+// until the client goes away.
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request, interval time.Duration) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastSent, lastRecv, _ := s.trafficTotals()
+	last := time.Now()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.baseContext().Done():
+			return
+		case now := <-ticker.C:
+			sent, recv, active := s.trafficTotals()
+			elapsed := now.Sub(last).Seconds()
+			stats := DashboardStats{
+				Time:            now,
+				Active:          len(active),
+				BytesInPerSec:   float64(recv-lastRecv) / elapsed,
+				BytesOutPerSec:  float64(sent-lastSent) / elapsed,
+				TopDestinations: topDestinations(active, 10),
+			}
+			lastSent, lastRecv, last = sent, recv, now
+
+			data, err := json.Marshal(stats)
+			if err != nil {
+				return
+			}
+			_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package gosocks_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// TestDashboardEvents checks that an SSE client of /events receives a
+// DashboardStats every second, and that the stream ends cleanly when it
+// goes away.
+func TestDashboardEvents(t *testing.T) {
+	srv := gosockstest.NewServer(t)
+	ts := httptest.NewServer(gosocks.NewAdminHandler(srv.Config, ""))
+	defer ts.Close()
+	target := gosockstest.NewEchoServer(t)
+	conn, err := client.Dial(srv.Addr(), target)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	resp, err := http.Get(ts.URL + "/dashboard")
+	if err != nil {
+		t.Fatalf("GET /dashboard: %v", err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "EventSource") {
+		t.Errorf("GET /dashboard = %d, want the page reading /events", resp.StatusCode)
+	}
+
+	resp, err = http.Get(ts.URL + "/events")
+	if err != nil {
+		t.Fatalf("GET /events: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for i := 0; i < 3; {
+		if !scanner.Scan() {
+			t.Fatalf("the stream ended after %d events: %v", i, scanner.Err())
+		}
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			t.Fatalf("unexpected line %q in the stream", line)
+		}
+		var stats gosocks.DashboardStats
+		if err := json.Unmarshal([]byte(data), &stats); err != nil {
+			t.Fatalf("event %s: %v", data, err)
+		}
+		if stats.Active != 1 || len(stats.TopDestinations) != 1 || stats.TopDestinations[0].TargetAddr != target {
+			t.Errorf("event %s, want the connection to %s", data, target)
+		}
+		i++
+	}
+	// ts.Close waits for the handler, which returns once the client is gone.
+	resp.Body.Close()
+}
@@ -0,0 +1,60 @@
+package gosocks
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// nonSOCKSPeekLen is how many bytes rejectNonSOCKS reads at most to
+	// identify the protocol of a client, and nonSOCKSPeekTimeout how long it
+	// waits for them.
+	nonSOCKSPeekLen     = 16
+	nonSOCKSPeekTimeout = time.Second
+)
+
+// rejectNonSOCKS answers a client which does not speak SOCKS, the first byte
+// it sent being first, in its own protocol when it can guess which one: an
+// HTTP 400 response to an HTTP request, and a TLS handshake_failure alert to
+// a ClientHello, so that the client gets an error rather than a silent drop.
+// It returns the protocol, "http" or "tls", or "" if it did not recognize it.
+func (s *Server) rejectNonSOCKS(client net.Conn, first byte) string {
+	prefix := make([]byte, 1, nonSOCKSPeekLen)
+	prefix[0] = first
+	client.SetReadDeadline(time.Now().Add(nonSOCKSPeekTimeout))
+	protocol := detectProtocol(prefix)
+	for protocol == "" && len(prefix) < nonSOCKSPeekLen {
+		n, err := client.Read(prefix[len(prefix):nonSOCKSPeekLen])
+		prefix = prefix[:len(prefix)+n]
+		if err != nil {
+			break
+		}
+		protocol = detectProtocol(prefix)
+	}
+
+	switch protocol {
+	case "http":
+		writeHTTPStatus(client, http.StatusBadRequest)
+	case "tls":
+		// Level fatal, description handshake_failure.
+		client.Write([]byte{0x15, prefix[1], prefix[2], 0x00, 0x02, 0x02, 0x28})
+	}
+	return protocol
+}
+
+// detectProtocol guesses the protocol of a client from the first bytes it
+// sent: "http" if they start with an HTTP method, "tls" if they start a TLS
+// handshake record, and "" if it cannot tell yet.
+func detectProtocol(prefix []byte) string {
+	for _, method := range httpMethods {
+		if bytes.HasPrefix(prefix, []byte(method+" ")) {
+			return "http"
+		}
+	}
+	if len(prefix) >= 3 && prefix[0] == 0x16 && prefix[1] == 0x03 {
+		return "tls"
+	}
+	return ""
+}
@@ -0,0 +1,157 @@
+package gosocks
+
+import (
+	"context"
+	"net"
+	"time"
+
+	socks5 "github.com/glacjay/gosocks/client"
+)
+
+// A Dialer opens the upstream connections requested by the clients. A custom
+// Dialer can chain to another proxy, apply a routing policy per destination,
+// or go through a tunnel.
+type Dialer interface {
+	Dial(network, addr string) (net.Conn, error)
+}
+
+// A ContextDialer also gives up when the context of the connection is
+// canceled, such as when the server shuts down.
+type ContextDialer interface {
+	Dialer
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// DirectDialer connects to the requested address directly, giving up after
+// Timeout if it is not zero.
+type DirectDialer struct {
+	Timeout time.Duration
+
+	// LocalIPs are the addresses the connections are bound to, at most one
+	// per family. The first one is used for the host names, and the others
+	// are dialed from the default address if there is none of their family.
+	LocalIPs []net.IP
+}
+
+func (d DirectDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d DirectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: d.Timeout}
+	if ip := d.localIP(addr); ip != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+		if network == "udp" || network == "udp4" || network == "udp6" {
+			dialer.LocalAddr = &net.UDPAddr{IP: ip}
+		}
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// localIP returns the address of d.LocalIPs to dial addr from, or nil.
+func (d DirectDialer) localIP(addr string) net.IP {
+	if len(d.LocalIPs) == 0 {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	target := net.ParseIP(host)
+	if err != nil || target == nil {
+		return d.LocalIPs[0]
+	}
+	for _, ip := range d.LocalIPs {
+		if (ip.To4() != nil) == (target.To4() != nil) {
+			return ip
+		}
+	}
+	return nil
+}
+
+func (s *Server) dialer() Dialer {
+	if s.Dialer != nil {
+		return s.Dialer
+	}
+	return DirectDialer{Timeout: s.ConnectTimeout}
+}
+
+// dial reuses an idle connection from s.Pool if there is one, and opens a new
+// one with s.dialer() otherwise, unless s.Breaker has opened the circuit of
+// addr.
+func (s *Server) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if s.Pool != nil {
+		if conn := s.Pool.get(addr); conn != nil {
+			return conn, nil
+		}
+	}
+
+	ctx, endSpan := s.startSpan(ctx, "dial", addr)
+	if !s.Breaker.allow(addr) {
+		endSpan(ErrCircuitOpen)
+		return nil, ErrCircuitOpen
+	}
+	conn, err := s.dialRetrying(ctx, network, addr)
+	endSpan(err)
+	if err != nil && ctx.Err() != nil {
+		s.Breaker.abort(addr)
+	} else {
+		s.Breaker.record(addr, err)
+	}
+	if err == nil {
+		s.tuneConn(conn)
+	}
+	if err == nil && s.Pool != nil {
+		conn = &poolConn{Conn: conn, addr: addr}
+	}
+	return conn, err
+}
+
+// dialWith opens a TCP connection to addr with the Dialer picked by a router
+// instead of s.dialer().
+func (s *Server) dialWith(ctx context.Context, dialer Dialer, addr string) (net.Conn, error) {
+	ctx, endSpan := s.startSpan(ctx, "dial", addr)
+	conn, err := dialContext(ctx, dialer, "tcp", addr)
+	endSpan(err)
+	if err == nil {
+		s.tuneConn(conn)
+	}
+	return conn, err
+}
+
+// dialRetrying dials addr with s.dialer(), retrying s.DialRetries times with
+// an exponential backoff while the connection is refused or the network is
+// unreachable.
+func (s *Server) dialRetrying(ctx context.Context, network, addr string) (net.Conn, error) {
+	backoff := s.DialRetryBackoff
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+	for attempt := 0; ; attempt++ {
+		conn, err := dialContext(ctx, s.dialer(), network, addr)
+		if err == nil || attempt >= s.DialRetries || !retryableDialError(err) {
+			return conn, err
+		}
+		requestLogger{Logger: s.logger(), id: RequestID(ctx)}.Debug("Retrying to connect to the requested address", "target_addr", addr, "error", err, "retry_in", backoff)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
+// retryableDialError reports whether err may go away soon, such as while the
+// remote server restarts, including when an upstream proxy replied so.
+func retryableDialError(err error) bool {
+	code := dialReplyCode(err)
+	return code == socks5.ReplyConnectionRefused || code == socks5.ReplyNetworkUnreachable
+}
+
+// dialContext dials with d, canceled by ctx if d is a ContextDialer.
+func dialContext(ctx context.Context, d Dialer, network, addr string) (net.Conn, error) {
+	if cd, ok := d.(ContextDialer); ok {
+		return cd.DialContext(ctx, network, addr)
+	}
+	return d.Dial(network, addr)
+}
@@ -0,0 +1,59 @@
+package gosocks_test
+
+import (
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// TestDialRetries checks that the refused and unreachable network dials are
+// retried DialRetries times, and that the other failures are not.
+func TestDialRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int32
+		err      syscall.Errno
+		rep      byte
+		dials    int32
+	}{
+		{"no failure", 0, syscall.ECONNREFUSED, 0x00, 1},
+		{"refused twice", 2, syscall.ECONNREFUSED, 0x00, 3},
+		{"refused up to the last retry", 3, syscall.ECONNREFUSED, 0x00, 4},
+		{"refused too many times", 4, syscall.ECONNREFUSED, 0x05, 4},
+		{"network unreachable", 2, syscall.ENETUNREACH, 0x00, 3},
+		{"host unreachable", 2, syscall.EHOSTUNREACH, 0x04, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dials atomic.Int32
+			dialer := gosockstest.DialerFunc(func(network, addr string) (net.Conn, error) {
+				if dials.Add(1) <= tt.failures {
+					return nil, &net.OpError{Op: "dial", Net: network, Err: tt.err}
+				}
+				return echoPipe(), nil
+			})
+			srv := gosockstest.NewServer(t, gosockstest.WithDialer(dialer), func(s *gosocks.Server) {
+				s.DialRetries = 3
+				s.DialRetryBackoff = time.Millisecond
+			})
+
+			conn := dialProxy(t, srv)
+			negotiate(t, conn, 0x00)
+			rep, _, _ := request(t, conn, 0x01, socksAddr(t, "192.0.2.1:80"))
+			if rep != tt.rep {
+				t.Errorf("reply = %#x, want %#x", rep, tt.rep)
+			}
+			if got := dials.Load(); got != tt.dials {
+				t.Errorf("%d dials, want %d", got, tt.dials)
+			}
+			if rep == 0x00 {
+				echo(t, conn, "hello")
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package gosocks
+
+import (
+	"container/list"
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// A Resolver looks up the IP addresses of the host names requested by the
+// clients.
+type Resolver interface {
+	LookupIP(host string) ([]net.IP, error)
+}
+
+// A TTLResolver also tells how long the addresses it returns stay valid.
+type TTLResolver interface {
+	Resolver
+	LookupIPTTL(host string) ([]net.IP, time.Duration, error)
+}
+
+// A ContextTTLResolver is a TTLResolver which also gives up when the context
+// of the lookup is done.
+type ContextTTLResolver interface {
+	TTLResolver
+	LookupIPTTLContext(ctx context.Context, host string) ([]net.IP, time.Duration, error)
+}
+
+// A ContextResolver also gives up when the context of the lookup is done,
+// such as when the handshake of the client times out.
+type ContextResolver interface {
+	Resolver
+	LookupIPContext(ctx context.Context, host string) ([]net.IP, error)
+}
+
+// SystemResolver uses net.DefaultResolver.
+type SystemResolver struct{}
+
+func (r SystemResolver) LookupIP(host string) ([]net.IP, error) {
+	return r.LookupIPContext(context.Background(), host)
+}
+
+func (SystemResolver) LookupIPContext(ctx context.Context, host string) ([]net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips, nil
+}
+
+// lookupIP resolves host with r, canceled by ctx if r is a ContextResolver.
+func lookupIP(ctx context.Context, r Resolver, host string) ([]net.IP, error) {
+	if cr, ok := r.(ContextResolver); ok {
+		return cr.LookupIPContext(ctx, host)
+	}
+	return r.LookupIP(host)
+}
+
+func (s *Server) resolver() Resolver {
+	if s.Resolver != nil {
+		return s.Resolver
+	}
+	return SystemResolver{}
+}
+
+// resolverKind names the resolver which looks host up through r in the
+// metrics: "system", "doh" or "custom". A DNSCache is looked through, and so
+// is a SplitResolver for its route of host.
+func resolverKind(r Resolver, host string) string {
+	switch r := r.(type) {
+	case *DNSCache:
+		return resolverKind(r.resolver, host)
+	case *SplitResolver:
+		return resolverKind(r.Route(host), host)
+	case SystemResolver:
+		return "system"
+	case *DoHResolver:
+		return "doh"
+	}
+	return "custom"
+}
+
+// DNSCache is a Resolver remembering the successful lookups of another one
+// for a while. It is safe for concurrent use.
+type DNSCache struct {
+	resolver Resolver
+	ttl      time.Duration
+	size     int
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type dnsCacheEntry struct {
+	host    string
+	ips     []net.IP
+	expires time.Time
+}
+
+// NewDNSCache returns a cache of at most size entries in front of resolver,
+// each of them kept for ttl. If resolver is a TTLResolver, the entries are
+// kept for the TTL it returns when that is shorter. A nil resolver means
+// SystemResolver.
+func NewDNSCache(resolver Resolver, ttl time.Duration, size int) *DNSCache {
+	if resolver == nil {
+		resolver = SystemResolver{}
+	}
+	return &DNSCache{
+		resolver: resolver,
+		ttl:      ttl,
+		size:     size,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *DNSCache) LookupIP(host string) ([]net.IP, error) {
+	return c.LookupIPContext(context.Background(), host)
+}
+
+func (c *DNSCache) LookupIPContext(ctx context.Context, host string) ([]net.IP, error) {
+	c.mu.Lock()
+	if elem, ok := c.entries[host]; ok {
+		entry := elem.Value.(*dnsCacheEntry)
+		if time.Now().Before(entry.expires) {
+			c.lru.MoveToFront(elem)
+			c.mu.Unlock()
+			return append([]net.IP(nil), entry.ips...), nil
+		}
+		c.lru.Remove(elem)
+		delete(c.entries, host)
+	}
+	c.mu.Unlock()
+
+	ttl := c.ttl
+	var ips []net.IP
+	var err error
+	if r, ok := c.resolver.(TTLResolver); ok {
+		var recordTTL time.Duration
+		if cr, ok := r.(ContextTTLResolver); ok {
+			ips, recordTTL, err = cr.LookupIPTTLContext(ctx, host)
+		} else {
+			ips, recordTTL, err = r.LookupIPTTL(host)
+		}
+		if recordTTL < ttl {
+			ttl = recordTTL
+		}
+	} else {
+		ips, err = lookupIP(ctx, c.resolver, host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.store(host, ips, ttl)
+	return ips, nil
+}
+
+// Flush forgets all the entries.
+func (c *DNSCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+func (c *DNSCache) store(host string, ips []net.IP, ttl time.Duration) {
+	if c.size <= 0 || ttl <= 0 || len(ips) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &dnsCacheEntry{
+		host:    host,
+		ips:     append([]net.IP(nil), ips...),
+		expires: time.Now().Add(ttl),
+	}
+	if elem, ok := c.entries[host]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[host] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*dnsCacheEntry).host)
+	}
+}
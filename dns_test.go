@@ -0,0 +1,116 @@
+package gosocks_test
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// TestDNSCache checks that the lookups within the TTL are answered by the
+// cache, and that the expired and evicted entries are looked up again.
+func TestDNSCache(t *testing.T) {
+	var lookups atomic.Int32
+	resolver := resolverFunc(func(host string) ([]net.IP, error) {
+		n := lookups.Add(1)
+		return []net.IP{net.IPv4(192, 0, 2, byte(n))}, nil
+	})
+	cache := gosocks.NewDNSCache(resolver, 100*time.Millisecond, 1)
+	lookup := func(host string, want int32) net.IP {
+		t.Helper()
+		ips, err := cache.LookupIP(host)
+		if err != nil {
+			t.Fatalf("LookupIP(%q): %v", host, err)
+		}
+		if got := lookups.Load(); got != want {
+			t.Errorf("after LookupIP(%q), %d lookups, want %d", host, got, want)
+		}
+		return ips[0]
+	}
+
+	first := lookup("a.test", 1)
+	if again := lookup("a.test", 1); !again.Equal(first) {
+		t.Errorf("cached address = %v, want %v", again, first)
+	}
+	// The cache holds a single entry.
+	lookup("b.test", 2)
+	lookup("a.test", 3)
+	time.Sleep(150 * time.Millisecond)
+	lookup("a.test", 4)
+
+	// The concurrent lookups of the same host are safe, and all but the
+	// first miss are hits.
+	cache = gosocks.NewDNSCache(resolver, time.Minute, 16)
+	lookups.Store(0)
+	cache.LookupIP("c.test")
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.LookupIP("c.test")
+		}()
+	}
+	wg.Wait()
+	if got := lookups.Load(); got != 1 {
+		t.Errorf("%d lookups of c.test, want 1", got)
+	}
+}
+
+// TestSingleFlightDNS checks that 100 concurrent requests for the same host
+// name share a single lookup, held until all of them are sent.
+func TestSingleFlightDNS(t *testing.T) {
+	var lookups atomic.Int32
+	release := make(chan struct{})
+	srv := gosockstest.NewServer(t, func(s *gosocks.Server) {
+		s.SingleFlightDNS = true
+		s.Resolver = resolverFunc(func(host string) ([]net.IP, error) {
+			lookups.Add(1)
+			<-release
+			return []net.IP{net.IPv4(127, 0, 0, 1)}, nil
+		})
+	})
+	_, port, _ := net.SplitHostPort(gosockstest.NewEchoServer(t))
+	// The method selection and the request are sent at once.
+	handshake := append([]byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00}, socksAddr(t, net.JoinHostPort("popular.test", port))...)
+
+	var sent, done sync.WaitGroup
+	for range 100 {
+		sent.Add(1)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			conn, err := net.Dial("tcp", srv.Addr())
+			if err != nil {
+				sent.Done()
+				t.Errorf("Dial: %v", err)
+				return
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			_, err = conn.Write(handshake)
+			sent.Done()
+			if err != nil {
+				t.Errorf("Write: %v", err)
+				return
+			}
+			// The method reply, then a reply with an IPv4 address.
+			reply := make([]byte, 2+10)
+			if _, err := io.ReadFull(conn, reply); err != nil || reply[3] != 0x00 {
+				t.Errorf("reply %x, %v, want a success", reply, err)
+			}
+		}()
+	}
+	sent.Wait()
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	done.Wait()
+	if got := lookups.Load(); got != 1 {
+		t.Errorf("%d lookups of popular.test, want 1", got)
+	}
+}
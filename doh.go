@@ -0,0 +1,179 @@
+package gosocks
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DoHResolver looks up host names with DNS-over-HTTPS (RFC 8484).
+type DoHResolver struct {
+	// URL is the address of the DoH server, such as
+	// "https://1.1.1.1/dns-query".
+	URL string
+
+	// UseGET sends the queries with GET instead of POST.
+	UseGET bool
+
+	// Client sends the queries, a client giving up after dohTimeout if nil.
+	Client *http.Client
+}
+
+// dohTimeout is how long the DoH queries of a DoHResolver without Client may
+// take, so that a server which hangs does not hold the lookups forever.
+const dohTimeout = 10 * time.Second
+
+var dohClient = &http.Client{Timeout: dohTimeout}
+
+func (r *DoHResolver) LookupIP(host string) ([]net.IP, error) {
+	return r.LookupIPContext(context.Background(), host)
+}
+
+func (r *DoHResolver) LookupIPContext(ctx context.Context, host string) ([]net.IP, error) {
+	ips, _, err := r.LookupIPTTLContext(ctx, host)
+	return ips, err
+}
+
+// LookupIPTTL returns the A and AAAA records of host, along with the
+// smallest TTL among them.
+func (r *DoHResolver) LookupIPTTL(host string) ([]net.IP, time.Duration, error) {
+	return r.LookupIPTTLContext(context.Background(), host)
+}
+
+// LookupIPTTLContext is LookupIPTTL, giving up when ctx is done.
+func (r *DoHResolver) LookupIPTTLContext(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, 0, nil
+	}
+
+	var ips []net.IP
+	var ttl time.Duration
+	var lastErr error
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		found, foundTTL, err := r.query(ctx, host, qtype)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(found) > 0 && (ttl == 0 || foundTTL < ttl) {
+			ttl = foundTTL
+		}
+		ips = append(ips, found...)
+	}
+	if len(ips) == 0 {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no such host: %s", host)
+		}
+		return nil, 0, &net.DNSError{Err: lastErr.Error(), Name: host, Server: r.URL}
+	}
+	return ips, ttl, nil
+}
+
+func (r *DoHResolver) query(ctx context.Context, host string, qtype dnsmessage.Type) ([]net.IP, time.Duration, error) {
+	if !strings.HasSuffix(host, ".") {
+		host += "."
+	}
+	name, err := dnsmessage.NewName(host)
+	if err != nil {
+		return nil, 0, err
+	}
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var req *http.Request
+	if r.UseGET {
+		req, err = http.NewRequestWithContext(ctx, "GET", r.URL+"?dns="+base64.RawURLEncoding.EncodeToString(packed), nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, "POST", r.URL, bytes.NewReader(packed))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/dns-message")
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Accept", "application/dns-message")
+
+	client := r.Client
+	if client == nil {
+		client = dohClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("DoH server replied %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return nil, 0, err
+	}
+	return parseDNSAnswer(body)
+}
+
+func parseDNSAnswer(msg []byte) ([]net.IP, time.Duration, error) {
+	var p dnsmessage.Parser
+	header, err := p.Start(msg)
+	if err != nil {
+		return nil, 0, err
+	}
+	if header.RCode != dnsmessage.RCodeSuccess {
+		return nil, 0, fmt.Errorf("DNS error: %v", header.RCode)
+	}
+	err = p.SkipAllQuestions()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var ips []net.IP
+	var ttl uint32
+	for {
+		h, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		switch h.Type {
+		case dnsmessage.TypeA:
+			a, err := p.AResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			ips = append(ips, net.IP(a.A[:]))
+		case dnsmessage.TypeAAAA:
+			aaaa, err := p.AAAAResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			ips = append(ips, net.IP(aaaa.AAAA[:]))
+		default:
+			err = p.SkipAnswer()
+			if err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		if len(ips) == 1 || h.TTL < ttl {
+			ttl = h.TTL
+		}
+	}
+	return ips, time.Duration(ttl) * time.Second, nil
+}
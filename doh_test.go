@@ -0,0 +1,45 @@
+package gosocks_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+)
+
+// TestDoHResolverContext checks that the lookups of a DoH server which does
+// not answer give up with their context, through a DNSCache too.
+func TestDoHResolverContext(t *testing.T) {
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hung.Close()
+	defer close(release)
+
+	doh := &gosocks.DoHResolver{URL: hung.URL}
+	resolvers := []struct {
+		name     string
+		resolver gosocks.ContextResolver
+	}{
+		{"DoHResolver", doh},
+		{"DNSCache", gosocks.NewDNSCache(doh, time.Minute, 10)},
+	}
+	for _, r := range resolvers {
+		t.Run(r.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, err := r.resolver.LookupIPContext(ctx, "example.com")
+			if err == nil {
+				t.Fatal("LookupIPContext succeeded")
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("LookupIPContext took %v after its context was done", elapsed)
+			}
+		})
+	}
+}
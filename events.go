@@ -0,0 +1,169 @@
+package gosocks
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// An EventBus receives the lifecycle events of the connections, so that a
+// program can react to them, for instance to store them or to raise alerts.
+// Publish is called synchronously by the goroutine of the connection, so it
+// must not block. The events of a connection are published in the order they
+// happen:
+//
+//	ConnectionAccepted
+//	AuthSucceeded or AuthFailed, if the client authenticates
+//	DialSucceeded or DialFailed, if the request connects to a target
+//	RelayStarted then RelayFinished, if data is relayed
+type EventBus interface {
+	Publish(event Event)
+}
+
+// An Event is one of ConnectionAccepted, AuthSucceeded, AuthFailed,
+// DialSucceeded, DialFailed, RelayStarted and RelayFinished.
+type Event interface {
+	eventType() string
+}
+
+// EventInfo is the part common to all the events.
+type EventInfo struct {
+	Time       time.Time
+	RequestID  string
+	ClientAddr string
+}
+
+// ConnectionAccepted is published when a client connects, after its PROXY
+// protocol header if any.
+type ConnectionAccepted struct {
+	EventInfo
+}
+
+// AuthSucceeded is published when a client authenticates, with Method
+// "gssapi", "password", "totp", "certificate" or, for HTTP CONNECT, "basic".
+type AuthSucceeded struct {
+	EventInfo
+	Method   string
+	Identity string
+}
+
+// AuthFailed is published when a client fails to authenticate with Method.
+type AuthFailed struct {
+	EventInfo
+	Method string
+}
+
+// DialSucceeded is published when the target of a request is connected,
+// Duration after the request was read.
+type DialSucceeded struct {
+	EventInfo
+	TargetAddr string
+	RemoteAddr string
+	Duration   time.Duration
+}
+
+// DialFailed is published when the target of a request cannot be connected.
+type DialFailed struct {
+	EventInfo
+	TargetAddr string
+	Err        error
+}
+
+// RelayStarted is published when the data of the connection starts to be
+// relayed to TargetAddr.
+type RelayStarted struct {
+	EventInfo
+	TargetAddr string
+	Identity   string
+}
+
+// RelayFinished is published when the relay of RelayStarted is done, after
+// Duration.
+type RelayFinished struct {
+	EventInfo
+	TargetAddr string
+	BytesSent  int64
+	BytesRecv  int64
+	Duration   time.Duration
+}
+
+func (ConnectionAccepted) eventType() string { return "connection_accepted" }
+func (AuthSucceeded) eventType() string      { return "auth_succeeded" }
+func (AuthFailed) eventType() string         { return "auth_failed" }
+func (DialSucceeded) eventType() string      { return "dial_succeeded" }
+func (DialFailed) eventType() string         { return "dial_failed" }
+func (RelayStarted) eventType() string       { return "relay_started" }
+func (RelayFinished) eventType() string      { return "relay_finished" }
+
+// ChannelEventBus is an EventBus sending the events to a buffered channel.
+// When the channel is full, the events are dropped rather than slowing the
+// connections down.
+type ChannelEventBus struct {
+	events  chan Event
+	dropped atomic.Int64
+}
+
+// NewChannelEventBus returns a ChannelEventBus whose channel holds buffer
+// events.
+func NewChannelEventBus(buffer int) *ChannelEventBus {
+	return &ChannelEventBus{events: make(chan Event, buffer)}
+}
+
+func (b *ChannelEventBus) Publish(event Event) {
+	select {
+	case b.events <- event:
+	default:
+		b.dropped.Add(1)
+	}
+}
+
+// Events returns the channel of the events. It is never closed.
+func (b *ChannelEventBus) Events() <-chan Event {
+	return b.events
+}
+
+// Dropped returns how many events were dropped because the channel was full.
+func (b *ChannelEventBus) Dropped() int64 {
+	return b.dropped.Load()
+}
+
+// publish sends the event built by event to s.Events, if set, so that no
+// event is built otherwise.
+func (s *Server) publish(sess *session, event func(info EventInfo) Event) {
+	if s.Events == nil {
+		return
+	}
+	s.Events.Publish(event(EventInfo{Time: time.Now(), RequestID: RequestID(sess.ctx), ClientAddr: sess.clientAddr}))
+}
+
+// publishAuth publishes the outcome of the authentication of sess with
+// method. An authentication which did not fail because of the credentials,
+// but because of the connection, is not published.
+func (s *Server) publishAuth(sess *session, method string, ok bool) {
+	switch {
+	case ok:
+		s.publish(sess, func(info EventInfo) Event {
+			return AuthSucceeded{EventInfo: info, Method: method, Identity: sess.identity}
+		})
+	case sess.outcome == outcomeAuthFail:
+		s.publish(sess, func(info EventInfo) Event {
+			return AuthFailed{EventInfo: info, Method: method}
+		})
+	}
+}
+
+// connected records that the request of sess reached its target on remote.
+func (s *Server) connected(sess *session, remote net.Conn) {
+	sess.dialDuration = time.Since(sess.requested)
+	s.publish(sess, func(info EventInfo) Event {
+		return DialSucceeded{EventInfo: info, TargetAddr: sess.target, RemoteAddr: remote.RemoteAddr().String(), Duration: sess.dialDuration}
+	})
+}
+
+// dialFailed records that the request of sess could not reach its target.
+func (s *Server) dialFailed(sess *session, err error) {
+	sess.outcome = outcomeConnectFail
+	s.publish(sess, func(info EventInfo) Event {
+		return DialFailed{EventInfo: info, TargetAddr: sess.target, Err: err}
+	})
+}
@@ -0,0 +1,75 @@
+package gosocks_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// receiveEvents returns the type names of the next n events of bus.
+func receiveEvents(t *testing.T, bus *gosocks.ChannelEventBus, n int) ([]string, []gosocks.Event) {
+	t.Helper()
+	var names []string
+	var events []gosocks.Event
+	for len(events) < n {
+		select {
+		case event := <-bus.Events():
+			names = append(names, fmt.Sprintf("%T", event))
+			events = append(events, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received the events %v, want %d", names, n)
+		}
+	}
+	return names, events
+}
+
+func TestEvents(t *testing.T) {
+	target := gosockstest.NewEchoServer(t)
+	tests := []struct {
+		name   string
+		auth   bool
+		target string
+		want   []string
+	}{
+		{"relay", false, target, []string{"gosocks.ConnectionAccepted", "gosocks.DialSucceeded", "gosocks.RelayStarted", "gosocks.RelayFinished"}},
+		{"authenticated relay", true, target, []string{"gosocks.ConnectionAccepted", "gosocks.AuthSucceeded", "gosocks.DialSucceeded", "gosocks.RelayStarted", "gosocks.RelayFinished"}},
+		{"dial failure", false, "127.0.0.1:0", []string{"gosocks.ConnectionAccepted", "gosocks.DialFailed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := gosocks.NewChannelEventBus(16)
+			opts := []gosockstest.Option{func(s *gosocks.Server) {
+				s.Events = bus
+			}}
+			if tt.auth {
+				opts = append(opts, gosockstest.WithAuth("user", "pass"))
+			}
+			srv := gosockstest.NewServer(t, opts...)
+
+			dialer := &client.Dialer{ProxyAddr: srv.Addr()}
+			if tt.auth {
+				dialer.Username, dialer.Password = "user", "pass"
+			}
+			conn, err := dialer.Dial("tcp", tt.target)
+			if err == nil {
+				echo(t, conn, "hello")
+				conn.Close()
+			}
+
+			names, events := receiveEvents(t, bus, len(tt.want))
+			if fmt.Sprint(names) != fmt.Sprint(tt.want) {
+				t.Fatalf("events = %v, want %v", names, tt.want)
+			}
+			if finished, ok := events[len(events)-1].(gosocks.RelayFinished); ok && (finished.BytesSent != 5 || finished.BytesRecv != 5) {
+				t.Errorf("RelayFinished counted %d bytes sent and %d received, want 5", finished.BytesSent, finished.BytesRecv)
+			}
+			if succeeded, ok := events[1].(gosocks.AuthSucceeded); ok && (succeeded.Method != "password" || succeeded.Identity != "user") {
+				t.Errorf("AuthSucceeded = %+v, want the user of the method password", succeeded)
+			}
+		})
+	}
+}
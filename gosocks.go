@@ -1,243 +1,619 @@
-package main
+// Package gosocks implements a SOCKS4, SOCKS4a and SOCKS5 proxy server which
+// can be embedded in other programs.
+package gosocks
 
 import (
-	"flag"
+	"bytes"
+	"context"
+	"crypto/tls"
 	"io"
-	"log"
 	"net"
-	"os"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	socks5 "github.com/glacjay/gosocks/client"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var (
-	flagPort = flag.Int("port", 1080, "listening port")
+// session collects what happens to a client connection, and the policy it
+// is subject to.
+type session struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	log    Logger
+	span   trace.Span
+
+	auth     Authenticator
+	acl      *ACL
+	outcome  string
+	identity string
+
+	// certAuthenticated is set when identity comes from the TLS client
+	// certificate, so that no SOCKS5 method is required.
+	certAuthenticated bool
+
+	start      time.Time
+	clientAddr string
+	version    string
+	command    string
+	target     string
+	sent       atomic.Int64
+	recv       atomic.Int64
+
+	// requested is when the request was read, and dialDuration how long it
+	// took from then to connect to the target, zero if it was not reached.
+	requested    time.Time
+	dialDuration time.Duration
+}
+
+const (
+	outcomeSuccess       = "success"
+	outcomeHandshakeFail = "handshake_fail"
+	outcomeAuthFail      = "auth_fail"
+	outcomeDNSFail       = "dns_fail"
+	outcomeConnectFail   = "connect_fail"
+	outcomeBindFail      = "bind_fail"
+	outcomeRejected      = "rejected"
 )
 
-func main() {
-	flag.Parse()
+var v5Commands = map[byte]string{
+	0x01: "connect",
+	0x02: "bind",
+	0x03: "udp_associate",
+}
 
-	addr := &net.TCPAddr{IP: []byte{0, 0, 0, 0}, Port: *flagPort}
-	listener, err := net.ListenTCP("tcp", addr)
-	if err != nil {
-		log.Fatalf("Failed to listen on port %d: %v", *flagPort, err)
+// handleConn serves client and returns the outcome of its session. lc is the
+// Listener of ListenAndServe which accepted client, nil otherwise; such a
+// client speaks TLS when s.TLSConfig is set, after its PROXY protocol header
+// if any.
+func (s *Server) handleConn(ctx context.Context, client net.Conn, lc *Listener) (outcome string) {
+	addr := client.RemoteAddr().String()
+	defer client.Close()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Canceling the context unblocks whatever I/O the client is waiting for.
+	stop := context.AfterFunc(ctx, func() {
+		client.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	sess := &session{
+		ctx:        ctx,
+		cancel:     cancel,
+		log:        requestLogger{Logger: s.logger(), id: RequestID(ctx)},
+		auth:       s.Auth,
+		acl:        s.acl(),
+		outcome:    outcomeHandshakeFail,
+		start:      time.Now(),
+		clientAddr: addr,
+	}
+	if lc != nil && lc.Auth != nil {
+		sess.auth = lc.Auth
+	}
+	if lc != nil && lc.ACL != nil {
+		sess.acl = lc.ACL.within(sess.acl)
+	}
+	s.Metrics.connectionStarted()
+	defer func() {
+		s.Metrics.connectionFinished(sess.outcome)
+		s.recordLatency(sess)
+		s.logAccess(sess)
+		outcome = sess.outcome
+	}()
+	defer s.endRequestSpan(sess)
+
+	s.tuneConn(client)
+	if s.HandshakeTimeout > 0 {
+		client.SetDeadline(time.Now().Add(s.HandshakeTimeout))
 	}
 
-	for {
-		client, err := listener.AcceptTCP()
+	if s.ProxyProtocol {
+		conn, err := readProxyHeader(client)
 		if err != nil {
-			log.Fatalf("Failed to accept new client connection: %v", err)
-			e := err.(net.Error)
-			if !e.Temporary() {
-				os.Exit(1)
-			}
+			sess.log.Warn("Failed to read the PROXY protocol header", "remote_addr", addr, "error", err)
+			return
 		}
-		go clientLoop(client)
+		client = conn
+		addr = client.RemoteAddr().String()
+		sess.clientAddr = addr
 	}
-}
+	// The per-IP rate limit applies to the client of the PROXY header, not
+	// to the load balancer.
+	if s.PerIPRate > 0 {
+		s.waitClient(ctx, client)
+		if s.HandshakeTimeout > 0 {
+			client.SetDeadline(time.Now().Add(s.HandshakeTimeout))
+		}
+	}
+	if lc != nil && s.TLSConfig != nil {
+		client = tls.Server(client, s.TLSConfig)
+	}
+	s.publish(sess, func(info EventInfo) Event {
+		return ConnectionAccepted{EventInfo: info}
+	})
 
-func clientLoop(client *net.TCPConn) {
-	addr := client.RemoteAddr()
-	defer client.Close()
+	if s.TProxy {
+		sess.version = "tproxy"
+		s.startRequestSpan(sess)
+		s.handleTProxy(client, sess)
+		return
+	}
 
-	var versionMethod [2]byte
-	_, err := io.ReadFull(client, versionMethod[:])
+	var version [1]byte
+	_, err := io.ReadFull(client, version[:])
 	if err != nil {
-		log.Printf("%v: Failed to read the version and methods number: %v", addr, err)
+		sess.log.Warn("Failed to read the version number", "remote_addr", addr, "error", err)
 		return
 	}
 
-	if versionMethod[0] != 0x05 {
-		log.Printf("%v: Only implemented socks5 proxy currently: %X.", addr, versionMethod[0])
-		return
+	switch version[0] {
+	case 0x04:
+		sess.version = "socks4"
+		s.startRequestSpan(sess)
+		s.handleV4(client, sess)
+	case 0x05:
+		sess.version = "socks5"
+		s.handleV5(client, sess)
+	case helloVersion:
+		if s.ExperimentalHello {
+			s.handleHello(client, sess)
+			return
+		}
+		sess.log.Warn("Only implemented socks4 and socks5 proxy currently", "remote_addr", addr, "version", version[0])
+	case 'C':
+		if s.HTTPConnect {
+			sess.version = "http"
+			s.startRequestSpan(sess)
+			s.handleHTTPConnect(client, version[0], sess)
+			return
+		}
+		sess.log.Warn("HTTP CONNECT is not enabled", "remote_addr", addr)
+		s.rejectNonSOCKS(client, version[0])
+	default:
+		if protocol := s.rejectNonSOCKS(client, version[0]); protocol != "" {
+			sess.log.Warn("Rejected non-SOCKS traffic", "remote_addr", addr, "protocol", protocol)
+			return
+		}
+		sess.log.Warn("Only implemented socks4 and socks5 proxy currently", "remote_addr", addr, "version", version[0])
 	}
+	return
+}
+
+func (s *Server) handleV5(client net.Conn, sess *session) {
+	addr := client.RemoteAddr().String()
 
-	nMethods := versionMethod[1]
-	if nMethods == 0 {
-		log.Printf("%v: Must provide one method at least.", addr)
+	var versionMethod [2]byte
+	versionMethod[0] = 0x05
+	_, err := io.ReadFull(client, versionMethod[1:])
+	if err != nil {
+		sess.log.Warn("Failed to read the methods number", "remote_addr", addr, "error", err)
 		return
 	}
 
-	methods := make([]byte, nMethods)
+	// An empty list offers no acceptable method, and gets 0xFF as well.
+	methods := make([]byte, versionMethod[1])
 	_, err = io.ReadFull(client, methods)
 	if err != nil {
-		log.Printf("%v: Failed to read the methods: %v", addr, err)
+		sess.log.Warn("Failed to read the methods", "remote_addr", addr, "error", err)
 		return
 	}
 
-	hasMethod0 := false
-	for i := 0; i < int(nMethods); i++ {
-		if methods[i] == 0x00 {
-			hasMethod0 = true
-			break
-		}
+	s.authenticateCert(client, sess)
+	method := s.methodNegotiation(methods, sess)
+	// A health check does not authenticate, such a client may only PING.
+	pingOnly := false
+	if method == 0xFF && s.HealthCheck && bytes.IndexByte(methods, 0x00) >= 0 {
+		method = 0x00
+		pingOnly = true
 	}
-	if !hasMethod0 {
-		log.Printf("%v: Only implemented 'no authentication required' method currently.", addr)
+	if method == 0xFF {
+		sess.outcome = outcomeAuthFail
+		sess.log.Warn("The client does not offer an acceptable method", "remote_addr", addr, "methods", methods)
+		client.Write([]byte{0x05, 0xFF})
 		return
 	}
 
-	versionMethod[1] = 0x00
+	versionMethod[1] = method
 	nw, err := client.Write(versionMethod[:])
 	if err != nil || nw != len(versionMethod) {
-		log.Printf("%v: Failed to write version and method back to the client: %v", addr, err)
+		sess.log.Warn("Failed to write version and method back to the client", "remote_addr", addr, "error", err)
 		return
 	}
 
+	switch method {
+	case 0x01:
+		var ok bool
+		client, ok = s.authenticateGSSAPI(client, sess)
+		s.publishAuth(sess, "gssapi", ok)
+		if !ok {
+			return
+		}
+	case 0x02:
+		ok := s.authenticate(client, sess)
+		s.publishAuth(sess, "password", ok)
+		if !ok {
+			return
+		}
+	case methodTOTP:
+		ok := s.authenticateTOTP(client, sess)
+		s.publishAuth(sess, "totp", ok)
+		if !ok {
+			return
+		}
+	case methodRequestID:
+		if !s.readClientRequestID(client, sess) {
+			return
+		}
+	}
+	if sess.identity != "" {
+		sess.ctx = context.WithValue(sess.ctx, identityKey{}, sess.identity)
+	}
+	s.startRequestSpan(sess)
+
 	var requestHeader [4]byte
 	_, err = io.ReadFull(client, requestHeader[:])
 	if err != nil {
-		log.Printf("%v: Failed to read the request header: %v", addr, err)
+		sess.log.Warn("Failed to read the request header", "remote_addr", addr, "error", err)
 		return
 	}
 
-	var reply [22]byte
-	reply[0] = 0x05 // VER
-	reply[2] = 0x00 // RSV
 	if requestHeader[0] != 0x05 {
-		log.Printf("%v: Version number in the request does not match the previous one: %X", addr, requestHeader[0])
+		sess.log.Warn("Version number in the request does not match the previous one", "remote_addr", addr, "version", requestHeader[0])
+		return
+	}
+	if requestHeader[1] == cmdPing && s.HealthCheck {
+		s.handlePing(client, requestHeader[3], sess)
 		return
 	}
-	if requestHeader[1] != 0x01 {
-		log.Printf("%v: Only implemented CONNECT command currently.", addr)
-		reply[1] = 0x07
-		client.Write(reply[:4])
+	if pingOnly {
+		sess.outcome = outcomeAuthFail
+		sess.log.Warn("The client does not offer an acceptable method", "remote_addr", addr, "methods", methods)
+		writeReply(client, socks5.ReplyNotAllowed, nil, 0)
+		return
+	}
+	if requestHeader[1] == cmdResolve && requestHeader[2] == 0x00 {
+		s.handleResolve(client, requestHeader[3], sess)
+		return
+	}
+	if requestHeader[1] < 0x01 || requestHeader[1] > 0x03 {
+		sess.log.Warn("Unknown command", "remote_addr", addr, "command", requestHeader[1])
+		writeReply(client, socks5.ReplyCommandNotSupported, nil, 0)
 		return
 	}
 	if requestHeader[2] != 0x00 {
-		log.Printf("%v: RESERVED field must be 0.", addr)
+		sess.log.Warn("RESERVED field must be 0", "remote_addr", addr)
 		return
 	}
+	sess.command = v5Commands[requestHeader[1]]
+	sess.requested = time.Now()
 
 	remoteAddress := new(net.TCPAddr)
+	var targetHost string
+	var targetIPs []net.IP
 	switch requestHeader[3] {
-	case 0x01, 0x04:
+	case 0x01:
+		{
+			remoteAddress.IP, remoteAddress.Port, err = readIPPort(client, net.IPv4len)
+			if err != nil {
+				sess.log.Warn("Failed to read requested address", "remote_addr", addr, "error", err)
+				return
+			}
+		}
+	case 0x04:
 		{
-			ipLen := 4 * requestHeader[3]
-			buf := make([]byte, ipLen+2)
-			_, err = io.ReadFull(client, buf)
+			remoteAddress.IP, remoteAddress.Port, err = readIPPort(client, net.IPv6len)
 			if err != nil {
-				log.Printf("%v: Failed to read requested address: %v", addr, err)
+				sess.log.Warn("Failed to read requested address", "remote_addr", addr, "error", err)
 				return
 			}
-			remoteAddress.IP = buf[:ipLen]
-			remoteAddress.Port = int(buf[ipLen])<<8 + int(buf[ipLen+1])
-			reply[3] = requestHeader[3]
 		}
 	case 0x03:
 		{
 			var hostLen [1]byte
 			_, err = io.ReadFull(client, hostLen[:])
 			if err != nil {
-				log.Printf("%v: Failed to read requested host len: %v", addr, err)
+				sess.log.Warn("Failed to read requested host len", "remote_addr", addr, "error", err)
 				return
 			}
 			host := make([]byte, hostLen[0])
 			_, err = io.ReadFull(client, host)
 			if err != nil {
-				log.Printf("%v: Failed to read requested host name: %v", addr, err)
+				sess.log.Warn("Failed to read requested host name", "remote_addr", addr, "error", err)
 				return
 			}
-			ips, err := net.LookupIP(string(host))
+			var port [2]byte
+			_, err = io.ReadFull(client, port[:])
 			if err != nil {
-				log.Printf("%v: Failed to resolve requested host: %v", addr, err)
+				sess.log.Warn("Failed to read requested port", "remote_addr", addr, "error", err)
 				return
 			}
-			if len(ips) == 0 {
-				log.Printf("%v: There is no IP address corresponding to host '%s'.", addr, host)
+			remoteAddress.Port = int(port[0])<<8 + int(port[1])
+			targetHost = string(host)
+			if requestHeader[1] == 0x01 && !sess.acl.AllowTarget(targetHost, nil) {
+				s.rejectV5(client, sess, targetHost)
 				return
 			}
-			remoteAddress.IP = ips[0]
-			reply[3] = len(ips[0]) / 4
-			var port [2]byte
-			_, err = io.ReadFull(client, port[:])
+			ips, err := s.lookupIP(sess, targetHost)
 			if err != nil {
-				log.Printf("%v: Failed to read requested port: %v", addr, err)
+				sess.outcome = outcomeDNSFail
+				sess.log.Warn("Failed to resolve requested host", "remote_addr", addr, "target_addr", string(host), "error", err)
+				writeReply(client, socks5.ReplyHostUnreachable, nil, 0)
 				return
 			}
-			remoteAddress.Port = int(port[0])<<8 + int(port[1])
+			if len(ips) == 0 {
+				sess.outcome = outcomeDNSFail
+				sess.log.Warn("There is no IP address corresponding to the requested host", "remote_addr", addr, "target_addr", string(host))
+				writeReply(client, socks5.ReplyHostUnreachable, nil, 0)
+				return
+			}
+			sess.log.Debug("Resolved requested host", "remote_addr", addr, "target_addr", targetHost, "ips", ips)
+			for i, ip := range ips {
+				ip = normalizeIP(ip)
+				ips[i] = ip
+				if sess.acl.AllowTarget(targetHost, ip) {
+					targetIPs = append(targetIPs, ip)
+				}
+			}
+			remoteAddress.IP = ips[0]
+			if len(targetIPs) > 0 {
+				remoteAddress.IP = targetIPs[0]
+			}
 		}
 	default:
-		log.Printf("%v: unknown address type: %X", addr, requestHeader[3])
-		reply[1] = 0x08
-		client.Write(reply[:4])
+		sess.log.Warn("Unknown address type", "remote_addr", addr, "address_type", requestHeader[3])
+		writeReply(client, socks5.ReplyAddressTypeNotSupported, nil, 0)
+		return
+	}
+	sess.target = remoteAddress.String()
+	if targetHost != "" {
+		sess.target = net.JoinHostPort(targetHost, strconv.Itoa(remoteAddress.Port))
+	}
+	sess.log.Info("Requested address", "remote_addr", addr, "target_addr", remoteAddress.String())
+
+	if s.Quotas.exceeded(sess.identity) {
+		sess.outcome = outcomeRejected
+		sess.log.Warn("Quota exceeded", "remote_addr", addr, "user", sess.identity)
+		writeReply(client, socks5.ReplyNotAllowed, nil, 0)
+		return
+	}
+	if !sess.acl.AllowClient(addrIP(client.RemoteAddr())) || !sess.acl.AllowIdentity(sess.identity) ||
+		(requestHeader[1] == 0x01 && !sess.acl.AllowTarget(targetHost, remoteAddress.IP)) {
+		s.rejectV5(client, sess, remoteAddress.String())
+		return
+	}
+	resolved := targetIPs
+	if resolved == nil {
+		resolved = []net.IP{remoteAddress.IP}
+	}
+	if !s.preRelay(client, sess, resolved) {
+		writeReply(client, socks5.ReplyNotAllowed, nil, 0)
+		return
+	}
+
+	switch requestHeader[1] {
+	case 0x02:
+		s.handleBind(client, remoteAddress, sess)
+		return
+	case 0x03:
+		s.handleUDPAssociate(client, remoteAddress, sess)
+		return
+	}
+
+	var rule *RouteRule
+	if s.Rules != nil {
+		rule, err = s.Rules.Route(RouteEnv{
+			ClientIP:   addrIP(client.RemoteAddr()).String(),
+			ClientUser: sess.identity,
+			TargetHost: targetHost,
+			TargetIP:   remoteAddress.IP.String(),
+			TargetPort: remoteAddress.Port,
+		})
+		if err != nil {
+			sess.log.Warn("Failed to evaluate the routing rules", "remote_addr", addr, "target_addr", sess.target, "error", err)
+			s.rejectV5(client, sess, sess.target)
+			return
+		}
+		if rule != nil && rule.Reject {
+			s.rejectV5(client, sess, sess.target)
+			return
+		}
+	}
+
+	var dialer Dialer
+	routed := rule != nil
+	if routed {
+		dialer = rule.Dialer
+	} else if s.Router != nil {
+		dialer, routed = s.Router.Lookup(remoteAddress.IP)
+	}
+
+	if s.SNIRouter != nil && !routed {
+		s.connectSNI(client, remoteAddress, targetIPs, sess)
 		return
 	}
-	log.Printf("%v: Requested address: %v", addr, remoteAddress)
 
-	remote, err := net.DialTCP("tcp", nil, remoteAddress)
+	// The upstream proxies which take host names get the requested one.
+	var remote net.Conn
+	if dialer != nil && targetHost != "" && sendsHostNames(dialer) {
+		remote, err = s.dialWith(sess.ctx, dialer, sess.target)
+	} else if dialer != nil {
+		remote, err = s.dialWith(sess.ctx, dialer, remoteAddress.String())
+	} else if targetHost != "" && sendsHostNames(s.dialer()) {
+		remote, err = s.dial(sess.ctx, "tcp", sess.target)
+	} else if len(targetIPs) > 1 {
+		remote, err = s.dialHappyEyeballs(sess.ctx, targetIPs, remoteAddress.Port)
+	} else {
+		remote, err = s.dial(sess.ctx, "tcp", remoteAddress.String())
+	}
 	if err != nil {
-		log.Printf("%v: Failed to connect to the requested address: %v", addr, err)
-		reply[1] = 0x05
-		client.Write(reply[:6])
+		s.dialFailed(sess, err)
+		sess.log.Warn("Failed to connect to the requested address", "remote_addr", addr, "target_addr", remoteAddress.String(), "error", err)
+		writeReply(client, dialReplyCode(err), nil, 0)
 		return
 	}
 	defer remote.Close()
+	s.connected(sess, remote)
 
-	reply[1] = 0x00
-	ipEnd := 4 + 4*reply[3]
-	copy(reply[4:ipEnd], remoteAddress.IP)
-	reply[ipEnd] = byte(remoteAddress.Port >> 8)
-	reply[ipEnd+1] = byte(remoteAddress.Port % 256)
-	_, err = client.Write(reply[:ipEnd+2])
+	var boundPort int
+	if bound, ok := remote.LocalAddr().(*net.TCPAddr); ok {
+		boundPort = bound.Port
+	}
+	err = writeReply(client, socks5.ReplySucceeded, addrIP(remote.LocalAddr()), boundPort)
 	if err != nil {
-		log.Printf("%v: Failed to write reply: %v", addr, err)
+		sess.log.Warn("Failed to write reply", "remote_addr", addr, "error", err)
 		return
 	}
 
-	stopChan := make(chan bool)
-	go readClientLoop(client, remote, stopChan)
-	go readRemoteLoop(client, remote, stopChan)
-	_ = <-stopChan
-	_ = <-stopChan
+	s.relay(client, remote, sess)
 }
 
-func readClientLoop(client, remote *net.TCPConn, stopChan chan<- bool) {
-	defer func() {
-		stopChan <- true
-	}()
-	addr := client.RemoteAddr()
+// lookupIP resolves host with s.resolver(), giving up when the session is
+// canceled or its handshake times out.
+func (s *Server) lookupIP(sess *session, host string) ([]net.IP, error) {
+	ctx := sess.ctx
+	if s.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, sess.start.Add(s.HandshakeTimeout))
+		defer cancel()
+	}
+	return s.lookupIPContext(ctx, host)
+}
 
-	for {
-		var buf [4096]byte
-		nr, err := client.Read(buf[:])
-		if err != nil && err != os.EOF {
-			log.Printf("%v: Failed to read from the client: %v", addr, err)
-			return
-		}
+// lookupIPContext resolves host with s.resolver(), giving up when ctx is
+// done.
+func (s *Server) lookupIPContext(ctx context.Context, host string) ([]net.IP, error) {
+	resolver := s.resolver()
+	ctx, endSpan := s.startSpan(ctx, "dns.lookup", host)
+	start := time.Now()
+	var ips []net.IP
+	var err error
+	if s.SingleFlightDNS {
+		ips, err = s.lookupIPShared(ctx, resolver, host)
+	} else {
+		ips, err = lookupIP(ctx, resolver, host)
+	}
+	s.Metrics.dnsResolved(resolverKind(resolver, host), err, time.Since(start))
+	endSpan(err)
+	return ips, err
+}
 
-		start := 0
-		for start < nr {
-			nw, err := remote.Write(buf[start:nr])
-			if err != nil && err != os.EOF {
-				log.Printf("%v: Failed to write to the remote: %v", addr, err)
-				return
-			}
-			start += nw
+// lookupIPShared resolves host with resolver, sharing the lookup with those
+// of host already in flight. The shared lookup is not canceled with ctx, so
+// that the other callers still get its result, but it is limited to
+// HandshakeTimeout.
+func (s *Server) lookupIPShared(ctx context.Context, resolver Resolver, host string) ([]net.IP, error) {
+	results := s.dnsFlight.DoChan(host, func() (any, error) {
+		ctx := context.WithoutCancel(ctx)
+		if s.HandshakeTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, s.HandshakeTimeout)
+			defer cancel()
 		}
+		return lookupIP(ctx, resolver, host)
+	})
+	select {
+	case result := <-results:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return slices.Clone(result.Val.([]net.IP)), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
-func readRemoteLoop(client, remote *net.TCPConn, stopChan chan<- bool) {
-	defer func() {
-		stopChan <- true
-	}()
-	addr := client.RemoteAddr()
+func (s *Server) rejectV5(client net.Conn, sess *session, target string) {
+	sess.outcome = outcomeRejected
+	sess.log.Warn("Connection not allowed by ruleset", "remote_addr", client.RemoteAddr().String(), "target_addr", target)
+	writeReply(client, socks5.ReplyNotAllowed, nil, 0)
+}
 
-	for {
-		var buf [4096]byte
-		nr, err := remote.Read(buf[:])
-		if err != nil && err != os.EOF {
-			log.Printf("%v: Failed to read from the remote: %v", addr, err)
-			return
-		}
+func readIPPort(r io.Reader, ipLen int) (net.IP, int, error) {
+	buf := make([]byte, ipLen+2)
+	_, err := io.ReadFull(r, buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	return net.IP(buf[:ipLen]), int(buf[ipLen])<<8 + int(buf[ipLen+1]), nil
+}
 
-		start := 0
-		for start < nr {
-			nw, err := client.Write(buf[start:nr])
-			if err != nil && err != os.EOF {
-				log.Printf("%v: Failed to write to the client: %v", addr, err)
-				return
+// methodNegotiation returns the first method of s.AuthMethods, or of the
+// default order, which is enabled and offered by the client, 0xFF if there is
+// none. By default GSSAPI is preferred to TOTP, then to username/password, and
+// no authentication is only possible if none of them is enabled. The request ID
+// extension replaces no authentication when both are offered.
+func (s *Server) methodNegotiation(offered []byte, sess *session) byte {
+	if sess.certAuthenticated && bytes.IndexByte(offered, 0x00) >= 0 {
+		return 0x00
+	}
+	order := s.AuthMethods
+	if order == nil {
+		order = []byte{0x01, methodTOTP, 0x02}
+		if s.GSSAPI == nil && s.TOTP == nil && sess.auth == nil {
+			order = []byte{0x00}
+		}
+	}
+	for _, m := range order {
+		switch {
+		case m == 0x00:
+		case m == 0x01 && s.GSSAPI != nil:
+		case m == 0x02 && sess.auth != nil:
+		case m == methodTOTP && s.TOTP != nil:
+		default:
+			continue
+		}
+		if bytes.IndexByte(offered, m) >= 0 {
+			if m == 0x00 && s.RequestIDExtension && bytes.IndexByte(offered, methodRequestID) >= 0 {
+				return methodRequestID
 			}
-			start += nw
+			return m
 		}
 	}
+	return 0xFF
+}
+
+// anonymousAllowed reports whether the client of sess may go without
+// authenticating, as a SOCKS5 client offering only the no authentication
+// method would. The protocols which cannot authenticate with the methods of
+// the server, SOCKS4 and HTTP CONNECT, are only served then.
+func (s *Server) anonymousAllowed(sess *session) bool {
+	return s.methodNegotiation([]byte{0x00}, sess) == 0x00
+}
+
+func appendAddr(b []byte, ip net.IP, port int) []byte {
+	if ip = normalizeIP(ip); ip == nil {
+		ip = net.IPv4zero.To4()
+	}
+	b = append(b, addrType(ip))
+	b = append(b, ip...)
+	return append(b, byte(port>>8), byte(port%256))
+}
+
+// normalizeIP returns the IPv4 addresses as 4 bytes, the IPv4-mapped IPv6
+// ones (::ffff:1.2.3.4) included, the others as 16 bytes, and nil if ip is
+// invalid.
+func normalizeIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip.To16()
+}
+
+// addrType returns the SOCKS5 address type of ip: 0x01 for IPv4, the
+// IPv4-mapped IPv6 addresses included, and 0x04 for IPv6.
+func addrType(ip net.IP) byte {
+	if ip.To4() != nil {
+		return 0x01
+	}
+	return 0x04
+}
+
+func addrIP(a net.Addr) net.IP {
+	if tcpAddr, ok := a.(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+	return nil
 }
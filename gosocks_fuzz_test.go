@@ -0,0 +1,82 @@
+package gosocks_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// FuzzClientLoop feeds arbitrary bytes to a Server over a net.Pipe, and
+// fails if the server panics or does not return within 5 seconds once the
+// client has hung up. No outbound connection is ever opened.
+func FuzzClientLoop(f *testing.F) {
+	longHost := strings.Repeat("a", 255)
+	seeds := [][]byte{
+		// CONNECT to an IPv4, IPv6 and domain address.
+		[]byte("\x05\x01\x00\x05\x01\x00\x01\xc0\x00\x02\x01\x00\x50"),
+		[]byte("\x05\x01\x00\x05\x01\x00\x04\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x50"),
+		[]byte("\x05\x01\x00\x05\x01\x00\x03\x0bexample.com\x00\x50"),
+		// A 255-byte host name, and an empty one.
+		[]byte("\x05\x01\x00\x05\x01\x00\x03\xff" + longHost + "\x00\x50"),
+		[]byte("\x05\x01\x00\x05\x01\x00\x03\x00\x00\x50"),
+		// An empty method list, and the longest one.
+		[]byte("\x05\x00"),
+		append([]byte{0x05, 0xff}, make([]byte, 255)...),
+		// Username/password authentication, with the longest fields.
+		[]byte("\x05\x01\x02\x01\x04user\x04pass\x05\x01\x00\x01\xc0\x00\x02\x01\x00\x50"),
+		[]byte("\x05\x01\x02\x01\xff" + longHost + "\xff" + longHost),
+		// BIND, UDP ASSOCIATE and unknown commands and address types.
+		[]byte("\x05\x01\x00\x05\x02\x00\x01\xc0\x00\x02\xba\x01\x00\x50"),
+		[]byte("\x05\x01\x00\x05\x03\x00\x01\x00\x00\x00\x00\x00\x00"),
+		[]byte("\x05\x01\x00\x05\xff\x00\x01\xc0\x00\x02\x01\x00\x50"),
+		[]byte("\x05\x01\x00\x05\x01\x00\xff"),
+		// Truncated messages.
+		[]byte("\x05"),
+		[]byte("\x05\x02\x00"),
+		[]byte("\x05\x01\x00\x05\x01\x00\x03\xff" + longHost[:10]),
+		// The other protocols of the port.
+		[]byte("\x04\x01\x00\x50\xc0\x00\x02\x01user\x00"),
+		[]byte("\x04\x01\x00\x50\x00\x00\x00\x01\x00example.com\x00"),
+		[]byte("CONNECT example.com:80 HTTP/1.1\r\nHost: example.com:80\r\n\r\n"),
+		{},
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	s := &gosocks.Server{
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Dialer: gosockstest.DialerFunc(func(network, addr string) (net.Conn, error) {
+			return nil, errors.New("fuzz: no outbound connection")
+		}),
+		Resolver: resolverFunc(func(host string) ([]net.IP, error) {
+			return []net.IP{net.IPv4(192, 0, 2, 1)}, nil
+		}),
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		client, server := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.ServeConn(context.Background(), server)
+		}()
+		go io.Copy(io.Discard, client)
+		client.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		client.Write(data)
+		client.Close()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the server did not return within 5 seconds for %q", data)
+		}
+	})
+}
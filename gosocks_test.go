@@ -0,0 +1,219 @@
+package gosocks_test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// dialProxy connects to srv, giving the exchange 5 seconds.
+func dialProxy(t *testing.T, srv *gosockstest.Server) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Addr())
+	if err != nil {
+		t.Fatalf("Dial proxy: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// negotiate offers methods to the proxy and returns the one it selected.
+func negotiate(t *testing.T, conn net.Conn, methods ...byte) byte {
+	t.Helper()
+	_, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...))
+	if err != nil {
+		t.Fatalf("Write methods: %v", err)
+	}
+	var reply [2]byte
+	_, err = io.ReadFull(conn, reply[:])
+	if err != nil {
+		t.Fatalf("Read method: %v", err)
+	}
+	if reply[0] != 0x05 {
+		t.Fatalf("method reply version = %#x, want 0x05", reply[0])
+	}
+	return reply[1]
+}
+
+// socksAddr returns the SOCKS5 ATYP, address and port of hostPort.
+func socksAddr(t *testing.T, hostPort string) []byte {
+	t.Helper()
+	host, portString, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		t.Fatalf("SplitHostPort(%q): %v", hostPort, err)
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		t.Fatalf("invalid port %q", portString)
+	}
+	var b []byte
+	switch ip := net.ParseIP(host); {
+	case ip == nil:
+		b = append([]byte{0x03, byte(len(host))}, host...)
+	case ip.To4() != nil:
+		b = append([]byte{0x01}, ip.To4()...)
+	default:
+		b = append([]byte{0x04}, ip.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port))
+}
+
+// request sends a SOCKS5 request with cmd for the ATYP and address addr, and
+// returns the REP field, the ATYP and the bound address of the reply.
+func request(t *testing.T, conn net.Conn, cmd byte, addr []byte) (rep, atyp byte, bound []byte) {
+	t.Helper()
+	_, err := conn.Write(append([]byte{0x05, cmd, 0x00}, addr...))
+	if err != nil {
+		t.Fatalf("Write request: %v", err)
+	}
+	return readReply(t, conn)
+}
+
+func readReply(t *testing.T, conn net.Conn) (rep, atyp byte, bound []byte) {
+	t.Helper()
+	var header [4]byte
+	_, err := io.ReadFull(conn, header[:])
+	if err != nil {
+		t.Fatalf("Read reply: %v", err)
+	}
+	var n int
+	switch header[3] {
+	case 0x01:
+		n = net.IPv4len
+	case 0x04:
+		n = net.IPv6len
+	default:
+		t.Fatalf("reply address type = %#x", header[3])
+	}
+	bound = make([]byte, n+2)
+	_, err = io.ReadFull(conn, bound)
+	if err != nil {
+		t.Fatalf("Read bound address: %v", err)
+	}
+	return header[1], header[3], bound
+}
+
+// echoPipe returns a connection to an echo server, for the fake dialers.
+func echoPipe() net.Conn {
+	conn, target := net.Pipe()
+	go func() {
+		defer target.Close()
+		io.Copy(target, target)
+	}()
+	return conn
+}
+
+// echo checks that what is written to conn comes back.
+func echo(t *testing.T, conn net.Conn, message string) {
+	t.Helper()
+	_, err := conn.Write([]byte(message))
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got := make([]byte, len(message))
+	_, err = io.ReadFull(conn, got)
+	if err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if string(got) != message {
+		t.Fatalf("echoed %q, want %q", got, message)
+	}
+}
+
+func TestConnect(t *testing.T) {
+	srv := gosockstest.NewServer(t)
+	target := gosockstest.NewEchoServer(t)
+	_, port, _ := net.SplitHostPort(target)
+
+	for _, addr := range []string{target, net.JoinHostPort("localhost", port)} {
+		t.Run(addr, func(t *testing.T) {
+			conn, err := client.Dial(srv.Addr(), addr)
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			defer conn.Close()
+			echo(t, conn, "hello")
+		})
+	}
+}
+
+func TestConnectSOCKS4(t *testing.T) {
+	srv := gosockstest.NewServer(t)
+	target := gosockstest.NewEchoServer(t)
+	_, portString, _ := net.SplitHostPort(target)
+	port, _ := strconv.Atoi(portString)
+
+	tests := []struct {
+		name    string
+		request []byte
+	}{
+		{"socks4", append(binary.BigEndian.AppendUint16([]byte{0x04, 0x01}, uint16(port)), 127, 0, 0, 1, 0)},
+		{"socks4a", append(binary.BigEndian.AppendUint16([]byte{0x04, 0x01}, uint16(port)), append([]byte{0, 0, 0, 1, 0}, "localhost\x00"...)...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := dialProxy(t, srv)
+			_, err := conn.Write(tt.request)
+			if err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			var reply [8]byte
+			_, err = io.ReadFull(conn, reply[:])
+			if err != nil {
+				t.Fatalf("Read reply: %v", err)
+			}
+			if reply[1] != 0x5A {
+				t.Fatalf("reply code = %#x, want 0x5a", reply[1])
+			}
+			echo(t, conn, "hello")
+		})
+	}
+}
+
+func TestConnectReply(t *testing.T) {
+	srv := gosockstest.NewServer(t)
+	target := gosockstest.NewEchoServer(t)
+
+	conn := dialProxy(t, srv)
+	if method := negotiate(t, conn, 0x00); method != 0x00 {
+		t.Fatalf("method = %#x, want 0x00", method)
+	}
+	rep, atyp, bound := request(t, conn, 0x01, socksAddr(t, target))
+	if rep != 0x00 || atyp != 0x01 {
+		t.Fatalf("reply = %#x with address type %#x, want 0x00 and 0x01", rep, atyp)
+	}
+	if ip := net.IP(bound[:4]); !ip.IsLoopback() {
+		t.Errorf("bound address = %v, want a loopback address", ip)
+	}
+	echo(t, conn, "hello")
+}
+
+// TestConnectIPv6 checks that the 16 bytes of an IPv6 address are read,
+// and no more: the target dialed is the requested one, and the data which
+// follows the request is relayed whole.
+func TestConnectIPv6(t *testing.T) {
+	dialed := make(chan string, 1)
+	srv := gosockstest.NewServer(t, gosockstest.WithDialer(gosockstest.DialerFunc(func(network, addr string) (net.Conn, error) {
+		dialed <- addr
+		return echoPipe(), nil
+	})))
+
+	conn := dialProxy(t, srv)
+	negotiate(t, conn, 0x00)
+	addr := "[2001:db8:1122:3344:5566:7788:99aa:bbcc]:8080"
+	rep, _, _ := request(t, conn, 0x01, socksAddr(t, addr))
+	if rep != 0x00 {
+		t.Fatalf("reply = %#x, want 0x00", rep)
+	}
+	if got := <-dialed; got != addr {
+		t.Errorf("dialed %v, want %v", got, addr)
+	}
+	echo(t, conn, "hello")
+}
@@ -0,0 +1,95 @@
+// Package gosockstest provides an in-process SOCKS5 server for the tests of
+// the code which connects through a proxy, in the manner of
+// net/http/httptest.
+package gosockstest
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/glacjay/gosocks"
+)
+
+// Server is a gosocks.Server listening on a random port of the loopback
+// interface.
+type Server struct {
+	// Config is the server which handles the connections. It must not be
+	// changed once NewServer has returned.
+	Config *gosocks.Server
+
+	listener net.Listener
+	done     chan struct{}
+	once     sync.Once
+}
+
+// An Option configures the Server of NewServer before it starts.
+type Option func(*gosocks.Server)
+
+// WithAuth requires the clients to authenticate with the username/password
+// method. It may be given several times for several users.
+func WithAuth(user, pass string) Option {
+	return func(s *gosocks.Server) {
+		credentials, ok := s.Auth.(gosocks.Credentials)
+		if !ok {
+			credentials = gosocks.Credentials{}
+			s.Auth = credentials
+		}
+		credentials[user] = pass
+	}
+}
+
+// WithDialer opens the outbound connections with d, so that a test can see
+// or fake them.
+func WithDialer(d gosocks.Dialer) Option {
+	return func(s *gosocks.Server) {
+		s.Dialer = d
+	}
+}
+
+// NewServer starts a Server, ready to accept connections when it returns,
+// which is closed when tb and its subtests complete. The logs of the server
+// are discarded.
+func NewServer(tb testing.TB, opts ...Option) *Server {
+	tb.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("gosockstest: failed to listen: %v", err)
+	}
+	s := &Server{
+		Config: &gosocks.Server{
+			Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		},
+		listener: l,
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s.Config)
+	}
+	go func() {
+		defer close(s.done)
+		s.Config.Serve(l)
+	}()
+	tb.Cleanup(s.Close)
+	return s
+}
+
+// Addr returns the "host:port" address of s.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops s, closing the connections it is still relaying. It may be
+// called several times.
+func (s *Server) Close() {
+	s.once.Do(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		s.Config.Shutdown(ctx)
+		s.listener.Close()
+		<-s.done
+	})
+}
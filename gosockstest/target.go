@@ -0,0 +1,79 @@
+package gosockstest
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+// DialerFunc is a gosocks.Dialer calling the function itself, so that a test
+// can fake the outbound connections of a server with WithDialer.
+type DialerFunc func(network, addr string) (net.Conn, error)
+
+func (f DialerFunc) Dial(network, addr string) (net.Conn, error) {
+	return f(network, addr)
+}
+
+// NewTarget starts a TCP server on a random port of the loopback interface,
+// to be connected to through a Server, and returns its "host:port" address.
+// Each connection is served by handle in a new goroutine, and closed when it
+// returns. The target and its connections are closed when tb and its
+// subtests complete.
+func NewTarget(tb testing.TB, handle func(conn net.Conn)) string {
+	tb.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("gosockstest: failed to listen: %v", err)
+	}
+
+	var mu sync.Mutex
+	conns := make(map[net.Conn]struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns[conn] = struct{}{}
+			mu.Unlock()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() {
+					mu.Lock()
+					delete(conns, conn)
+					mu.Unlock()
+					conn.Close()
+				}()
+				handle(conn)
+			}()
+		}
+	}()
+	tb.Cleanup(func() {
+		l.Close()
+		mu.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+		wg.Wait()
+	})
+	return l.Addr().String()
+}
+
+// NewEchoServer starts a target, as NewTarget, which writes back everything
+// it reads, and half-closes its side of the connection once the client has.
+func NewEchoServer(tb testing.TB) string {
+	tb.Helper()
+	return NewTarget(tb, func(conn net.Conn) {
+		io.Copy(conn, conn)
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+	})
+}
@@ -0,0 +1,202 @@
+package gosocks
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// GSSAPIAuthenticator enables the GSSAPI method (RFC 1961), typically backed
+// by Kerberos. No implementation is bundled, to keep cgo and the Kerberos
+// libraries out of this package; wrap a binding such as a gss or krb5
+// package in it.
+type GSSAPIAuthenticator interface {
+	// NewContext starts the security context of a new client.
+	NewContext() (GSSAPIContext, error)
+}
+
+// GSSAPIContext is the acceptor side of a GSSAPI security context.
+type GSSAPIContext interface {
+	// Accept processes a token sent by the client, and returns the token to
+	// send back, if any, and whether the context is established.
+	Accept(ctx context.Context, token []byte) (output []byte, established bool, err error)
+
+	// Principal is the name of the authenticated client, once the context
+	// is established.
+	Principal() string
+
+	// Wrap and Unwrap protect the messages exchanged after the context is
+	// established, encrypting them too if confidential is set.
+	Wrap(message []byte, confidential bool) ([]byte, error)
+	Unwrap(token []byte) ([]byte, error)
+}
+
+const (
+	gssapiVersion           = 0x01
+	gssapiAuthMessage       = 0x01
+	gssapiProtectionMessage = 0x02
+	gssapiEncapsulated      = 0x03
+	gssapiAbort             = 0xFF
+
+	gssapiIntegrity       = 0x01
+	gssapiConfidentiality = 0x02
+)
+
+// authenticateGSSAPI runs the context establishment and the protection level
+// subnegotiation, and returns client wrapped in that protection.
+func (s *Server) authenticateGSSAPI(client net.Conn, sess *session) (net.Conn, bool) {
+	addr := client.RemoteAddr().String()
+
+	gctx, err := s.GSSAPI.NewContext()
+	if err != nil {
+		sess.log.Error("Failed to create the GSSAPI context", "remote_addr", addr, "error", err)
+		writeGSSAPIMessage(client, gssapiAbort, nil)
+		return nil, false
+	}
+
+	for established := false; !established; {
+		mtyp, token, err := readGSSAPIMessage(client)
+		if err != nil {
+			sess.log.Warn("Failed to read the GSSAPI token", "remote_addr", addr, "error", err)
+			return nil, false
+		}
+		if mtyp != gssapiAuthMessage {
+			sess.log.Warn("Unexpected GSSAPI message type", "remote_addr", addr, "message_type", mtyp)
+			return nil, false
+		}
+		var output []byte
+		output, established, err = gctx.Accept(context.Background(), token)
+		if err != nil {
+			sess.outcome = outcomeAuthFail
+			sess.log.Warn("Authentication failed", "remote_addr", addr, "method", "gssapi", "error", err)
+			writeGSSAPIMessage(client, gssapiAbort, nil)
+			return nil, false
+		}
+		if len(output) > 0 || !established {
+			err = writeGSSAPIMessage(client, gssapiAuthMessage, output)
+			if err != nil {
+				sess.log.Warn("Failed to write the GSSAPI token", "remote_addr", addr, "error", err)
+				return nil, false
+			}
+		}
+	}
+
+	mtyp, token, err := readGSSAPIMessage(client)
+	if err != nil || mtyp != gssapiProtectionMessage {
+		sess.log.Warn("Failed to read the GSSAPI protection level", "remote_addr", addr, "message_type", mtyp, "error", err)
+		return nil, false
+	}
+	level, err := gctx.Unwrap(token)
+	if err != nil || len(level) != 1 {
+		sess.log.Warn("Invalid GSSAPI protection level", "remote_addr", addr, "error", err)
+		writeGSSAPIMessage(client, gssapiAbort, nil)
+		return nil, false
+	}
+	// Selective protection is not supported, it is upgraded to
+	// confidentiality.
+	confidential := level[0] != gssapiIntegrity
+	chosen := []byte{gssapiIntegrity}
+	if confidential {
+		chosen[0] = gssapiConfidentiality
+	}
+	token, err = gctx.Wrap(chosen, false)
+	if err == nil {
+		err = writeGSSAPIMessage(client, gssapiProtectionMessage, token)
+	}
+	if err != nil {
+		sess.log.Warn("Failed to write the GSSAPI protection level", "remote_addr", addr, "error", err)
+		return nil, false
+	}
+
+	sess.identity = gctx.Principal()
+	return &gssapiConn{Conn: client, gctx: gctx, confidential: confidential}, true
+}
+
+func readGSSAPIMessage(r io.Reader) (byte, []byte, error) {
+	var header [4]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return 0, nil, err
+	}
+	if header[0] != gssapiVersion {
+		return 0, nil, fmt.Errorf("unknown GSSAPI version: %X", header[0])
+	}
+	if header[1] == gssapiAbort {
+		return gssapiAbort, nil, fmt.Errorf("aborted by the client")
+	}
+	token := make([]byte, binary.BigEndian.Uint16(header[2:]))
+	_, err = io.ReadFull(r, token)
+	if err != nil {
+		return 0, nil, err
+	}
+	return header[1], token, nil
+}
+
+func writeGSSAPIMessage(w io.Writer, mtyp byte, token []byte) error {
+	if len(token) > 0xFFFF {
+		return fmt.Errorf("GSSAPI token too long: %d bytes", len(token))
+	}
+	if mtyp == gssapiAbort {
+		_, err := w.Write([]byte{gssapiVersion, gssapiAbort})
+		return err
+	}
+	message := []byte{gssapiVersion, mtyp, 0, 0}
+	binary.BigEndian.PutUint16(message[2:4], uint16(len(token)))
+	_, err := w.Write(append(message, token...))
+	return err
+}
+
+// gssapiConn encapsulates the data going through a connection in GSSAPI
+// messages, as required once the protection level is negotiated.
+type gssapiConn struct {
+	net.Conn
+	gctx         GSSAPIContext
+	confidential bool
+	pending      []byte
+}
+
+func (c *gssapiConn) Read(b []byte) (int, error) {
+	for len(c.pending) == 0 {
+		mtyp, token, err := readGSSAPIMessage(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		if mtyp != gssapiEncapsulated {
+			return 0, fmt.Errorf("unexpected GSSAPI message type: %X", mtyp)
+		}
+		c.pending, err = c.gctx.Unwrap(token)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *gssapiConn) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		// Leave room for the overhead of the wrapping.
+		chunk := len(b)
+		if chunk > 0x8000 {
+			chunk = 0x8000
+		}
+		token, err := c.gctx.Wrap(b[:chunk], c.confidential)
+		if err == nil {
+			err = writeGSSAPIMessage(c.Conn, gssapiEncapsulated, token)
+		}
+		if err != nil {
+			return written, err
+		}
+		written += chunk
+		b = b[chunk:]
+	}
+	return written, nil
+}
+
+func (c *gssapiConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
@@ -0,0 +1,105 @@
+package gosocks
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+)
+
+// happyEyeballsDelay is how long each connection attempt runs alone before
+// the next one starts, the minimum allowed by RFC 8305.
+const happyEyeballsDelay = 100 * time.Millisecond
+
+// dialHappyEyeballs connects to port on every address of ips, alternating
+// between IPv6 and IPv4 starting with IPv6. Each attempt gets a head start
+// over the next one, which also starts as soon as it fails. Whichever
+// connects first is returned, and the other attempts are canceled.
+func (s *Server) dialHappyEyeballs(ctx context.Context, ips []net.IP, port int) (net.Conn, error) {
+	ips = interleaveFamilies(ips)
+	if len(ips) == 1 {
+		return s.dial(ctx, "tcp", net.JoinHostPort(ips[0].String(), strconv.Itoa(port)))
+	}
+
+	// The attempts which lose are canceled as soon as one wins.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(ips))
+	next := 0
+	dial := func() {
+		ip := ips[next]
+		next++
+		go func() {
+			conn, err := s.dial(ctx, "tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+			results <- result{conn, err}
+		}()
+	}
+
+	dial()
+	pending := 1
+	timer := time.NewTimer(happyEyeballsDelay)
+	defer timer.Stop()
+
+	var firstErr error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if next < len(ips) {
+				dial()
+				pending++
+				timer.Reset(happyEyeballsDelay)
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				go func(n int) {
+					for ; n > 0; n-- {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(ips) {
+				dial()
+				pending++
+				timer.Reset(happyEyeballsDelay)
+			}
+		}
+	}
+	return nil, firstErr
+}
+
+// interleaveFamilies orders ips as IPv6, IPv4, IPv6... keeping the order of
+// each family.
+func interleaveFamilies(ips []net.IP) []net.IP {
+	var ip4, ip6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			ip4 = append(ip4, ip)
+		} else {
+			ip6 = append(ip6, ip)
+		}
+	}
+	ordered := make([]net.IP, 0, len(ips))
+	for len(ip4) > 0 || len(ip6) > 0 {
+		if len(ip6) > 0 {
+			ordered = append(ordered, ip6[0])
+			ip6 = ip6[1:]
+		}
+		if len(ip4) > 0 {
+			ordered = append(ordered, ip4[0])
+			ip4 = ip4[1:]
+		}
+	}
+	return ordered
+}
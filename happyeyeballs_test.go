@@ -0,0 +1,131 @@
+package gosocks_test
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// TestHappyEyeballs checks that IPv4 wins over a stalled IPv6 attempt, and
+// that the reply has the address of the connection which won.
+func TestHappyEyeballs(t *testing.T) {
+	target := gosockstest.NewEchoServer(t)
+	_, port, _ := net.SplitHostPort(target)
+	stalled := make(chan struct{})
+	t.Cleanup(func() { close(stalled) })
+	var mu sync.Mutex
+	var dialed []string
+	var bound net.Addr
+	srv := gosockstest.NewServer(t,
+		gosockstest.WithDialer(gosockstest.DialerFunc(func(network, addr string) (net.Conn, error) {
+			mu.Lock()
+			dialed = append(dialed, addr)
+			mu.Unlock()
+			host, _, _ := net.SplitHostPort(addr)
+			if net.ParseIP(host).To4() == nil {
+				<-stalled
+				return nil, &net.OpError{Op: "dial", Net: network, Err: net.UnknownNetworkError("stalled")}
+			}
+			conn, err := net.Dial(network, net.JoinHostPort(host, port))
+			if err == nil {
+				mu.Lock()
+				bound = conn.LocalAddr()
+				mu.Unlock()
+			}
+			return conn, err
+		})),
+		func(s *gosocks.Server) {
+			s.Resolver = resolverFunc(func(host string) ([]net.IP, error) {
+				return []net.IP{net.IPv4(127, 0, 0, 1), net.ParseIP("2001:db8::1")}, nil
+			})
+		},
+	)
+
+	conn := dialProxy(t, srv)
+	negotiate(t, conn, 0x00)
+	start := time.Now()
+	rep, atyp, addr := request(t, conn, 0x01, socksAddr(t, net.JoinHostPort("dual.test", port)))
+	if rep != 0x00 {
+		t.Fatalf("reply = %#x, want 0x00", rep)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("connected after %v, the IPv6 attempt held back IPv4", elapsed)
+	}
+	echo(t, conn, "hello")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dialed) != 2 || dialed[0] != net.JoinHostPort("2001:db8::1", port) {
+		t.Errorf("dialed %q, want IPv6 then IPv4", dialed)
+	}
+	if atyp != 0x01 {
+		t.Fatalf("bound address type = %#x, want IPv4", atyp)
+	}
+	got := net.JoinHostPort(net.IP(addr[:4]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(addr[4:]))))
+	if got != bound.String() {
+		t.Errorf("bound address = %s, want %s", got, bound)
+	}
+}
+
+// TestDialFailover checks that when the first address of a host fails, or
+// does not answer, the next one is tried and its connection is used.
+func TestDialFailover(t *testing.T) {
+	target := gosockstest.NewEchoServer(t)
+	_, port, _ := net.SplitHostPort(target)
+	stalled := make(chan struct{})
+	t.Cleanup(func() { close(stalled) })
+	tests := []struct {
+		name  string
+		first string
+	}{
+		{"refused", "192.0.2.1"},
+		{"stalled", "192.0.2.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var dialed []string
+			srv := gosockstest.NewServer(t,
+				gosockstest.WithDialer(gosockstest.DialerFunc(func(network, addr string) (net.Conn, error) {
+					mu.Lock()
+					dialed = append(dialed, addr)
+					mu.Unlock()
+					host, _, _ := net.SplitHostPort(addr)
+					switch host {
+					case "192.0.2.1":
+						return nil, &net.OpError{Op: "dial", Net: network, Err: syscall.ECONNREFUSED}
+					case "192.0.2.2":
+						<-stalled
+						return nil, &net.OpError{Op: "dial", Net: network, Err: syscall.ETIMEDOUT}
+					}
+					return net.Dial(network, net.JoinHostPort(host, port))
+				})),
+				func(s *gosocks.Server) {
+					s.Resolver = resolverFunc(func(host string) ([]net.IP, error) {
+						return []net.IP{net.ParseIP(tt.first), net.IPv4(127, 0, 0, 1)}, nil
+					})
+				},
+			)
+
+			conn := dialProxy(t, srv)
+			negotiate(t, conn, 0x00)
+			rep, _, _ := request(t, conn, 0x01, socksAddr(t, net.JoinHostPort("failover.test", port)))
+			if rep != 0x00 {
+				t.Fatalf("reply = %#x, want 0x00", rep)
+			}
+			echo(t, conn, "hello")
+			mu.Lock()
+			defer mu.Unlock()
+			if len(dialed) != 2 || dialed[0] != net.JoinHostPort(tt.first, port) {
+				t.Errorf("dialed %q, want %s then 127.0.0.1", dialed, tt.first)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package gosocks
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+)
+
+// httpMethods are the request methods recognized by headerConn and
+// rejectNonSOCKS.
+var httpMethods = []string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS", "PATCH", "TRACE", "CONNECT"}
+
+// headerConn adds header lines, such as X-Forwarded-For, after the first line
+// the client sends, if that line is an HTTP request line. Only the first
+// request of a keep-alive connection gets the headers.
+type headerConn struct {
+	net.Conn
+	headers []string
+	r       io.Reader
+}
+
+func newHeaderConn(conn net.Conn, headers []string) *headerConn {
+	return &headerConn{Conn: conn, headers: headers}
+}
+
+func (c *headerConn) Read(b []byte) (int, error) {
+	if c.r == nil {
+		c.r = injectHeaders(c.Conn, c.headers)
+	}
+	return c.r.Read(b)
+}
+
+func (c *headerConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
+
+// relayHeaders returns the header lines added to the HTTP requests of the
+// session: X-Forwarded-For with ForwardClientIP, and X-Request-Id if the
+// client sent one with the request ID extension.
+func (s *Server) relayHeaders(client net.Conn, sess *session) []string {
+	var headers []string
+	if ip := addrIP(client.RemoteAddr()); s.ForwardClientIP && ip != nil {
+		headers = append(headers, "X-Forwarded-For: "+ip.String())
+	}
+	if id := ClientRequestID(sess.ctx); id != "" {
+		headers = append(headers, "X-Request-Id: "+id)
+	}
+	return headers
+}
+
+// injectHeaders reads the first line of r and returns a reader of the whole
+// stream, with the headers inserted after that line if it is an HTTP/1
+// request line. Lines longer than the bufio buffer are relayed untouched, and
+// so are the streams which do not start with an HTTP method, without waiting
+// for a line.
+func injectHeaders(r io.Reader, headers []string) io.Reader {
+	br := bufio.NewReader(r)
+	var head []byte
+	if startsWithMethod(br) {
+		line, err := br.ReadSlice('\n')
+		head = append(head, line...)
+		if err == nil && isHTTPRequestLine(head) {
+			for _, h := range headers {
+				head = append(head, h+"\r\n"...)
+			}
+		}
+	}
+	return io.MultiReader(bytes.NewReader(head), br)
+}
+
+// startsWithMethod peeks at br one more byte at a time until it can tell
+// whether the stream starts with an HTTP method and a space, so that a client
+// speaking TLS or a binary protocol first is not kept waiting for a newline
+// it never sends.
+func startsWithMethod(br *bufio.Reader) bool {
+	for n := 1; ; n++ {
+		prefix, err := br.Peek(n)
+		if err != nil {
+			return false
+		}
+		possible := false
+		for _, m := range httpMethods {
+			token := []byte(m + " ")
+			if bytes.HasPrefix(prefix, token) {
+				return true
+			}
+			possible = possible || bytes.HasPrefix(token, prefix)
+		}
+		if !possible {
+			return false
+		}
+	}
+}
+
+func isHTTPRequestLine(line []byte) bool {
+	line = bytes.TrimRight(line, "\r\n")
+	method, rest, ok := bytes.Cut(line, []byte(" "))
+	if !ok || !bytes.HasPrefix(rest, []byte("/")) && !bytes.HasPrefix(rest, []byte("*")) && !bytes.Contains(rest, []byte("://")) {
+		return false
+	}
+	if !bytes.HasSuffix(line, []byte(" HTTP/1.0")) && !bytes.HasSuffix(line, []byte(" HTTP/1.1")) {
+		return false
+	}
+	for _, m := range httpMethods {
+		if string(method) == m {
+			return true
+		}
+	}
+	return false
+}
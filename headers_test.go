@@ -0,0 +1,57 @@
+package gosocks
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestInjectHeaders(t *testing.T) {
+	headers := []string{"X-Forwarded-For: 192.0.2.1"}
+	tests := []struct {
+		name, in, want string
+	}{
+		{"request", "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "GET / HTTP/1.1\r\nX-Forwarded-For: 192.0.2.1\r\nHost: example.com\r\n\r\n"},
+		{"absolute URL", "GET http://example.com/ HTTP/1.0\r\n\r\n", "GET http://example.com/ HTTP/1.0\r\nX-Forwarded-For: 192.0.2.1\r\n\r\n"},
+		{"unknown method", "BREW / HTTP/1.1\r\n\r\n", "BREW / HTTP/1.1\r\n\r\n"},
+		{"not HTTP/1", "GET / SPDY/3\r\n\r\n", "GET / SPDY/3\r\n\r\n"},
+		{"TLS", "\x16\x03\x01\x00\x05hello\n", "\x16\x03\x01\x00\x05hello\n"},
+		{"short", "GE", "GE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			go func() {
+				client.Write([]byte(tt.in))
+				client.Close()
+			}()
+			got, err := io.ReadAll(injectHeaders(server, headers))
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestInjectHeadersNoNewline checks that a client speaking first a protocol
+// other than HTTP is relayed without waiting for a newline.
+func TestInjectHeadersNoNewline(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte("\x16\x03\x01\x00\x05"))
+
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := injectHeaders(server, []string{"X-Forwarded-For: 192.0.2.1"})
+	got := make([]byte, 5)
+	_, err := io.ReadFull(r, got)
+	if err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if string(got) != "\x16\x03\x01\x00\x05" {
+		t.Errorf("got %q", got)
+	}
+}
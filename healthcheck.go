@@ -0,0 +1,38 @@
+package gosocks
+
+import (
+	"net"
+
+	socks5 "github.com/glacjay/gosocks/client"
+)
+
+// cmdPing is the vendor-specific SOCKS5 command answered when HealthCheck is
+// set. Its address, if any, is ignored.
+const cmdPing = 0xFE
+
+// handlePing answers a PING request with a success reply and a zero IPv4
+// address, then lets the connection close.
+func (s *Server) handlePing(client net.Conn, atyp byte, sess *session) {
+	addr := client.RemoteAddr().String()
+	sess.command = "ping"
+
+	var err error
+	switch atyp {
+	case 0x01:
+		_, _, err = readIPPort(client, net.IPv4len)
+	case 0x04:
+		_, _, err = readIPPort(client, net.IPv6len)
+	}
+	if err != nil {
+		sess.log.Warn("Failed to read the health check address", "remote_addr", addr, "error", err)
+		return
+	}
+
+	err = writeReply(client, socks5.ReplySucceeded, net.IPv4zero, 0)
+	if err != nil {
+		sess.log.Warn("Failed to write reply", "remote_addr", addr, "error", err)
+		return
+	}
+	sess.outcome = outcomeSuccess
+	sess.log.Debug("Answered health check", "remote_addr", addr)
+}
@@ -0,0 +1,91 @@
+package gosocks
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+)
+
+// helloVersion is the first byte of the HELLO preamble of the experimental
+// capability negotiation, a version number SOCKS does not use. The client
+// sends it, the length of its advertisement on two bytes in network order,
+// then the advertisement, a JSON helloMessage. The server replies in the same
+// format with its own capabilities, and the standard SOCKS5 handshake follows
+// on the connection.
+const helloVersion = 0x06
+
+// helloMessage is the capability advertisement of a HELLO preamble.
+type helloMessage struct {
+	Capabilities []string `json:"capabilities"`
+}
+
+// capabilities returns the SOCKS5 extensions the server supports, as
+// advertised in the HELLO preamble.
+func (s *Server) capabilities() []string {
+	capabilities := []string{"resolve", "udp-fragmentation"}
+	if s.HealthCheck {
+		capabilities = append(capabilities, "health-check")
+	}
+	if s.RequestIDExtension {
+		capabilities = append(capabilities, "request-id")
+	}
+	if s.GSSAPI != nil {
+		capabilities = append(capabilities, "gssapi")
+	}
+	if s.TOTP != nil {
+		capabilities = append(capabilities, "totp")
+	}
+	return capabilities
+}
+
+// handleHello answers the HELLO preamble, whose first byte was read, then
+// handles the SOCKS5 handshake which follows.
+func (s *Server) handleHello(client net.Conn, sess *session) {
+	addr := client.RemoteAddr().String()
+
+	var length [2]byte
+	_, err := io.ReadFull(client, length[:])
+	if err != nil {
+		sess.log.Warn("Failed to read the HELLO length", "remote_addr", addr, "error", err)
+		return
+	}
+	body := make([]byte, binary.BigEndian.Uint16(length[:]))
+	_, err = io.ReadFull(client, body)
+	if err != nil {
+		sess.log.Warn("Failed to read the HELLO advertisement", "remote_addr", addr, "error", err)
+		return
+	}
+	var hello helloMessage
+	err = json.Unmarshal(body, &hello)
+	if err != nil {
+		sess.log.Warn("Invalid HELLO advertisement", "remote_addr", addr, "error", err)
+		return
+	}
+
+	reply, err := json.Marshal(helloMessage{Capabilities: s.capabilities()})
+	if err != nil {
+		sess.log.Warn("Failed to encode the HELLO reply", "remote_addr", addr, "error", err)
+		return
+	}
+	reply = append(binary.BigEndian.AppendUint16([]byte{helloVersion}, uint16(len(reply))), reply...)
+	_, err = client.Write(reply)
+	if err != nil {
+		sess.log.Warn("Failed to write the HELLO reply", "remote_addr", addr, "error", err)
+		return
+	}
+	sess.log.Debug("Negotiated capabilities", "remote_addr", addr, "capabilities", hello.Capabilities)
+
+	var version [1]byte
+	_, err = io.ReadFull(client, version[:])
+	if err != nil {
+		sess.log.Warn("Failed to read the version number", "remote_addr", addr, "error", err)
+		return
+	}
+	if version[0] != 0x05 {
+		sess.log.Warn("The HELLO preamble must be followed by a SOCKS5 handshake", "remote_addr", addr, "version", version[0])
+		return
+	}
+	sess.version = "socks5"
+	s.handleV5(client, sess)
+}
@@ -0,0 +1,95 @@
+package gosocks_test
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// hello sends a HELLO preamble on conn and returns the capabilities of the
+// reply.
+func hello(t *testing.T, conn net.Conn, capabilities ...string) []string {
+	t.Helper()
+	body, _ := json.Marshal(map[string][]string{"capabilities": capabilities})
+	_, err := conn.Write(append(binary.BigEndian.AppendUint16([]byte{0x06}, uint16(len(body))), body...))
+	if err != nil {
+		t.Fatalf("Write HELLO: %v", err)
+	}
+	var header [3]byte
+	_, err = io.ReadFull(conn, header[:])
+	if err != nil {
+		t.Fatalf("Read HELLO reply: %v", err)
+	}
+	if header[0] != 0x06 {
+		t.Fatalf("HELLO reply version = %#x, want 0x06", header[0])
+	}
+	body = make([]byte, binary.BigEndian.Uint16(header[1:]))
+	_, err = io.ReadFull(conn, body)
+	if err != nil {
+		t.Fatalf("Read HELLO reply: %v", err)
+	}
+	var reply struct {
+		Capabilities []string `json:"capabilities"`
+	}
+	err = json.Unmarshal(body, &reply)
+	if err != nil {
+		t.Fatalf("invalid HELLO reply %q: %v", body, err)
+	}
+	return reply.Capabilities
+}
+
+func TestHello(t *testing.T) {
+	target := gosockstest.NewEchoServer(t)
+	for _, enabled := range []bool{false, true} {
+		srv := gosockstest.NewServer(t, func(s *gosocks.Server) {
+			s.ExperimentalHello = enabled
+			s.HealthCheck = true
+		})
+		name := "disabled"
+		if enabled {
+			name = "enabled"
+		}
+
+		t.Run(name+"/standard client", func(t *testing.T) {
+			conn, err := client.Dial(srv.Addr(), target)
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			defer conn.Close()
+			echo(t, conn, "hello")
+		})
+
+		t.Run(name+"/HELLO client", func(t *testing.T) {
+			conn := dialProxy(t, srv)
+			if !enabled {
+				_, err := conn.Write([]byte{0x06, 0x00, 0x02, '{', '}'})
+				if err != nil {
+					t.Fatalf("Write HELLO: %v", err)
+				}
+				if n, err := conn.Read(make([]byte, 1)); err == nil {
+					t.Fatalf("read %d bytes, want the connection closed", n)
+				}
+				return
+			}
+			capabilities := hello(t, conn, "resolve")
+			for _, c := range []string{"resolve", "health-check"} {
+				if !slices.Contains(capabilities, c) {
+					t.Errorf("capabilities = %v, want %s among them", capabilities, c)
+				}
+			}
+			negotiate(t, conn, 0x00)
+			rep, _, _ := request(t, conn, 0x01, socksAddr(t, target))
+			if rep != 0x00 {
+				t.Fatalf("reply = %#x, want 0x00", rep)
+			}
+			echo(t, conn, "hello")
+		})
+	}
+}
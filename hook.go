@@ -0,0 +1,64 @@
+package gosocks
+
+import (
+	"context"
+	"net"
+)
+
+// ConnInfo describes a request once the handshake is done and the target is
+// resolved.
+type ConnInfo struct {
+	ClientAddr net.Addr
+	Version    string
+	Command    string
+
+	// TargetAddr is the "host:port" address requested by the client, and
+	// TargetIPs the addresses the host resolved to.
+	TargetAddr string
+	TargetIPs  []net.IP
+
+	// Identity is the authenticated user, empty if the client did not
+	// authenticate.
+	Identity string
+}
+
+// A Hook inspects the requests before the server acts on them. Returning an
+// error refuses the request, with the reply code 0x02 (not allowed by
+// ruleset) for SOCKS5.
+type Hook interface {
+	PreRelay(ctx context.Context, info ConnInfo) error
+}
+
+// MultiHook calls each of its hooks in order, stopping at the first error.
+type MultiHook []Hook
+
+func (m MultiHook) PreRelay(ctx context.Context, info ConnInfo) error {
+	for _, h := range m {
+		err := h.PreRelay(ctx, info)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// preRelay asks s.Hook whether the request of sess may go on.
+func (s *Server) preRelay(client net.Conn, sess *session, ips []net.IP) bool {
+	if s.Hook == nil {
+		return true
+	}
+	err := s.Hook.PreRelay(sess.ctx, ConnInfo{
+		ClientAddr: client.RemoteAddr(),
+		Version:    sess.version,
+		Command:    sess.command,
+		TargetAddr: sess.target,
+		TargetIPs:  ips,
+		Identity:   sess.identity,
+	})
+	if err != nil {
+		sess.outcome = outcomeRejected
+		sess.log.Warn("Connection refused by hook", "remote_addr", client.RemoteAddr().String(), "target_addr", sess.target, "error", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package gosocks_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+type hookFunc func(ctx context.Context, info gosocks.ConnInfo) error
+
+func (f hookFunc) PreRelay(ctx context.Context, info gosocks.ConnInfo) error {
+	return f(ctx, info)
+}
+
+// blockIP refuses the requests for a target which resolves to ip.
+func blockIP(ip net.IP) gosocks.Hook {
+	return hookFunc(func(ctx context.Context, info gosocks.ConnInfo) error {
+		for _, target := range info.TargetIPs {
+			if target.Equal(ip) {
+				return errors.New("blocked")
+			}
+		}
+		return nil
+	})
+}
+
+func TestHook(t *testing.T) {
+	target := gosockstest.NewEchoServer(t)
+	_, port, _ := net.SplitHostPort(target)
+	byName := net.JoinHostPort("localhost", port)
+
+	tests := []struct {
+		name   string
+		block  net.IP
+		target string
+		rep    byte
+	}{
+		{"allowed", net.IPv4(192, 0, 2, 1), target, 0x00},
+		{"blocked", net.IPv4(127, 0, 0, 1), target, 0x02},
+		{"blocked once resolved", net.IPv4(127, 0, 0, 1), byName, 0x02},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &recordingHook{}
+			srv := gosockstest.NewServer(t, func(s *gosocks.Server) {
+				s.Hook = gosocks.MultiHook{blockIP(tt.block), recorder}
+			})
+			conn := dialProxy(t, srv)
+			negotiate(t, conn, 0x00)
+			rep, _, _ := request(t, conn, 0x01, socksAddr(t, tt.target))
+			if rep != tt.rep {
+				t.Fatalf("reply = %#x, want %#x", rep, tt.rep)
+			}
+
+			// The hooks after the one refusing the request are not called.
+			recorder.mu.Lock()
+			defer recorder.mu.Unlock()
+			want := 0
+			if tt.rep == 0x00 {
+				want = 1
+			}
+			if len(recorder.infos) != want {
+				t.Fatalf("the second hook was called %d times, want %d", len(recorder.infos), want)
+			}
+			if want == 1 && recorder.infos[0].TargetAddr != tt.target {
+				t.Errorf("TargetAddr = %q, want %q", recorder.infos[0].TargetAddr, tt.target)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package gosocks
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// handleHTTPConnect serves a client speaking HTTP CONNECT instead of SOCKS,
+// first being the byte already read by handleConn.
+func (s *Server) handleHTTPConnect(client net.Conn, first byte, sess *session) {
+	addr := client.RemoteAddr().String()
+
+	reader := bufio.NewReader(io.MultiReader(bytes.NewReader([]byte{first}), client))
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		sess.log.Warn("Failed to read the HTTP request", "remote_addr", addr, "error", err)
+		writeHTTPStatus(client, http.StatusBadRequest)
+		return
+	}
+	if req.Method != http.MethodConnect {
+		sess.log.Warn("Only implemented CONNECT method for HTTP currently", "remote_addr", addr, "method", req.Method)
+		writeHTTPStatus(client, http.StatusMethodNotAllowed)
+		return
+	}
+	sess.command = "connect"
+	sess.requested = time.Now()
+	sess.target = req.Host
+
+	s.authenticateCert(client, sess)
+	switch {
+	case s.anonymousAllowed(sess):
+	case s.methodNegotiation([]byte{0x02}, sess) != 0x02:
+		sess.outcome = outcomeAuthFail
+		sess.log.Warn("The server requires an authentication HTTP CONNECT does not support", "remote_addr", addr)
+		writeHTTPStatus(client, http.StatusForbidden)
+		return
+	default:
+		user, password, ok := parseProxyAuthorization(req.Header.Get("Proxy-Authorization"))
+		if !ok {
+			sess.outcome = outcomeAuthFail
+			sess.log.Warn("The client does not offer the required method", "remote_addr", addr, "method", "basic")
+			client.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Basic realm=\"gosocks\"\r\n\r\n"))
+			return
+		}
+		sess.identity, err = sess.auth.Authenticate(user, password)
+		if err != nil {
+			sess.outcome = outcomeAuthFail
+			sess.log.Warn("Authentication failed", "remote_addr", addr, "user", user, "error", err)
+			s.publishAuth(sess, "basic", false)
+			client.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Basic realm=\"gosocks\"\r\n\r\n"))
+			return
+		}
+		s.publishAuth(sess, "basic", true)
+	}
+
+	if s.Quotas.exceeded(sess.identity) {
+		sess.outcome = outcomeRejected
+		sess.log.Warn("Quota exceeded", "remote_addr", addr, "user", sess.identity)
+		writeHTTPStatus(client, http.StatusForbidden)
+		return
+	}
+
+	host, portString, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		sess.log.Warn("Failed to parse requested address", "remote_addr", addr, "target_addr", req.Host, "error", err)
+		writeHTTPStatus(client, http.StatusBadRequest)
+		return
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil || port <= 0 || port > 0xFFFF {
+		sess.log.Warn("Invalid requested port", "remote_addr", addr, "target_addr", req.Host)
+		writeHTTPStatus(client, http.StatusBadRequest)
+		return
+	}
+
+	var targetHost string
+	if net.ParseIP(host) == nil {
+		targetHost = host
+	}
+	if !sess.acl.AllowClient(addrIP(client.RemoteAddr())) || !sess.acl.AllowIdentity(sess.identity) || !sess.acl.AllowTarget(targetHost, nil) {
+		sess.outcome = outcomeRejected
+		sess.log.Warn("Connection not allowed by ruleset", "remote_addr", addr, "target_addr", req.Host)
+		writeHTTPStatus(client, http.StatusForbidden)
+		return
+	}
+
+	ips, err := s.lookupIP(sess, host)
+	if err != nil || len(ips) == 0 {
+		sess.outcome = outcomeDNSFail
+		sess.log.Warn("Failed to resolve requested host", "remote_addr", addr, "target_addr", host, "error", err)
+		writeHTTPStatus(client, http.StatusBadGateway)
+		return
+	}
+	var targetIPs []net.IP
+	for _, ip := range ips {
+		if sess.acl.AllowTarget(targetHost, ip) {
+			targetIPs = append(targetIPs, ip)
+		}
+	}
+	if len(targetIPs) == 0 {
+		sess.outcome = outcomeRejected
+		sess.log.Warn("Connection not allowed by ruleset", "remote_addr", addr, "target_addr", req.Host)
+		writeHTTPStatus(client, http.StatusForbidden)
+		return
+	}
+	sess.log.Info("Requested address", "remote_addr", addr, "target_addr", req.Host)
+	if !s.preRelay(client, sess, targetIPs) {
+		writeHTTPStatus(client, http.StatusForbidden)
+		return
+	}
+
+	var remote net.Conn
+	if targetHost != "" && sendsHostNames(s.dialer()) {
+		remote, err = s.dial(sess.ctx, "tcp", req.Host)
+	} else {
+		remote, err = s.dialHappyEyeballs(sess.ctx, targetIPs, port)
+	}
+	if err != nil {
+		s.dialFailed(sess, err)
+		sess.log.Warn("Failed to connect to the requested address", "remote_addr", addr, "target_addr", req.Host, "error", err)
+		writeHTTPStatus(client, http.StatusBadGateway)
+		return
+	}
+	defer remote.Close()
+	s.connected(sess, remote)
+
+	_, err = client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	if err != nil {
+		sess.log.Warn("Failed to write reply", "remote_addr", addr, "error", err)
+		return
+	}
+
+	s.relay(&bufferedConn{client, reader}, remote, sess)
+}
+
+func writeHTTPStatus(client net.Conn, code int) {
+	client.Write([]byte("HTTP/1.1 " + strconv.Itoa(code) + " " + http.StatusText(code) + "\r\nConnection: close\r\n\r\n"))
+}
+
+func parseProxyAuthorization(header string) (user, password string, ok bool) {
+	if header == "" {
+		return "", "", false
+	}
+	req := http.Request{Header: http.Header{"Authorization": {header}}}
+	return req.BasicAuth()
+}
+
+// bufferedConn reads what the HTTP parser has buffered before reading from
+// the connection itself.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *bufferedConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
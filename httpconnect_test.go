@@ -0,0 +1,54 @@
+package gosocks_test
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+func TestHTTPConnectAuthentication(t *testing.T) {
+	httpConnect := func(s *gosocks.Server) { s.HTTPConnect = true }
+	totp := func(s *gosocks.Server) {
+		s.TOTP = &gosocks.TOTPAuthenticator{Secrets: map[string][]byte{"alice": []byte("12345678901234567890")}}
+	}
+	target := gosockstest.NewEchoServer(t)
+
+	tests := []struct {
+		name        string
+		opts        []gosockstest.Option
+		credentials string
+		status      int
+	}{
+		{"no authentication", nil, "", http.StatusOK},
+		{"password without credentials", []gosockstest.Option{gosockstest.WithAuth("alice", "secret")}, "", http.StatusProxyAuthRequired},
+		{"password with credentials", []gosockstest.Option{gosockstest.WithAuth("alice", "secret")}, "alice:secret", http.StatusOK},
+		{"password with wrong credentials", []gosockstest.Option{gosockstest.WithAuth("alice", "secret")}, "alice:guess", http.StatusProxyAuthRequired},
+		{"TOTP only", []gosockstest.Option{totp}, "alice:secret", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := gosockstest.NewServer(t, append(tt.opts, httpConnect)...)
+			conn := dialProxy(t, srv)
+
+			req := "CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n"
+			if tt.credentials != "" {
+				req += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(tt.credentials)) + "\r\n"
+			}
+			_, err := conn.Write([]byte(req + "\r\n"))
+			if err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatalf("ReadResponse: %v", err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package gosocks
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyWindow is how many of the last connections DestinationStats are
+// computed from.
+const latencyWindow = 1000
+
+// DestinationStat is the dial latency of a destination over the last
+// connections, as listed by the admin API, the durations in seconds.
+type DestinationStat struct {
+	TargetAddr  string  `json:"target_addr"`
+	Connections int     `json:"connections"`
+	AvgDial     float64 `json:"avg_dial_seconds"`
+	P99Dial     float64 `json:"p99_dial_seconds"`
+}
+
+type latencySample struct {
+	target string
+	dial   time.Duration
+}
+
+// latencyStats keeps the dial durations of the last latencyWindow
+// connections which reached their target, in a ring. The zero value is ready
+// to use.
+type latencyStats struct {
+	mu      sync.Mutex
+	samples []latencySample
+	next    int
+}
+
+func (l *latencyStats) record(target string, dial time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.samples) < latencyWindow {
+		l.samples = append(l.samples, latencySample{target, dial})
+		return
+	}
+	l.samples[l.next] = latencySample{target, dial}
+	l.next = (l.next + 1) % latencyWindow
+}
+
+// recordLatency adds the dial duration of sess, if it reached its target, to
+// the DestinationStats.
+func (s *Server) recordLatency(sess *session) {
+	if sess.dialDuration > 0 && sess.target != "" {
+		s.latencies.record(sess.target, sess.dialDuration)
+	}
+}
+
+// DestinationStats returns the dial latency of the destinations of the last
+// 1000 connections which reached their target, the most connected to first.
+func (s *Server) DestinationStats() []DestinationStat {
+	s.latencies.mu.Lock()
+	byTarget := make(map[string][]time.Duration)
+	for _, sample := range s.latencies.samples {
+		byTarget[sample.target] = append(byTarget[sample.target], sample.dial)
+	}
+	s.latencies.mu.Unlock()
+
+	stats := make([]DestinationStat, 0, len(byTarget))
+	for target, dials := range byTarget {
+		slices.Sort(dials)
+		var total time.Duration
+		for _, d := range dials {
+			total += d
+		}
+		p99 := dials[min(len(dials)*99/100, len(dials)-1)]
+		stats = append(stats, DestinationStat{
+			TargetAddr:  target,
+			Connections: len(dials),
+			AvgDial:     (total / time.Duration(len(dials))).Seconds(),
+			P99Dial:     p99.Seconds(),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Connections != stats[j].Connections {
+			return stats[i].Connections > stats[j].Connections
+		}
+		return stats[i].TargetAddr < stats[j].TargetAddr
+	})
+	return stats
+}
@@ -0,0 +1,81 @@
+package gosocks_test
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// TestDestinationStats checks that GET /stats/destinations ranks the targets
+// of the completed connections, with their dial latency.
+func TestDestinationStats(t *testing.T) {
+	fast := gosockstest.NewEchoServer(t)
+	slow := gosockstest.NewEchoServer(t)
+	srv := gosockstest.NewServer(t, gosockstest.WithDialer(gosockstest.DialerFunc(func(network, addr string) (net.Conn, error) {
+		if addr == slow {
+			time.Sleep(50 * time.Millisecond)
+		}
+		return net.Dial(network, addr)
+	})))
+	ts := httptest.NewServer(gosocks.NewAdminHandler(srv.Config, ""))
+	defer ts.Close()
+
+	for i := range 10 {
+		target := fast
+		if i%3 == 0 {
+			target = slow
+		}
+		conn, err := client.Dial(srv.Addr(), target)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		echo(t, conn, "hello")
+		conn.Close()
+	}
+
+	// The connections are recorded once the server is done with them.
+	var stats []gosocks.DestinationStat
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		code, body := adminRequest(t, ts, "", "GET", "/stats/destinations", "")
+		stats = nil
+		if err := json.Unmarshal(body, &stats); code != http.StatusOK || err != nil {
+			t.Fatalf("GET /stats/destinations = %d %s (%v)", code, body, err)
+		}
+		total := 0
+		for _, s := range stats {
+			total += s.Connections
+		}
+		if total == 10 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("GET /stats/destinations counts %d connections, want 10", total)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(stats) != 2 {
+		t.Fatalf("GET /stats/destinations = %+v, want 2 destinations", stats)
+	}
+	if stats[0].TargetAddr != fast || stats[0].Connections != 6 || stats[1].TargetAddr != slow || stats[1].Connections != 4 {
+		t.Errorf("GET /stats/destinations = %+v, want %s with 6 connections then %s with 4", stats, fast, slow)
+	}
+	for _, s := range stats {
+		if s.AvgDial <= 0 || s.P99Dial < s.AvgDial {
+			t.Errorf("%s: avg %vs, p99 %vs, want 0 < avg <= p99", s.TargetAddr, s.AvgDial, s.P99Dial)
+		}
+	}
+	if s := stats[1]; s.AvgDial < 0.05 {
+		t.Errorf("%s: avg %vs, want at least the 50ms of the dial", s.TargetAddr, s.AvgDial)
+	}
+	if stats[0].AvgDial >= stats[1].AvgDial {
+		t.Errorf("avg dial of %s = %vs, want below the %vs of %s", fast, stats[0].AvgDial, stats[1].AvgDial, slow)
+	}
+}
@@ -0,0 +1,80 @@
+package gosocks_test
+
+import (
+	"io"
+	"net"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// TestNoGoroutineLeak opens 1000 connections which stop at the different
+// stages of the handshake, or complete it, and checks that the server is
+// back to its goroutines of before. It is meant to run with -race too.
+func TestNoGoroutineLeak(t *testing.T) {
+	srv := gosockstest.NewServer(t, gosockstest.WithAuth("user", "pass"))
+	target := gosockstest.NewEchoServer(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	refused := l.Addr().String()
+	l.Close()
+
+	auth := "\x05\x01\x02\x01\x04user\x04pass"
+	scripts := []string{
+		"",
+		"\x05",
+		"\x06\x01\x00",
+		"\x05\x01\x00",
+		"\x05\x01\x02\x01\x04user\x05wrong",
+		auth + "\x05\x01\x00\x03",
+		auth + "\x05\x09\x00" + string(socksAddr(t, target)),
+		auth + "\x05\x01\x00" + string(socksAddr(t, refused)),
+		auth + "\x05\x01\x00" + string(socksAddr(t, target)) + "hello",
+	}
+
+	baseline := runtime.NumGoroutine()
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 50)
+	for i := 0; i < 1000; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(script string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			conn, err := net.Dial("tcp", srv.Addr())
+			if err != nil {
+				t.Errorf("Dial: %v", err)
+				return
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			if script == "" {
+				return
+			}
+			conn.Write([]byte(script))
+			conn.(*net.TCPConn).CloseWrite()
+			_, err = io.Copy(io.Discard, conn)
+			if err != nil && strings.Contains(err.Error(), "timeout") {
+				t.Errorf("the server did not close the connection of %q", script)
+			}
+		}(scripts[i%len(scripts)])
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			var stacks strings.Builder
+			pprof.Lookup("goroutine").WriteTo(&stacks, 1)
+			t.Fatalf("%d goroutines left, %d before the connections:\n%s", runtime.NumGoroutine(), baseline, stacks.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
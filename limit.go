@@ -0,0 +1,81 @@
+package gosocks
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+var ErrByteLimitExceeded = errors.New("gosocks: byte limit of the connection exceeded")
+
+// A LimitedConn lets at most Limit bytes through its Read and Write methods
+// together. Once they are used up, Read returns io.EOF and Write writes what
+// still fits then returns ErrByteLimitExceeded. A concurrent Read and Write
+// may go slightly over the limit.
+type LimitedConn struct {
+	net.Conn
+	Limit int64
+
+	used atomic.Int64
+}
+
+// NewLimitedConn wraps conn with a limit of limit bytes.
+func NewLimitedConn(conn net.Conn, limit int64) *LimitedConn {
+	return &LimitedConn{Conn: conn, Limit: limit}
+}
+
+// Used returns how many bytes went through c.
+func (c *LimitedConn) Used() int64 {
+	return c.used.Load()
+}
+
+func (c *LimitedConn) remaining() int64 {
+	return c.Limit - c.used.Load()
+}
+
+func (c *LimitedConn) Read(b []byte) (int, error) {
+	left := c.remaining()
+	if left <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > left {
+		b = b[:left]
+	}
+	n, err := c.Conn.Read(b)
+	c.used.Add(int64(n))
+	if err != nil && c.remaining() <= 0 {
+		// woken up by Write below
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (c *LimitedConn) Write(b []byte) (int, error) {
+	left := c.remaining()
+	truncated := int64(len(b)) > left
+	if truncated {
+		b = b[:max(left, 0)]
+	}
+	n, err := c.Conn.Write(b)
+	c.used.Add(int64(n))
+	if err == nil && truncated {
+		// Wake up a Read blocked on the client.
+		c.Conn.SetReadDeadline(time.Now())
+		err = ErrByteLimitExceeded
+	}
+	return n, err
+}
+
+func (c *LimitedConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
+
+// byteLimit returns the limit of the connections of identity.
+func (s *Server) byteLimit(identity string) int64 {
+	if limit, ok := s.UserMaxBytesPerConn[identity]; ok && identity != "" {
+		return limit
+	}
+	return s.MaxBytesPerConn
+}
@@ -0,0 +1,57 @@
+package gosocks_test
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// TestByteLimit checks that the relay stops at exactly the byte limit of
+// the connection, the one of its user if there is one, counting both
+// directions.
+func TestByteLimit(t *testing.T) {
+	srv := gosockstest.NewServer(t, func(s *gosocks.Server) {
+		s.Auth = gosocks.Credentials{"alice": "secret", "bob": "secret"}
+		s.MaxBytesPerConn = 1000
+		s.UserMaxBytesPerConn = map[string]int64{"alice": 300}
+	})
+	target := gosockstest.NewTarget(t, func(conn net.Conn) {
+		// The 100 bytes of the client come first.
+		io.ReadFull(conn, make([]byte, 100))
+		conn.Write(make([]byte, 4000))
+		io.Copy(io.Discard, conn)
+	})
+	tests := []struct {
+		username string
+		limit    int
+	}{
+		{"alice", 300},
+		{"bob", 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			dialer := &client.Dialer{ProxyAddr: srv.Addr(), Username: tt.username, Password: "secret"}
+			conn, err := dialer.Dial("tcp", target)
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			if _, err := conn.Write(make([]byte, 100)); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			received, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+			if want := tt.limit - 100; len(received) != want {
+				t.Errorf("received %d bytes, want %d past the 100 sent", len(received), want)
+			}
+		})
+	}
+}
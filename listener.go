@@ -0,0 +1,34 @@
+package gosocks
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// A ListenerFactory opens a listener on addr.
+type ListenerFactory func(addr string) (net.Listener, error)
+
+// unixScheme prefixes the addresses of Unix domain sockets, as in
+// "unix:///run/gosocks.sock".
+const unixScheme = "unix://"
+
+// UnixFactory listens on a Unix domain socket. The path may be prefixed by
+// "unix://". The socket file is removed when the listener is closed.
+func UnixFactory(addr string) (net.Listener, error) {
+	return net.ListenUnix("unix", &net.UnixAddr{Name: strings.TrimPrefix(addr, unixScheme), Net: "unix"})
+}
+
+// listenTCP is the default ListenerFactory, which sets up the sockets for
+// TProxy if needed.
+func (s *Server) listenTCP(addr string) (net.Listener, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	var lc net.ListenConfig
+	if s.TProxy {
+		lc.Control = transparentControl
+	}
+	return lc.Listen(context.Background(), "tcp", tcpAddr.String())
+}
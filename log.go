@@ -0,0 +1,55 @@
+package gosocks
+
+import (
+	"log/slog"
+)
+
+// Logger receives the events of the server as a message and a list of
+// alternating keys and values, such as "remote_addr", "target_addr",
+// "bytes_sent", "bytes_recv", "duration" and "error". A *slog.Logger
+// satisfies this interface.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+func (s *Server) logger() Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+// requestLogger adds the request ID of a connection, and the one sent by the
+// client if any, to all its events.
+type requestLogger struct {
+	Logger
+	id       string
+	clientID string
+}
+
+func (l requestLogger) args(args []any) []any {
+	args = append(args, "request_id", l.id)
+	if l.clientID != "" {
+		args = append(args, "client_request_id", l.clientID)
+	}
+	return args
+}
+
+func (l requestLogger) Debug(msg string, args ...any) {
+	l.Logger.Debug(msg, l.args(args)...)
+}
+
+func (l requestLogger) Info(msg string, args ...any) {
+	l.Logger.Info(msg, l.args(args)...)
+}
+
+func (l requestLogger) Warn(msg string, args ...any) {
+	l.Logger.Warn(msg, l.args(args)...)
+}
+
+func (l requestLogger) Error(msg string, args ...any) {
+	l.Logger.Error(msg, l.args(args)...)
+}
@@ -0,0 +1,43 @@
+package gosocks_test
+
+import (
+	"testing"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+func TestMethodNegotiation(t *testing.T) {
+	tests := []struct {
+		name     string
+		password bool
+		order    []byte
+		offered  []byte
+		want     byte
+	}{
+		{"password preferred by default", true, nil, []byte{0x02, 0x00}, 0x02},
+		{"password preferred, offered last", true, []byte{0x02, 0x00}, []byte{0x00, 0x02}, 0x02},
+		{"no authentication preferred", true, []byte{0x00, 0x02}, []byte{0x02, 0x00}, 0x00},
+		{"fallback to no authentication", true, []byte{0x02, 0x00}, []byte{0x00}, 0x00},
+		{"password required", true, nil, []byte{0x00}, 0xFF},
+		{"no method enabled", true, nil, []byte{0x01, 0x03, 0x80}, 0xFF},
+		{"no authentication only", false, nil, []byte{0x02}, 0xFF},
+		{"no authentication", false, nil, []byte{0x02, 0x00}, 0x00},
+		{"empty list", false, nil, nil, 0xFF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []gosockstest.Option{func(s *gosocks.Server) {
+				s.AuthMethods = tt.order
+			}}
+			if tt.password {
+				opts = append(opts, gosockstest.WithAuth("user", "pass"))
+			}
+			srv := gosockstest.NewServer(t, opts...)
+			conn := dialProxy(t, srv)
+			if got := negotiate(t, conn, tt.offered...); got != tt.want {
+				t.Errorf("method = %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+}
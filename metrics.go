@@ -0,0 +1,85 @@
+package gosocks
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics holds the Prometheus collectors updated by a Server. A nil
+// *Metrics records nothing.
+type Metrics struct {
+	connections         *prometheus.CounterVec
+	active              prometheus.Gauge
+	bytesClientToRemote prometheus.Histogram
+	bytesRemoteToClient prometheus.Histogram
+	dnsDuration         *prometheus.HistogramVec
+}
+
+// NewMetrics creates the collectors and registers them with reg.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	bytesBuckets := prometheus.ExponentialBuckets(1024, 4, 11)
+	m := &Metrics{
+		connections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gosocks_connections_total",
+			Help: "Number of client connections handled, by outcome.",
+		}, []string{"outcome"}),
+		active: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "gosocks_connections_active",
+			Help: "Number of client connections currently handled.",
+		}),
+		bytesClientToRemote: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "gosocks_bytes_client_to_remote_total",
+			Help:    "Bytes relayed from the client to the remote, per connection.",
+			Buckets: bytesBuckets,
+		}),
+		bytesRemoteToClient: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "gosocks_bytes_remote_to_client_total",
+			Help:    "Bytes relayed from the remote to the client, per connection.",
+			Buckets: bytesBuckets,
+		}),
+		dnsDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "gosocks_dns_resolution_duration_seconds",
+			Help:    "Time taken to resolve the requested host names, by resolver and outcome.",
+			Buckets: prometheus.ExponentialBucketsRange(0.001, 10, 14),
+		}, []string{"resolver", "outcome"}),
+	}
+	reg.MustRegister(m.connections, m.active, m.bytesClientToRemote, m.bytesRemoteToClient, m.dnsDuration)
+	return m
+}
+
+func (m *Metrics) connectionStarted() {
+	if m == nil {
+		return
+	}
+	m.active.Inc()
+}
+
+func (m *Metrics) connectionFinished(outcome string) {
+	if m == nil {
+		return
+	}
+	m.active.Dec()
+	m.connections.WithLabelValues(outcome).Inc()
+}
+
+func (m *Metrics) relayFinished(sent, recv int64) {
+	if m == nil {
+		return
+	}
+	m.bytesClientToRemote.Observe(float64(sent))
+	m.bytesRemoteToClient.Observe(float64(recv))
+}
+
+// dnsResolved records a lookup by a resolver of the given kind, see
+// resolverKind.
+func (m *Metrics) dnsResolved(resolver string, err error, d time.Duration) {
+	if m == nil {
+		return
+	}
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	m.dnsDuration.WithLabelValues(resolver, outcome).Observe(d.Seconds())
+}
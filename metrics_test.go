@@ -0,0 +1,76 @@
+package gosocks_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// scrape returns the metrics served by ts.
+func scrape(t *testing.T, ts *httptest.Server) string {
+	t.Helper()
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	srv := gosockstest.NewServer(t, func(s *gosocks.Server) {
+		s.Metrics = gosocks.NewMetrics(reg)
+	})
+	ts := httptest.NewServer(promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	defer ts.Close()
+
+	conn, err := client.Dial(srv.Addr(), gosockstest.NewEchoServer(t))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	echo(t, conn, "hello")
+	if metrics := scrape(t, ts); !strings.Contains(metrics, "gosocks_connections_active 1\n") {
+		t.Errorf("metrics do not count the active connection:\n%s", metrics)
+	}
+	conn.Close()
+
+	// The session is counted once the relay has ended.
+	want := []string{
+		`gosocks_connections_total{outcome="success"} 1` + "\n",
+		"gosocks_connections_active 0\n",
+		"gosocks_bytes_client_to_remote_total_sum 5\n",
+		"gosocks_bytes_remote_to_client_total_sum 5\n",
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		metrics := scrape(t, ts)
+		missing := ""
+		for _, w := range want {
+			if !strings.Contains(metrics, w) {
+				missing = w
+				break
+			}
+		}
+		if missing == "" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("metrics do not contain %q:\n%s", missing, metrics)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,126 @@
+package gosocks
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Mirror sends a copy of the relayed TCP payloads to a UDP endpoint, for
+// traffic analysis. Each datagram is a frame made of:
+//
+//	1 byte    version, 1
+//	1 byte    direction, 0 from the client to the target, 1 back
+//	16 bytes  connection ID, the UUID of RequestID
+//	8 bytes   sequence number, the offset of the payload in its direction
+//	n bytes   payload, at most 8 KiB
+//
+// The integers are big-endian. The frames are sent from a single goroutine,
+// and dropped when it cannot keep up, so that the relay is never slowed down;
+// a gap in the sequence numbers reveals the loss.
+type Mirror struct {
+	conn    net.Conn
+	frames  chan []byte
+	dropped atomic.Int64
+	stop    chan struct{}
+	once    sync.Once
+}
+
+const (
+	mirrorVersion    = 1
+	mirrorHeaderLen  = 26
+	mirrorMaxPayload = 8 * 1024
+	mirrorQueueLen   = 1024
+)
+
+// NewMirror returns a Mirror sending the frames to addr, a "host:port" UDP
+// address.
+func NewMirror(addr string) (*Mirror, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	m := &Mirror{
+		conn:   conn,
+		frames: make(chan []byte, mirrorQueueLen),
+		stop:   make(chan struct{}),
+	}
+	go m.send()
+	return m, nil
+}
+
+func (m *Mirror) send() {
+	for {
+		select {
+		case frame := <-m.frames:
+			m.conn.Write(frame)
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// Close stops sending the frames, the ones still queued are dropped.
+func (m *Mirror) Close() error {
+	err := net.ErrClosed
+	m.once.Do(func() {
+		close(m.stop)
+		err = m.conn.Close()
+	})
+	return err
+}
+
+// Dropped returns how many frames were dropped because the queue was full.
+func (m *Mirror) Dropped() int64 {
+	return m.dropped.Load()
+}
+
+func (m *Mirror) queue(id *[16]byte, direction byte, seq int64, payload []byte) {
+	for len(payload) > 0 {
+		n := min(len(payload), mirrorMaxPayload)
+		frame := make([]byte, mirrorHeaderLen+n)
+		frame[0] = mirrorVersion
+		frame[1] = direction
+		copy(frame[2:18], id[:])
+		binary.BigEndian.PutUint64(frame[18:26], uint64(seq))
+		copy(frame[mirrorHeaderLen:], payload[:n])
+		select {
+		case m.frames <- frame:
+		default:
+			m.dropped.Add(1)
+		}
+		payload = payload[n:]
+		seq += int64(n)
+	}
+}
+
+// mirrorConn queues a copy of what is read from the connection.
+type mirrorConn struct {
+	net.Conn
+	mirror    *Mirror
+	id        [16]byte
+	direction byte
+	seq       int64
+}
+
+func newMirrorConn(conn net.Conn, m *Mirror, requestID string, direction byte) *mirrorConn {
+	c := &mirrorConn{Conn: conn, mirror: m, direction: direction}
+	hex.Decode(c.id[:], []byte(strings.ReplaceAll(requestID, "-", "")))
+	return c
+}
+
+func (c *mirrorConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.mirror.queue(&c.id, c.direction, c.seq, b[:n])
+		c.seq += int64(n)
+	}
+	return n, err
+}
+
+func (c *mirrorConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
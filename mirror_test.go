@@ -0,0 +1,89 @@
+package gosocks_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// TestMirror checks that the frames received by the mirror endpoint
+// reconstruct the byte stream of both directions, in order.
+func TestMirror(t *testing.T) {
+	capture, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	defer capture.Close()
+	mirror, err := gosocks.NewMirror(capture.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("NewMirror: %v", err)
+	}
+	defer mirror.Close()
+	srv := gosockstest.NewServer(t, func(s *gosocks.Server) {
+		s.Mirror = mirror
+	})
+
+	data := make([]byte, 50*1024)
+	rand.Read(data)
+	conn, err := client.Dial(srv.Addr(), gosockstest.NewEchoServer(t))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < len(data); i += 3000 {
+		if _, err := conn.Write(data[i:min(i+3000, len(data))]); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if _, err := io.ReadFull(conn, make([]byte, len(data))); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+
+	var streams [2][]byte
+	var id []byte
+	capture.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 65535)
+	for len(streams[0]) < len(data) || len(streams[1]) < len(data) {
+		n, err := capture.Read(buf)
+		if err != nil {
+			t.Fatalf("got %d and %d bytes of the streams: %v", len(streams[0]), len(streams[1]), err)
+		}
+		frame := buf[:n]
+		if n < 26 || frame[0] != 1 || frame[1] > 1 {
+			t.Fatalf("invalid frame header %x", frame[:min(n, 26)])
+		}
+		if id == nil {
+			id = bytes.Clone(frame[2:18])
+		} else if !bytes.Equal(frame[2:18], id) {
+			t.Fatalf("frame of the connection %x, want %x", frame[2:18], id)
+		}
+		direction, seq, payload := frame[1], binary.BigEndian.Uint64(frame[18:26]), frame[26:]
+		if len(payload) > 8*1024 {
+			t.Errorf("payload of %d bytes, want at most 8 KiB", len(payload))
+		}
+		if seq != uint64(len(streams[direction])) {
+			t.Fatalf("frame at %d in the direction %d, want the next one at %d", seq, direction, len(streams[direction]))
+		}
+		streams[direction] = append(streams[direction], payload...)
+	}
+	if bytes.Equal(id, make([]byte, 16)) {
+		t.Error("the frames have no connection ID")
+	}
+	for direction, stream := range streams {
+		if !bytes.Equal(stream, data) {
+			t.Errorf("the stream of the direction %d differs from the relayed one", direction)
+		}
+	}
+	if dropped := mirror.Dropped(); dropped != 0 {
+		t.Errorf("%d frames dropped", dropped)
+	}
+}
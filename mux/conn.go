@@ -0,0 +1,165 @@
+package mux
+
+import (
+	"net"
+	"sync"
+)
+
+// A MuxClient opens streams over a connection to a mux listener.
+type MuxClient struct {
+	sess *session
+}
+
+// NewClient starts a mux session over conn, which it owns from now on.
+func NewClient(conn net.Conn) *MuxClient {
+	return &MuxClient{sess: newSession(conn, true)}
+}
+
+// Open opens a new stream, on which the client then speaks SOCKS5 as over a
+// connection of its own, for instance with client.Handshake of gosocks.
+func (c *MuxClient) Open() (net.Conn, error) {
+	return c.sess.open()
+}
+
+// Close closes the connection, aborting the open streams.
+func (c *MuxClient) Close() error {
+	c.sess.close(ErrSessionClosed)
+	return nil
+}
+
+// Done is closed once the session is over.
+func (c *MuxClient) Done() <-chan struct{} {
+	return c.sess.done
+}
+
+// A MuxServer is a net.Listener for the streams opened by the MuxClient at
+// the other end of a connection.
+type MuxServer struct {
+	sess *session
+}
+
+// NewServer starts a mux session over conn, which it owns from now on.
+func NewServer(conn net.Conn) *MuxServer {
+	return &MuxServer{sess: newSession(conn, false)}
+}
+
+// Accept waits for the next stream.
+func (m *MuxServer) Accept() (net.Conn, error) {
+	select {
+	case st := <-m.sess.accept:
+		return st, nil
+	case <-m.sess.done:
+		return nil, m.sess.closeErr()
+	}
+}
+
+// Close closes the connection, aborting the streams.
+func (m *MuxServer) Close() error {
+	m.sess.close(ErrSessionClosed)
+	return nil
+}
+
+// Addr returns the local address of the connection.
+func (m *MuxServer) Addr() net.Addr {
+	return m.sess.conn.LocalAddr()
+}
+
+// Listen returns a listener accepting the streams of all the mux sessions
+// started by the connections l accepts. Closing it closes l, and each session
+// is closed when the last of its streams accepted by then is, the streams
+// opened meanwhile being reset.
+func Listen(l net.Listener) net.Listener {
+	ml := &listener{
+		Listener: l,
+		streams:  make(chan net.Conn),
+		done:     make(chan struct{}),
+		sessions: make(map[*MuxServer]struct{}),
+	}
+	go ml.acceptLoop()
+	return ml
+}
+
+type listener struct {
+	net.Listener
+	streams chan net.Conn
+	done    chan struct{}
+	once    sync.Once
+
+	mu       sync.Mutex
+	closed   bool
+	err      error
+	sessions map[*MuxServer]struct{}
+}
+
+func (l *listener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			l.mu.Lock()
+			if !l.closed {
+				l.err = err
+			}
+			l.mu.Unlock()
+			l.Close()
+			return
+		}
+		server := NewServer(conn)
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			server.Close()
+			continue
+		}
+		l.sessions[server] = struct{}{}
+		l.mu.Unlock()
+		go l.serve(server)
+	}
+}
+
+func (l *listener) serve(server *MuxServer) {
+	defer func() {
+		l.mu.Lock()
+		delete(l.sessions, server)
+		l.mu.Unlock()
+	}()
+	for {
+		st, err := server.Accept()
+		if err != nil {
+			return
+		}
+		select {
+		case l.streams <- st:
+		case <-l.done:
+			st.Close()
+		}
+	}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case st := <-l.streams:
+		return st, nil
+	case <-l.done:
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.err != nil {
+			return nil, l.err
+		}
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *listener) Close() error {
+	var err error
+	l.once.Do(func() {
+		l.mu.Lock()
+		l.closed = true
+		for server := range l.sessions {
+			server.sess.drain()
+		}
+		l.mu.Unlock()
+		err = l.Listener.Close()
+		close(l.done)
+	})
+	return err
+}
@@ -0,0 +1,492 @@
+// Package mux multiplexes streams over a single connection, so that the
+// clients behind a high-latency link pay for the TCP (and TLS) handshake with
+// the proxy once instead of once per SOCKS5 connection. Listen turns the
+// connections of a listener into streams which a gosocks.Server serves like
+// any other connection, and a MuxClient opens them.
+//
+// Every frame starts with a 10-byte header: a 4-byte stream ID, 2 bytes of
+// flags and a 4-byte payload length, all big-endian. A stream is opened by a
+// frame with SYN, its data is carried by the frames without flags, and each
+// side ends its direction with FIN. RST aborts a stream. A frame with WND
+// carries a 4-byte increment of the flow control window of its stream: a
+// side may only send initialWindow bytes on a stream before the other side
+// credits them back, so that a slow stream does not stall the others.
+package mux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	flagSYN uint16 = 1 << iota
+	flagFIN
+	flagRST
+	flagWND
+)
+
+const (
+	headerLen     = 10
+	maxPayload    = 32 * 1024
+	initialWindow = 256 * 1024
+
+	// acceptBacklog is how many opened streams may wait for Accept before
+	// the session stops reading its connection.
+	acceptBacklog = 64
+)
+
+var (
+	ErrSessionClosed = errors.New("mux: session closed")
+	ErrStreamReset   = errors.New("mux: stream reset by peer")
+)
+
+// session is the state shared by the streams of one connection.
+type session struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	streams  map[uint32]*stream
+	nextID   uint32
+	accept   chan *stream
+	draining bool
+	err      error
+	done     chan struct{}
+}
+
+func newSession(conn net.Conn, client bool) *session {
+	s := &session{
+		conn:    conn,
+		streams: make(map[uint32]*stream),
+		done:    make(chan struct{}),
+	}
+	if client {
+		s.nextID = 1
+	} else {
+		s.accept = make(chan *stream, acceptBacklog)
+	}
+	go s.readLoop()
+	return s
+}
+
+func (s *session) writeFrame(id uint32, flags uint16, payload []byte) error {
+	var header [headerLen]byte
+	binary.BigEndian.PutUint32(header[0:4], id)
+	binary.BigEndian.PutUint16(header[4:6], flags)
+	binary.BigEndian.PutUint32(header[6:10], uint32(len(payload)))
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	select {
+	case <-s.done:
+		return s.closeErr()
+	default:
+	}
+	buffers := net.Buffers{header[:], payload}
+	_, err := buffers.WriteTo(s.conn)
+	if err != nil {
+		s.close(err)
+	}
+	return err
+}
+
+func (s *session) readLoop() {
+	var header [headerLen]byte
+	for {
+		_, err := io.ReadFull(s.conn, header[:])
+		if err != nil {
+			s.close(err)
+			return
+		}
+		id := binary.BigEndian.Uint32(header[0:4])
+		flags := binary.BigEndian.Uint16(header[4:6])
+		n := binary.BigEndian.Uint32(header[6:10])
+		if n > maxPayload {
+			s.close(fmt.Errorf("mux: frame of %d bytes", n))
+			return
+		}
+		payload := make([]byte, n)
+		_, err = io.ReadFull(s.conn, payload)
+		if err != nil {
+			s.close(err)
+			return
+		}
+		err = s.handleFrame(id, flags, payload)
+		if err != nil {
+			s.close(err)
+			return
+		}
+	}
+}
+
+func (s *session) handleFrame(id uint32, flags uint16, payload []byte) error {
+	s.mu.Lock()
+	st := s.streams[id]
+	if flags&flagSYN != 0 {
+		if s.accept == nil || st != nil || id == 0 {
+			s.mu.Unlock()
+			return fmt.Errorf("mux: unexpected SYN of stream %d", id)
+		}
+		if s.draining {
+			s.mu.Unlock()
+			// Not written from here, which could deadlock with a peer
+			// blocked writing to us.
+			go s.writeFrame(id, flagRST, nil)
+			return nil
+		}
+		st = newStream(s, id)
+		s.streams[id] = st
+		s.mu.Unlock()
+		select {
+		case s.accept <- st:
+		case <-s.done:
+			return nil
+		}
+	} else {
+		s.mu.Unlock()
+	}
+	if st == nil {
+		// The stream was reset here and the peer has not noticed yet.
+		return nil
+	}
+
+	if flags&flagWND != 0 {
+		if len(payload) != 4 {
+			return fmt.Errorf("mux: WND frame of %d bytes", len(payload))
+		}
+		st.addWindow(int(binary.BigEndian.Uint32(payload)))
+	} else if len(payload) > 0 {
+		err := st.receive(payload)
+		if err != nil {
+			return err
+		}
+	}
+	if flags&flagFIN != 0 {
+		st.receiveFIN()
+	}
+	if flags&flagRST != 0 {
+		st.abort(ErrStreamReset)
+		s.remove(id)
+	}
+	return nil
+}
+
+func (s *session) open() (*stream, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	id := s.nextID
+	s.nextID += 2
+	st := newStream(s, id)
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	err := s.writeFrame(id, flagSYN, nil)
+	if err != nil {
+		s.remove(id)
+		return nil, err
+	}
+	return st, nil
+}
+
+func (s *session) remove(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams, id)
+	if s.draining && len(s.streams) == 0 {
+		go s.close(ErrSessionClosed)
+	}
+}
+
+// drain resets the streams opened from now on, and closes s once the others
+// are closed.
+func (s *session) drain() {
+	s.mu.Lock()
+	s.draining = true
+	empty := len(s.streams) == 0
+	s.mu.Unlock()
+	if empty {
+		s.close(ErrSessionClosed)
+	}
+}
+
+// close aborts all the streams of s with err, once.
+func (s *session) close(err error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	if err == nil || errors.Is(err, io.EOF) {
+		err = ErrSessionClosed
+	}
+	s.err = err
+	for _, st := range s.streams {
+		st.abort(err)
+	}
+	s.streams = make(map[uint32]*stream)
+	close(s.done)
+	s.mu.Unlock()
+
+	s.conn.Close()
+}
+
+func (s *session) closeErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// stream is the net.Conn of a stream.
+type stream struct {
+	sess *session
+	id   uint32
+
+	mu            sync.Mutex
+	buf           bytes.Buffer
+	unacked       int
+	window        int
+	finRecv       bool
+	finSent       bool
+	closed        bool
+	err           error
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readReady     chan struct{}
+	writeReady    chan struct{}
+}
+
+func newStream(sess *session, id uint32) *stream {
+	return &stream{
+		sess:       sess,
+		id:         id,
+		window:     initialWindow,
+		readReady:  make(chan struct{}, 1),
+		writeReady: make(chan struct{}, 1),
+	}
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func (st *stream) receive(payload []byte) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.closed {
+		return nil
+	}
+	if st.buf.Len()+len(payload) > initialWindow {
+		return fmt.Errorf("mux: stream %d overflowed its window", st.id)
+	}
+	st.buf.Write(payload)
+	notify(st.readReady)
+	return nil
+}
+
+func (st *stream) receiveFIN() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.finRecv = true
+	notify(st.readReady)
+}
+
+func (st *stream) addWindow(n int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.window += n
+	notify(st.writeReady)
+}
+
+func (st *stream) abort(err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.err == nil {
+		st.err = err
+	}
+	notify(st.readReady)
+	notify(st.writeReady)
+}
+
+// wait blocks until ready is notified, deadline passes or the session is
+// closed.
+func (st *stream) wait(ready chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-ready:
+	case <-st.sess.done:
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+	return nil
+}
+
+func (st *stream) Read(b []byte) (int, error) {
+	for {
+		st.mu.Lock()
+		if st.buf.Len() > 0 {
+			n, _ := st.buf.Read(b)
+			st.unacked += n
+			credit := 0
+			if st.unacked >= initialWindow/2 && !st.finRecv {
+				credit, st.unacked = st.unacked, 0
+			}
+			st.mu.Unlock()
+			if credit > 0 {
+				var payload [4]byte
+				binary.BigEndian.PutUint32(payload[:], uint32(credit))
+				st.sess.writeFrame(st.id, flagWND, payload[:])
+			}
+			return n, nil
+		}
+		switch {
+		case st.closed:
+			st.mu.Unlock()
+			return 0, net.ErrClosed
+		case st.err != nil:
+			err := st.err
+			st.mu.Unlock()
+			return 0, err
+		case st.finRecv:
+			st.mu.Unlock()
+			return 0, io.EOF
+		}
+		deadline := st.readDeadline
+		st.mu.Unlock()
+		err := st.wait(st.readReady, deadline)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (st *stream) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		st.mu.Lock()
+		switch {
+		case st.closed || st.finSent:
+			st.mu.Unlock()
+			return written, net.ErrClosed
+		case st.err != nil:
+			err := st.err
+			st.mu.Unlock()
+			return written, err
+		case !st.writeDeadline.IsZero() && !time.Now().Before(st.writeDeadline):
+			st.mu.Unlock()
+			return written, os.ErrDeadlineExceeded
+		}
+		if st.window == 0 {
+			deadline := st.writeDeadline
+			st.mu.Unlock()
+			err := st.wait(st.writeReady, deadline)
+			if err != nil {
+				return written, err
+			}
+			continue
+		}
+		n := min(len(b), st.window, maxPayload)
+		st.window -= n
+		st.mu.Unlock()
+
+		err := st.sess.writeFrame(st.id, 0, b[:n])
+		if err != nil {
+			return written, err
+		}
+		written += n
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// CloseWrite sends FIN, after which the peer reads EOF.
+func (st *stream) CloseWrite() error {
+	st.mu.Lock()
+	if st.finSent || st.closed || st.err != nil {
+		st.mu.Unlock()
+		return nil
+	}
+	st.finSent = true
+	st.mu.Unlock()
+	return st.sess.writeFrame(st.id, flagFIN, nil)
+}
+
+// Close ends the stream with FIN if the peer is done sending too, and with
+// RST otherwise so that it stops.
+func (st *stream) Close() error {
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.closed = true
+	var flags uint16
+	if st.err == nil {
+		if !st.finRecv {
+			flags = flagRST
+		} else if !st.finSent {
+			flags = flagFIN
+		}
+	}
+	st.finSent = true
+	st.buf.Reset()
+	notify(st.readReady)
+	notify(st.writeReady)
+	st.mu.Unlock()
+
+	st.sess.remove(st.id)
+	if flags != 0 {
+		return st.sess.writeFrame(st.id, flags, nil)
+	}
+	return nil
+}
+
+func (st *stream) LocalAddr() net.Addr {
+	return st.sess.conn.LocalAddr()
+}
+
+func (st *stream) RemoteAddr() net.Addr {
+	return st.sess.conn.RemoteAddr()
+}
+
+func (st *stream) SetDeadline(t time.Time) error {
+	st.SetReadDeadline(t)
+	return st.SetWriteDeadline(t)
+}
+
+func (st *stream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.readDeadline = t
+	notify(st.readReady)
+	return nil
+}
+
+func (st *stream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.writeDeadline = t
+	notify(st.writeReady)
+	return nil
+}
@@ -0,0 +1,81 @@
+package mux_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+	"github.com/glacjay/gosocks/mux"
+)
+
+// TestConcurrentStreams opens 100 SOCKS5 streams at once over a single mux
+// connection to a Server, and checks that the data of each one comes back
+// intact from an echo target.
+func TestConcurrentStreams(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	s := &gosocks.Server{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	go s.Serve(mux.Listen(l))
+	defer func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		s.Shutdown(ctx)
+	}()
+	target := gosockstest.NewEchoServer(t)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	c := mux.NewClient(conn)
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stream, err := c.Open()
+			if err != nil {
+				t.Errorf("Open %d: %v", i, err)
+				return
+			}
+			defer stream.Close()
+			stream.SetDeadline(time.Now().Add(10 * time.Second))
+			err = client.Handshake(stream, "", "", target)
+			if err != nil {
+				t.Errorf("Handshake %d: %v", i, err)
+				return
+			}
+			data := make([]byte, 16*1024+i)
+			rand.Read(data)
+			go stream.Write(data)
+			got := make([]byte, len(data))
+			if _, err := io.ReadFull(stream, got); err != nil {
+				t.Errorf("ReadFull %d: %v", i, err)
+				return
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("stream %d: the echoed data differs", i)
+			}
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case <-c.Done():
+		t.Error("the mux session ended")
+	default:
+	}
+}
@@ -0,0 +1,171 @@
+package gosocks
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PAC returns a Proxy Auto-Configuration file which sends the browsers to
+// the SOCKS5 proxy at proxyAddr, except for the targets denied by the ACL
+// which they would not reach through it anyway. When a listener of s has the
+// port of proxyAddr and an ACL of its own, its denials count too. Only the
+// IPv4 networks of DenyTargets and AllowTargets can be checked by the PAC
+// functions. The host globs are path.Match patterns, of which shExpMatch
+// only shares '*' and '?': those with a character class or an escape are
+// checked with a regular expression instead.
+func (s *Server) PAC(proxyAddr string) string {
+	acl := s.acl()
+	_, port, _ := net.SplitHostPort(proxyAddr)
+	if _, lc := s.tcpListener(port); lc != nil && lc.ACL != nil {
+		acl = lc.ACL.within(acl)
+	}
+	var chain []*ACL
+	for a := acl; a != nil; a = a.parent {
+		chain = append(chain, a)
+	}
+
+	var b strings.Builder
+	b.WriteString("function FindProxyForURL(url, host) {\n")
+	for _, a := range chain {
+		if len(ipv4Networks(a.DenyTargets)) > 0 || (a.Allowlist && len(ipv4Networks(a.AllowTargets)) > 0) {
+			b.WriteString("\tvar ip = dnsResolve(host);\n")
+			break
+		}
+	}
+	for _, a := range chain {
+		for _, pattern := range a.DenyHosts {
+			fmt.Fprintf(&b, "\tif (%s) return \"DIRECT\";\n", pacHostMatch(pattern))
+		}
+		for _, ipNet := range ipv4Networks(a.DenyTargets) {
+			fmt.Fprintf(&b, "\tif (%s) return \"DIRECT\";\n", pacInNet(ipNet))
+		}
+	}
+	// An allowlist sends the targets it does not list direct.
+	for _, a := range chain {
+		if !a.Allowlist {
+			continue
+		}
+		var listed []string
+		for _, pattern := range a.AllowHosts {
+			listed = append(listed, pacHostMatch(pattern))
+		}
+		for _, ipNet := range ipv4Networks(a.AllowTargets) {
+			listed = append(listed, "("+pacInNet(ipNet)+")")
+		}
+		if len(listed) == 0 {
+			b.WriteString("\treturn \"DIRECT\";\n}\n")
+			return b.String()
+		}
+		fmt.Fprintf(&b, "\tif (!(%s)) return \"DIRECT\";\n", strings.Join(listed, " || "))
+	}
+	fmt.Fprintf(&b, "\treturn %q;\n}\n", "SOCKS5 "+proxyAddr)
+	return b.String()
+}
+
+// pacHostMatch returns the PAC condition of host matching the path.Match
+// pattern.
+func pacHostMatch(pattern string) string {
+	if !strings.ContainsAny(pattern, `[\`) {
+		return fmt.Sprintf("shExpMatch(host, %q)", pattern)
+	}
+	return fmt.Sprintf("new RegExp(%q).test(host)", globRegexp(pattern))
+}
+
+// globRegexp translates a path.Match pattern to a regular expression of
+// JavaScript, and of Go.
+func globRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		case '[':
+			b.WriteByte('[')
+			i++
+			if i < len(pattern) && pattern[i] == '^' {
+				b.WriteByte('^')
+				i++
+			}
+			for ; i < len(pattern) && pattern[i] != ']'; i++ {
+				escaped := pattern[i] == '\\' && i+1 < len(pattern)
+				if escaped {
+					i++
+				}
+				if c := pattern[i]; !isAlphanumeric(c) && (escaped || c != '-') {
+					b.WriteByte('\\')
+				}
+				b.WriteByte(pattern[i])
+			}
+			b.WriteByte(']')
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+func isAlphanumeric(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// pacInNet returns the PAC condition of the resolved ip being in ipNet.
+func pacInNet(ipNet *net.IPNet) string {
+	return fmt.Sprintf("ip && isInNet(ip, %q, %q)", ipNet.IP.String(), net.IP(ipNet.Mask).String())
+}
+
+func ipv4Networks(ipNets []*net.IPNet) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, ipNet := range ipNets {
+		if ipNet.IP.To4() != nil && len(ipNet.Mask) == net.IPv4len {
+			networks = append(networks, ipNet)
+		}
+	}
+	return networks
+}
+
+// servePAC serves s.PAC for the proxy address given by the proxy query
+// parameter, or else the host the request was sent to with the port of a TCP
+// listener of s.
+func (s *Server) servePAC(w http.ResponseWriter, r *http.Request) {
+	proxyAddr := r.URL.Query().Get("proxy")
+	if proxyAddr == "" {
+		port, _ := s.tcpListener("")
+		if port == "" {
+			writeJSONError(w, http.StatusConflict, "the server is not listening on TCP, set the proxy query parameter")
+			return
+		}
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		proxyAddr = net.JoinHostPort(strings.Trim(host, "[]"), port)
+	}
+	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+	w.Write([]byte(s.PAC(proxyAddr)))
+}
+
+// tcpListener returns the port of the TCP listener of s with port, of any of
+// them if port is empty, and the Listener it was opened for, or "" and nil.
+func (s *Server) tcpListener(port string) (string, *Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for l, lc := range s.listeners {
+		if addr, ok := l.Addr().(*net.TCPAddr); ok && (port == "" || strconv.Itoa(addr.Port) == port) {
+			return strconv.Itoa(addr.Port), lc
+		}
+	}
+	return "", nil
+}
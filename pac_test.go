@@ -0,0 +1,108 @@
+package gosocks_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+)
+
+// pacStatement matches the statements FindProxyForURL may be made of.
+var pacStatement = regexp.MustCompile(`^\t(var ip = dnsResolve\(host\);|if \(.+\) return "[^"]+";|return "[^"]+";)$`)
+
+// checkPAC checks that pac is a FindProxyForURL function made of the
+// statements of pacStatement, with balanced parentheses.
+func checkPAC(t *testing.T, pac string) {
+	t.Helper()
+	lines := strings.Split(strings.TrimSuffix(pac, "\n"), "\n")
+	if lines[0] != "function FindProxyForURL(url, host) {" || lines[len(lines)-1] != "}" {
+		t.Fatalf("PAC is not a FindProxyForURL function:\n%s", pac)
+	}
+	for _, line := range lines[1 : len(lines)-1] {
+		if !pacStatement.MatchString(line) || strings.Count(line, "(") != strings.Count(line, ")") {
+			t.Errorf("invalid PAC statement %q", line)
+		}
+	}
+	if last := lines[len(lines)-2]; !strings.HasPrefix(last, "\treturn ") {
+		t.Errorf("PAC ends with %q, want a return", last)
+	}
+}
+
+func TestPAC(t *testing.T) {
+	acl := &gosocks.ACL{Allowlist: true}
+	for _, target := range []string{"*.example.com", "[ab].test", "10.0.0.0/8"} {
+		if err := acl.AddAllowTarget(target); err != nil {
+			t.Fatalf("AddAllowTarget(%q): %v", target, err)
+		}
+	}
+	listenerACL := &gosocks.ACL{}
+	for _, target := range []string{"*.blocked.test", "10.9.0.0/16"} {
+		if err := listenerACL.AddDenyTarget(target); err != nil {
+			t.Fatalf("AddDenyTarget(%q): %v", target, err)
+		}
+	}
+	srv := &gosocks.Server{
+		Listeners: []gosocks.Listener{{Addr: "127.0.0.1:0", ACL: listenerACL}},
+		ACL:       acl,
+		Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	go srv.ListenAndServe()
+	defer func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		srv.Shutdown(ctx)
+	}()
+	if err := srv.WaitReady(5 * time.Second); err != nil {
+		t.Fatalf("WaitReady: %v", err)
+	}
+	port := strconv.Itoa(srv.ListenAddrs()[0].(*net.TCPAddr).Port)
+	ts := httptest.NewServer(gosocks.NewAdminHandler(srv, ""))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/proxy.pac")
+	if err != nil {
+		t.Fatalf("GET /proxy.pac: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-ns-proxy-autoconfig" {
+		t.Fatalf("GET /proxy.pac = %d %s, want a PAC file", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	pac := string(body)
+	checkPAC(t, pac)
+	for _, want := range []string{
+		`if (shExpMatch(host, "*.blocked.test")) return "DIRECT";`,
+		`if (ip && isInNet(ip, "10.9.0.0", "255.255.0.0")) return "DIRECT";`,
+		`shExpMatch(host, "*.example.com")`,
+		`(ip && isInNet(ip, "10.0.0.0", "255.0.0.0"))`,
+		`return "SOCKS5 127.0.0.1:` + port + `";`,
+	} {
+		if !strings.Contains(pac, want) {
+			t.Errorf("PAC does not contain %s:\n%s", want, pac)
+		}
+	}
+
+	// The character class is checked with a regular expression, which
+	// matches like path.Match.
+	m := regexp.MustCompile(`new RegExp\("([^"]+)"\)`).FindStringSubmatch(pac)
+	if m == nil {
+		t.Fatalf("PAC has no regular expression for [ab].test:\n%s", pac)
+	}
+	re := regexp.MustCompile(strings.ReplaceAll(m[1], `\\`, `\`))
+	for _, host := range []string{"a.test", "b.test", "c.test", "ab.test", "axtest"} {
+		want, _ := path.Match("[ab].test", host)
+		if got := re.MatchString(host); got != want {
+			t.Errorf("%s matches %s = %v, want %v", re, host, got, want)
+		}
+	}
+}
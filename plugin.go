@@ -0,0 +1,47 @@
+package gosocks
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// PluginAPIVersion is the version of the authentication plugin ABI. A plugin
+// must export a 'func PluginAPIVersion() int' returning it, and a 'func
+// NewAuthenticator() gosocks.Authenticator' symbol.
+const PluginAPIVersion = 1
+
+// LoadAuthPlugin opens the Go plugin at path, built with -buildmode=plugin
+// against the same version of this package, and returns the Authenticator
+// it provides.
+func LoadAuthPlugin(path string) (Authenticator, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sym, err := p.Lookup("PluginAPIVersion")
+	if err != nil {
+		return nil, err
+	}
+	version, ok := sym.(func() int)
+	if !ok {
+		return nil, fmt.Errorf("plugin %s: PluginAPIVersion is a %T, not a func() int", path, sym)
+	}
+	if v := version(); v != PluginAPIVersion {
+		return nil, fmt.Errorf("plugin %s: API version %d, expected %d", path, v, PluginAPIVersion)
+	}
+
+	sym, err = p.Lookup("NewAuthenticator")
+	if err != nil {
+		return nil, err
+	}
+	newAuthenticator, ok := sym.(func() Authenticator)
+	if !ok {
+		return nil, fmt.Errorf("plugin %s: NewAuthenticator is a %T, not a func() gosocks.Authenticator", path, sym)
+	}
+	auth := newAuthenticator()
+	if auth == nil {
+		return nil, fmt.Errorf("plugin %s: NewAuthenticator returned nil", path)
+	}
+	return auth, nil
+}
@@ -0,0 +1,151 @@
+package gosocks
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Pool keeps the upstream connections whose client went away while the
+// target kept them open, and hands them to the next client requesting the
+// same address. This only suits protocols which can reuse an idle
+// connection, such as HTTP/1.1 with keep-alive.
+type Pool struct {
+	// MaxPerHost is how many idle connections are kept per target address.
+	MaxPerHost int
+
+	// IdleTimeout is how long an idle connection is kept, 90 seconds if
+	// zero.
+	IdleTimeout time.Duration
+
+	mu        sync.Mutex
+	idle      map[string][]idleConn
+	lastPrune time.Time
+}
+
+type idleConn struct {
+	conn  net.Conn
+	since time.Time
+}
+
+// poolConn is an upstream connection which may go back to its Pool when the
+// relay is done with it. Once detached, reads of it are stopped and its read
+// deadline stays in the past, whatever the wrappers of the relay set.
+type poolConn struct {
+	net.Conn
+	addr     string
+	released bool
+	detached atomic.Bool
+}
+
+// detach stops the reads of c, for the client went away and c may go back to
+// the Pool.
+func (c *poolConn) detach() {
+	c.detached.Store(true)
+	c.Conn.SetReadDeadline(time.Now())
+}
+
+func (c *poolConn) SetDeadline(t time.Time) error {
+	if c.detached.Load() {
+		return c.Conn.SetWriteDeadline(t)
+	}
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *poolConn) SetReadDeadline(t time.Time) error {
+	if c.detached.Load() {
+		return nil
+	}
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *poolConn) Close() error {
+	if c.released {
+		return nil
+	}
+	return c.Conn.Close()
+}
+
+func (c *poolConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
+
+func (p *Pool) idleTimeout() time.Duration {
+	if p.IdleTimeout > 0 {
+		return p.IdleTimeout
+	}
+	return 90 * time.Second
+}
+
+// get returns an idle connection to addr which is still open, or nil.
+func (p *Pool) get(addr string) *poolConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prune()
+
+	conns := p.idle[addr]
+	for len(conns) > 0 {
+		c := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		p.idle[addr] = conns
+		if healthy(c.conn) {
+			return &poolConn{Conn: c.conn, addr: addr}
+		}
+		c.conn.Close()
+	}
+	return nil
+}
+
+// put keeps conn for reuse if there is room for it, and closes it otherwise.
+func (p *Pool) put(conn *poolConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prune()
+
+	if p.idle == nil {
+		p.idle = make(map[string][]idleConn)
+	}
+	if len(p.idle[conn.addr]) >= p.MaxPerHost || !healthy(conn.Conn) {
+		conn.Conn.Close()
+		return
+	}
+	conn.Conn.SetDeadline(time.Time{})
+	conn.released = true
+	p.idle[conn.addr] = append(p.idle[conn.addr], idleConn{conn.Conn, time.Now()})
+}
+
+func (p *Pool) prune() {
+	now := time.Now()
+	if now.Sub(p.lastPrune) < time.Second {
+		return
+	}
+	p.lastPrune = now
+	for addr, conns := range p.idle {
+		kept := conns[:0]
+		for _, c := range conns {
+			if now.Sub(c.since) > p.idleTimeout() {
+				c.conn.Close()
+			} else {
+				kept = append(kept, c)
+			}
+		}
+		if len(kept) == 0 {
+			delete(p.idle, addr)
+		} else {
+			p.idle[addr] = kept
+		}
+	}
+}
+
+// healthy reports whether conn is still open and has nothing to read, which
+// rules out the connections half-closed by the target.
+func healthy(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now())
+	var b [1]byte
+	n, err := conn.Read(b[:])
+	conn.SetReadDeadline(time.Time{})
+	return n == 0 && errors.Is(err, os.ErrDeadlineExceeded)
+}
@@ -0,0 +1,59 @@
+package gosocks_test
+
+import (
+	"bufio"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// TestPoolReuse checks that a second CONNECT to the same target gets the
+// connection of the first one, which the target kept open.
+func TestPoolReuse(t *testing.T) {
+	var accepted atomic.Int32
+	target := gosockstest.NewTarget(t, func(conn net.Conn) {
+		accepted.Add(1)
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("re: " + line))
+		}
+	})
+	srv := gosockstest.NewServer(t, func(s *gosocks.Server) {
+		s.Pool = &gosocks.Pool{MaxPerHost: 1}
+	})
+
+	for i := range 2 {
+		conn, err := client.Dial(srv.Addr(), target)
+		if err != nil {
+			t.Fatalf("Dial %d: %v", i, err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte("hello\n"))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil || line != "re: hello\n" {
+			t.Fatalf("read %q, %v, want %q", line, err, "re: hello\n")
+		}
+		conn.Close()
+
+		// The connection goes back to the pool before the session ends.
+		deadline := time.Now().Add(5 * time.Second)
+		for len(srv.Config.Connections()) > 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("the session did not end")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if n := accepted.Load(); n != 1 {
+		t.Errorf("target accepted %d connections, want 1", n)
+	}
+}
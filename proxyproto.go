@@ -0,0 +1,126 @@
+package gosocks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// The PROXY protocol header sent by load balancers in front of the server,
+// as specified by HAProxy, carries the address of the actual client.
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxProxyV1Len is the longest possible version 1 header, CRLF included.
+const maxProxyV1Len = 107
+
+// proxyConn reports the client address read from the PROXY protocol header
+// instead of the address of the load balancer.
+type proxyConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *proxyConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
+
+// readProxyHeader reads the PROXY protocol header, version 1 or 2, at the
+// start of conn. It returns conn itself if the header does not tell the
+// client address, such as for health checks.
+func readProxyHeader(conn net.Conn) (net.Conn, error) {
+	// Both versions are at least this long.
+	header := make([]byte, 12)
+	_, err := io.ReadFull(conn, header)
+	if err != nil {
+		return nil, err
+	}
+
+	var remote net.Addr
+	switch {
+	case bytes.HasPrefix(header, []byte("PROXY ")):
+		remote, err = readProxyV1(conn, header)
+	case bytes.Equal(header, proxyV2Signature):
+		remote, err = readProxyV2(conn)
+	default:
+		return nil, fmt.Errorf("missing PROXY protocol header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if remote == nil {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, remote: remote}, nil
+}
+
+func readProxyV1(r io.Reader, line []byte) (net.Addr, error) {
+	var b [1]byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == maxProxyV1Len {
+			return nil, fmt.Errorf("PROXY protocol header is longer than %d bytes", maxProxyV1Len)
+		}
+		_, err := io.ReadFull(r, b[:])
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b[0])
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY protocol header: %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 0xFFFF {
+		return nil, fmt.Errorf("invalid PROXY protocol source address: %s %s", fields[2], fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+func readProxyV2(r io.Reader) (net.Addr, error) {
+	var header [4]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return nil, err
+	}
+	if header[0]>>4 != 0x2 {
+		return nil, fmt.Errorf("unknown PROXY protocol version: %X", header[0]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[2:]))
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// The LOCAL command is sent by the load balancer itself.
+	if header[0]&0x0F == 0x0 {
+		return nil, nil
+	}
+	switch header[1] {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, fmt.Errorf("PROXY protocol addresses too short: %d bytes", len(body))
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, fmt.Errorf("PROXY protocol addresses too short: %d bytes", len(body))
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,108 @@
+package gosocks_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+func selfSignedCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "gosocks test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+type recordingHook struct {
+	mu    sync.Mutex
+	infos []gosocks.ConnInfo
+}
+
+func (h *recordingHook) PreRelay(ctx context.Context, info gosocks.ConnInfo) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.infos = append(h.infos, info)
+	return nil
+}
+
+// TestProxyProtocolTLS checks that the PROXY header is read before the TLS
+// handshake, and that the client address it carries is the one of the
+// request.
+func TestProxyProtocolTLS(t *testing.T) {
+	hook := &recordingHook{}
+	s := &gosocks.Server{
+		Addr:          "127.0.0.1:0",
+		TLSConfig:     &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t)}},
+		ProxyProtocol: true,
+		PerIPRate:     1000,
+		Hook:          hook,
+		Logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.ListenAndServe()
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		s.Shutdown(ctx)
+		<-done
+	})
+	err := s.WaitReady(5 * time.Second)
+	if err != nil {
+		t.Fatalf("WaitReady: %v", err)
+	}
+	target := gosockstest.NewEchoServer(t)
+
+	raw, err := net.Dial("tcp", s.ListenAddrs()[0].String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer raw.Close()
+	raw.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = raw.Write([]byte("PROXY TCP4 192.0.2.7 127.0.0.1 5555 1080\r\n"))
+	if err != nil {
+		t.Fatalf("Write PROXY header: %v", err)
+	}
+	conn := tls.Client(raw, &tls.Config{InsecureSkipVerify: true})
+	negotiate(t, conn, 0x00)
+	rep, _, _ := request(t, conn, 0x01, socksAddr(t, target))
+	if rep != 0x00 {
+		t.Fatalf("reply = %#x, want 0x00", rep)
+	}
+	echo(t, conn, "hello")
+
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	if len(hook.infos) != 1 || hook.infos[0].ClientAddr.String() != "192.0.2.7:5555" {
+		t.Errorf("hook saw %+v, want one request from 192.0.2.7:5555", hook.infos)
+	}
+}
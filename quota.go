@@ -0,0 +1,153 @@
+package gosocks
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrQuotaExceeded = errors.New("gosocks: daily quota of the user exceeded")
+
+// Quotas limits how many bytes each user may relay per day, counting both
+// directions of all the connections. The counters reset at midnight UTC.
+type Quotas struct {
+	mu     sync.Mutex
+	limits map[string]int64
+	day    string
+	used   map[string]int64
+}
+
+// quotaState is the JSON form of the counters, as saved by SaveState.
+type quotaState struct {
+	Day  string           `json:"day"`
+	Used map[string]int64 `json:"used"`
+}
+
+// LoadQuotas reads a JSON file mapping the usernames to their daily quota in
+// bytes. The users which are not listed have no quota.
+func LoadQuotas(path string) (*Quotas, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	q := &Quotas{used: make(map[string]int64)}
+	err = json.Unmarshal(data, &q.limits)
+	if err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// LoadState restores the counters saved by SaveState, unless they are from
+// a previous day. A missing file is not an error.
+func (q *Quotas) LoadState(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state quotaState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover()
+	if state.Day == q.day && state.Used != nil {
+		q.used = state.Used
+	}
+	return nil
+}
+
+// SaveState writes the counters of the day to path.
+func (q *Quotas) SaveState(path string) error {
+	q.mu.Lock()
+	q.rollover()
+	data, err := json.Marshal(quotaState{Day: q.day, Used: q.used})
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Used returns how many bytes user has relayed today.
+func (q *Quotas) Used(user string) int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover()
+	return q.used[user]
+}
+
+func (q *Quotas) rollover() {
+	day := time.Now().UTC().Format(time.DateOnly)
+	if day != q.day {
+		q.day = day
+		q.used = make(map[string]int64)
+	}
+}
+
+// exceeded reports whether user has used up its quota. It is false for the
+// unauthenticated clients.
+func (q *Quotas) exceeded(user string) bool {
+	if q == nil || user == "" {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover()
+	limit, ok := q.limits[user]
+	return ok && q.used[user] >= limit
+}
+
+func (q *Quotas) add(user string, n int64) {
+	if q == nil || user == "" || n == 0 {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover()
+	q.used[user] += n
+}
+
+// consume counts up to n more bytes relayed by user, as many as its quota
+// has left, and returns how many it counted.
+func (q *Quotas) consume(user string, n int64) int64 {
+	if q == nil || user == "" || n == 0 {
+		return n
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover()
+	if limit, ok := q.limits[user]; ok {
+		n = min(n, max(limit-q.used[user], 0))
+	}
+	q.used[user] += n
+	return n
+}
+
+// quotaWriter counts the bytes written to w against the quota of user. Once
+// it is used up, Write writes what still fits then returns
+// ErrQuotaExceeded.
+type quotaWriter struct {
+	w      io.Writer
+	quotas *Quotas
+	user   string
+}
+
+func (w *quotaWriter) Write(b []byte) (int, error) {
+	allowed := w.quotas.consume(w.user, int64(len(b)))
+	n, err := w.w.Write(b[:allowed])
+	// The bytes counted but not written are given back.
+	w.quotas.add(w.user, int64(n)-allowed)
+	if err == nil && allowed < int64(len(b)) {
+		err = ErrQuotaExceeded
+	}
+	return n, err
+}
@@ -0,0 +1,58 @@
+package gosocks_test
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// TestQuotaCutOff checks that a transfer larger than the quota of the user
+// stops after exactly the quota, and that the next connection is refused.
+func TestQuotaCutOff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quotas.json")
+	err := os.WriteFile(path, []byte(`{"alice": 1000}`), 0644)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	quotas, err := gosocks.LoadQuotas(path)
+	if err != nil {
+		t.Fatalf("LoadQuotas: %v", err)
+	}
+	srv := gosockstest.NewServer(t, gosockstest.WithAuth("alice", "secret"), func(s *gosocks.Server) {
+		s.Quotas = quotas
+	})
+	target := gosockstest.NewTarget(t, func(conn net.Conn) {
+		conn.Write(make([]byte, 4000))
+		io.Copy(io.Discard, conn)
+	})
+
+	dialer := &client.Dialer{ProxyAddr: srv.Addr(), Username: "alice", Password: "secret"}
+	conn, err := dialer.Dial("tcp", target)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	received, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if len(received) != 1000 {
+		t.Errorf("received %d bytes, want the quota of 1000", len(received))
+	}
+	if used := quotas.Used("alice"); used != 1000 {
+		t.Errorf("Used = %d, want 1000", used)
+	}
+
+	_, err = dialer.Dial("tcp", target)
+	if err == nil {
+		t.Error("Dial over the quota succeeded")
+	}
+}
@@ -0,0 +1,121 @@
+package gosocks
+
+import (
+	"context"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ipLimiters keeps one token bucket per client IP. The buckets which have not
+// been used for a minute are forgotten.
+type ipLimiters struct {
+	mu        sync.Mutex
+	limit     rate.Limit
+	limiters  map[string]*ipLimiter
+	lastPrune time.Time
+}
+
+type ipLimiter struct {
+	*rate.Limiter
+	lastSeen time.Time
+}
+
+func (l *ipLimiters) get(ip net.IP) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastPrune) > time.Minute {
+		for key, limiter := range l.limiters {
+			if now.Sub(limiter.lastSeen) > time.Minute {
+				delete(l.limiters, key)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	if l.limiters == nil {
+		l.limiters = make(map[string]*ipLimiter)
+	}
+	key := ip.String()
+	limiter, ok := l.limiters[key]
+	if !ok {
+		burst := int(math.Ceil(float64(l.limit)))
+		if burst < 1 {
+			burst = 1
+		}
+		limiter = &ipLimiter{Limiter: rate.NewLimiter(l.limit, burst)}
+		l.limiters[key] = limiter
+	}
+	limiter.lastSeen = now
+	return limiter.Limiter
+}
+
+// waitAccept blocks until the global rate limit allows a new connection to be
+// accepted, so that the pending ones stay in the listen queue.
+func (s *Server) waitAccept() {
+	if s.RateLimit <= 0 {
+		return
+	}
+	s.mu.Lock()
+	if s.acceptLimiter == nil {
+		burst := s.RateBurst
+		if burst < 1 {
+			burst = 1
+		}
+		s.acceptLimiter = rate.NewLimiter(s.RateLimit, burst)
+	}
+	limiter := s.acceptLimiter
+	s.mu.Unlock()
+	limiter.Wait(context.Background())
+}
+
+// acquireConnSlot blocks until fewer than s.MaxConns connections accepted by
+// the listeners are handled. It returns false if s is shut down meanwhile.
+func (s *Server) acquireConnSlot() bool {
+	if s.MaxConns <= 0 {
+		return true
+	}
+	s.mu.Lock()
+	if s.connSlots == nil {
+		s.connSlots = make(chan struct{}, s.MaxConns)
+	}
+	slots, shutdown := s.connSlots, s.shutdownChan()
+	s.mu.Unlock()
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-shutdown:
+		return false
+	}
+}
+
+func (s *Server) releaseConnSlot() {
+	if s.MaxConns <= 0 {
+		return
+	}
+	<-s.connSlots
+}
+
+// waitClient delays the handling of a new connection until the rate limit of
+// its source IP allows it.
+func (s *Server) waitClient(ctx context.Context, client net.Conn) {
+	if s.PerIPRate <= 0 {
+		return
+	}
+	ip := addrIP(client.RemoteAddr())
+	if ip == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ipLimiters == nil {
+		s.ipLimiters = &ipLimiters{limit: s.PerIPRate}
+	}
+	limiters := s.ipLimiters
+	s.mu.Unlock()
+	limiters.get(ip).Wait(ctx)
+}
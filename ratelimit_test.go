@@ -0,0 +1,92 @@
+package gosocks_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// TestRateLimit checks that the connections opened beyond the rate limits
+// are delayed rather than dropped.
+func TestRateLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		opt   gosockstest.Option
+		conns int
+		min   time.Duration
+	}{
+		// A burst of 2 at 10/s lets the 4 others through in 400ms.
+		{"global", func(s *gosocks.Server) {
+			s.RateLimit = 10
+			s.RateBurst = 2
+		}, 6, 300 * time.Millisecond},
+		// A burst of 5 at 5/s lets the 3 others through in 600ms.
+		{"per IP", func(s *gosocks.Server) {
+			s.PerIPRate = 5
+		}, 8, 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := gosockstest.NewServer(t, tt.opt)
+			target := gosockstest.NewEchoServer(t)
+			start := time.Now()
+			for i := range tt.conns {
+				conn, err := client.Dial(srv.Addr(), target)
+				if err != nil {
+					t.Fatalf("Dial %d: %v", i, err)
+				}
+				echo(t, conn, "hello")
+				conn.Close()
+			}
+			if elapsed := time.Since(start); elapsed < tt.min {
+				t.Errorf("%d connections took %v, want at least %v", tt.conns, elapsed, tt.min)
+			}
+		})
+	}
+}
+
+// TestMaxConns checks that with MaxConns at 2 a third client is held until
+// one of the first two finishes, and then served.
+func TestMaxConns(t *testing.T) {
+	srv := gosockstest.NewServer(t, func(s *gosocks.Server) {
+		s.MaxConns = 2
+	})
+	target := gosockstest.NewEchoServer(t)
+	var active []net.Conn
+	for range 2 {
+		conn, err := client.Dial(srv.Addr(), target)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		defer conn.Close()
+		echo(t, conn, "hello")
+		active = append(active, conn)
+	}
+
+	third := dialProxy(t, srv)
+	third.Write([]byte{0x05, 0x01, 0x00})
+	third.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := third.Read(make([]byte, 2)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Read of the third client = %v, want no reply while two are active", err)
+	}
+	if n := len(srv.Config.Connections()); n != 2 {
+		t.Errorf("%d active connections, want 2", n)
+	}
+
+	active[0].Close()
+	third.SetReadDeadline(time.Now().Add(5 * time.Second))
+	method := make([]byte, 2)
+	if _, err := io.ReadFull(third, method); err != nil {
+		t.Fatalf("the third client was not served after the first finished: %v", err)
+	}
+	if method[1] != 0x00 {
+		t.Errorf("method = %#x, want no authentication", method[1])
+	}
+}
@@ -0,0 +1,196 @@
+package gosocks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// relay copies data in both directions until both of them are done. When one
+// side stops sending, the other one is half-closed so that it sees EOF too.
+// A pooled remote is not half-closed when the client stops sending: its
+// reads are stopped instead, and it goes back to its Pool if the target did
+// not send EOF nor fail meanwhile, and put finds it still open.
+// The handshake deadline of the client is cleared first, and if IdleTimeout
+// is set both connections are dropped after being idle for that long. The
+// first HTTP request of the client may get headers, see relayHeaders, with
+// Mirror the traffic is copied to it, and the client is a LimitedConn if
+// there is a byte limit for it.
+func (s *Server) relay(client, remote net.Conn, sess *session) {
+	start := time.Now()
+	pooled, _ := remote.(*poolConn)
+	client.SetDeadline(time.Time{})
+	if s.IdleTimeout > 0 {
+		client = newIdleTimeoutConn(client, s.IdleTimeout)
+		remote = newIdleTimeoutConn(remote, s.IdleTimeout)
+	}
+	if limit := s.byteLimit(sess.identity); limit > 0 {
+		client = NewLimitedConn(client, limit)
+	}
+	if headers := s.relayHeaders(client, sess); len(headers) > 0 {
+		client = newHeaderConn(client, headers)
+	}
+	if s.Mirror != nil {
+		id := RequestID(sess.ctx)
+		client = newMirrorConn(client, s.Mirror, id, 0)
+		remote = newMirrorConn(remote, s.Mirror, id, 1)
+	}
+
+	addr := client.RemoteAddr().String()
+	stop := context.AfterFunc(sess.ctx, func() {
+		client.SetDeadline(time.Now())
+		remote.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	s.trackSession(sess, true)
+	defer s.trackSession(sess, false)
+	s.publish(sess, func(info EventInfo) Event {
+		return RelayStarted{EventInfo: info, TargetAddr: sess.target, Identity: sess.identity}
+	})
+	_, span := s.tracer().Start(sess.ctx, "relay", trace.WithAttributes(peerAttributes(sess.target)...))
+	defer span.End()
+
+	var clientDone atomic.Bool
+	var remoteErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		err := s.copyConn(sess, remote, client, &sess.sent)
+		if errors.Is(err, ErrQuotaExceeded) {
+			s.quotaExhausted(sess, addr)
+		} else if err != nil && sess.ctx.Err() == nil {
+			sess.log.Warn("Failed to relay from the client to the remote", "remote_addr", addr, "error", err)
+		}
+		clientDone.Store(err == nil)
+		if pooled != nil && err == nil {
+			pooled.detach()
+		} else {
+			closeWrite(remote)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		remoteErr = s.copyConn(sess, client, remote, &sess.recv)
+		if errors.Is(remoteErr, ErrByteLimitExceeded) {
+			sess.log.Info("Byte limit of the connection reached", "remote_addr", addr)
+		} else if errors.Is(remoteErr, ErrQuotaExceeded) {
+			s.quotaExhausted(sess, addr)
+		} else if remoteErr != nil && sess.ctx.Err() == nil && (pooled == nil || !pooled.detached.Load()) {
+			sess.log.Warn("Failed to relay from the remote to the client", "remote_addr", addr, "error", remoteErr)
+		}
+		closeWrite(client)
+	}()
+	wg.Wait()
+
+	if pooled != nil && clientDone.Load() && errors.Is(remoteErr, os.ErrDeadlineExceeded) && sess.ctx.Err() == nil {
+		s.Pool.put(pooled)
+	}
+
+	sess.outcome = outcomeSuccess
+	sent, recv := sess.sent.Load(), sess.recv.Load()
+	s.Metrics.relayFinished(sent, recv)
+	s.publish(sess, func(info EventInfo) Event {
+		return RelayFinished{EventInfo: info, TargetAddr: sess.target, BytesSent: sent, BytesRecv: recv, Duration: time.Since(start)}
+	})
+
+	sess.log.Info("Relay finished",
+		"remote_addr", client.RemoteAddr().String(),
+		"target_addr", remote.RemoteAddr().String(),
+		"bytes_sent", sent,
+		"bytes_recv", recv,
+		"duration", time.Since(start))
+}
+
+// quotaExhausted ends the relay of sess, whose user has used up its quota.
+func (s *Server) quotaExhausted(sess *session, addr string) {
+	sess.log.Info("Quota exceeded", "remote_addr", addr, "user", sess.identity)
+	sess.cancel()
+}
+
+// relayChunk is how much data copyConn moves before updating the byte count.
+const relayChunk = 64 * 1024
+
+// copyConn copies src to dst until EOF, adding the bytes to written, and to
+// the quota of the client, as they go; it fails with ErrQuotaExceeded once
+// the quota is used up. When both are plain TCP connections without a
+// bandwidth limit nor a quota, io.Copy lets the kernel splice the data on
+// Linux; otherwise it goes through a pooled buffer.
+func (s *Server) copyConn(sess *session, dst, src net.Conn, written *atomic.Int64) error {
+	w := s.limitWriter(sess.ctx, dst)
+	if s.Quotas != nil && sess.identity != "" {
+		w = &quotaWriter{w: w, quotas: s.Quotas, user: sess.identity}
+	}
+	_, dstTCP := w.(*net.TCPConn)
+	_, srcTCP := src.(*net.TCPConn)
+
+	var buf *[]byte
+	if !dstTCP || !srcTCP {
+		buf = s.getBuffer()
+		defer s.putBuffer(buf)
+	}
+	for {
+		// The LimitedReader also hides the WriterTo of src, which would
+		// allocate its own buffer.
+		var n int64
+		var err error
+		if buf == nil {
+			n, err = io.Copy(w, io.LimitReader(src, relayChunk))
+		} else {
+			n, err = io.CopyBuffer(w, io.LimitReader(src, relayChunk), *buf)
+		}
+		written.Add(n)
+		if err != nil || n < relayChunk {
+			return err
+		}
+	}
+}
+
+// closeWrite shuts down the writing side of conn, if it supports it, so that
+// the peer sees EOF.
+func closeWrite(conn net.Conn) error {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// idleTimeoutConn pushes the deadline of the connection forward after each
+// successful read or write.
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func newIdleTimeoutConn(conn net.Conn, timeout time.Duration) *idleTimeoutConn {
+	conn.SetDeadline(time.Now().Add(timeout))
+	return &idleTimeoutConn{Conn: conn, timeout: timeout}
+}
+
+func (c *idleTimeoutConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	return n, err
+}
+
+func (c *idleTimeoutConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	return n, err
+}
+
+func (c *idleTimeoutConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
@@ -0,0 +1,55 @@
+package gosocks_test
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// TestHalfClose checks that the FIN of the client reaches the target, and
+// that the answer the target sends afterwards, then its own FIN, still reach
+// the client.
+func TestHalfClose(t *testing.T) {
+	srv := gosockstest.NewServer(t)
+	received := make(chan string, 1)
+	target := gosockstest.NewTarget(t, func(conn net.Conn) {
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		request, err := io.ReadAll(conn)
+		if err != nil {
+			received <- "error: " + err.Error()
+			return
+		}
+		received <- string(request)
+		conn.Write([]byte("response"))
+	})
+
+	conn, err := client.Dial(srv.Addr(), target)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("request"))
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	err = conn.(*net.TCPConn).CloseWrite()
+	if err != nil {
+		t.Fatalf("CloseWrite: %v", err)
+	}
+	if got := <-received; got != "request" {
+		t.Fatalf("target read %q, want %q", got, "request")
+	}
+
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(response) != "response" {
+		t.Errorf("client read %q, want %q", response, "response")
+	}
+}
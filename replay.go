@@ -0,0 +1,64 @@
+package gosocks
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// replayProtectedAuthenticator refuses the credentials which were already
+// accepted within the window, so that a captured one-time token cannot be
+// used again.
+type replayProtectedAuthenticator struct {
+	inner  Authenticator
+	window time.Duration
+
+	mu   sync.Mutex
+	seen map[[sha256.Size]byte]*list.Element
+	fifo *list.List // of *seenToken, oldest first
+}
+
+type seenToken struct {
+	hash [sha256.Size]byte
+	at   time.Time
+}
+
+// ReplayProtectedAuthenticator wraps inner so that the same username and
+// password are only accepted once within window. Only the hashes of the
+// accepted credentials are kept, and they are forgotten after window. This
+// suits the backends issuing one-time tokens; with regular passwords, a
+// client could not reconnect before window has elapsed.
+func ReplayProtectedAuthenticator(inner Authenticator, window time.Duration) Authenticator {
+	return &replayProtectedAuthenticator{
+		inner:  inner,
+		window: window,
+		seen:   make(map[[sha256.Size]byte]*list.Element),
+		fifo:   list.New(),
+	}
+}
+
+func (a *replayProtectedAuthenticator) Authenticate(username, password string) (string, error) {
+	identity, err := a.inner.Authenticate(username, password)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256([]byte(username + "\x00" + password))
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for e := a.fifo.Front(); e != nil; e = a.fifo.Front() {
+		token := e.Value.(*seenToken)
+		if now.Sub(token.at) < a.window {
+			break
+		}
+		a.fifo.Remove(e)
+		delete(a.seen, token.hash)
+	}
+	if _, ok := a.seen[hash]; ok {
+		return "", ErrAuthFailed
+	}
+	a.seen[hash] = a.fifo.PushBack(&seenToken{hash: hash, at: now})
+	return identity, nil
+}
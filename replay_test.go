@@ -0,0 +1,53 @@
+package gosocks_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// TestReplayProtectedAuthenticator checks that a token is refused when used
+// again within the window, and accepted again once the window has elapsed.
+func TestReplayProtectedAuthenticator(t *testing.T) {
+	const window = 100 * time.Millisecond
+	auth := gosocks.ReplayProtectedAuthenticator(gosocks.Credentials{"alice": "token1", "bob": "token1"}, window)
+	tests := []struct {
+		username, password string
+		ok                 bool
+	}{
+		{"alice", "token1", true},
+		{"alice", "token1", false},
+		// The same password for another user is another token.
+		{"bob", "token1", true},
+		{"alice", "wrong", false},
+	}
+	for _, tt := range tests {
+		_, err := auth.Authenticate(tt.username, tt.password)
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("Authenticate(%q, %q) = %v, want accepted = %v", tt.username, tt.password, err, tt.ok)
+		}
+	}
+	time.Sleep(window)
+	if _, err := auth.Authenticate("alice", "token1"); err != nil {
+		t.Errorf("Authenticate after the window = %v, want accepted", err)
+	}
+
+	// Through a server, the second connection with the token fails.
+	srv := gosockstest.NewServer(t, func(s *gosocks.Server) {
+		s.Auth = gosocks.ReplayProtectedAuthenticator(gosocks.Credentials{"alice": "token1"}, time.Minute)
+	})
+	target := gosockstest.NewEchoServer(t)
+	conn, err := client.DialWithAuth(srv.Addr(), "alice", "token1", target)
+	if err != nil {
+		t.Fatalf("DialWithAuth: %v", err)
+	}
+	echo(t, conn, "hello")
+	conn.Close()
+	if conn, err := client.DialWithAuth(srv.Addr(), "alice", "token1", target); err == nil {
+		conn.Close()
+		t.Error("DialWithAuth with a replayed token succeeded")
+	}
+}
@@ -0,0 +1,38 @@
+package gosocks
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+
+	socks5 "github.com/glacjay/gosocks/client"
+)
+
+// dialReplyCode returns the SOCKS5 reply to a request whose dial failed with
+// err. The refusal of an upstream SOCKS5 proxy is passed on as is.
+func dialReplyCode(err error) socks5.ReplyCode {
+	var replyErr *socks5.ReplyError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &replyErr):
+		return replyErr.Code
+	case errors.Is(err, ErrCircuitOpen), errors.Is(err, syscall.EHOSTUNREACH):
+		return socks5.ReplyHostUnreachable
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return socks5.ReplyConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return socks5.ReplyNetworkUnreachable
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return socks5.ReplyTTLExpired
+	default:
+		return socks5.ReplyGeneralFailure
+	}
+}
+
+// writeReply sends a SOCKS5 reply with the given bound address.
+func writeReply(client net.Conn, rep socks5.ReplyCode, ip net.IP, port int) error {
+	reply := appendAddr([]byte{0x05, byte(rep), 0x00}, ip, port)
+	_, err := client.Write(reply)
+	return err
+}
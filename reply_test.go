@@ -0,0 +1,87 @@
+package gosocks_test
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+type resolverFunc func(host string) ([]net.IP, error)
+
+func (f resolverFunc) LookupIP(host string) ([]net.IP, error) {
+	return f(host)
+}
+
+// TestErrorReplies checks that the refused requests get a complete reply,
+// with a bound address, and the expected code.
+func TestErrorReplies(t *testing.T) {
+	srv := gosockstest.NewServer(t,
+		gosockstest.WithDialer(gosockstest.DialerFunc(func(network, addr string) (net.Conn, error) {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: syscall.ECONNREFUSED}
+		})),
+		func(s *gosocks.Server) {
+			s.Resolver = resolverFunc(func(host string) ([]net.IP, error) {
+				if host == "empty.test" {
+					return nil, nil
+				}
+				return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+			})
+		},
+	)
+
+	tests := []struct {
+		name string
+		cmd  byte
+		addr []byte
+		rep  byte
+	}{
+		{"refused", 0x01, socksAddr(t, "192.0.2.1:80"), 0x05},
+		{"unresolvable host", 0x01, socksAddr(t, "nowhere.test:80"), 0x04},
+		{"host without address", 0x01, socksAddr(t, "empty.test:80"), 0x04},
+		{"unknown command", 0x09, socksAddr(t, "192.0.2.1:80"), 0x07},
+		{"unknown address type", 0x01, []byte{0x07}, 0x08},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := dialProxy(t, srv)
+			negotiate(t, conn, 0x00)
+			rep, _, _ := request(t, conn, tt.cmd, tt.addr)
+			if rep != tt.rep {
+				t.Errorf("reply = %#x, want %#x", rep, tt.rep)
+			}
+		})
+	}
+}
+
+// localAddrConn is a connection bound to local.
+type localAddrConn struct {
+	net.Conn
+	local net.Addr
+}
+
+func (c localAddrConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// TestIPv4MappedReply checks that an IPv4-mapped IPv6 bound address is
+// replied as an IPv4 address.
+func TestIPv4MappedReply(t *testing.T) {
+	mapped := net.ParseIP("1.2.3.4").To16()
+	srv := gosockstest.NewServer(t, gosockstest.WithDialer(gosockstest.DialerFunc(func(network, addr string) (net.Conn, error) {
+		return localAddrConn{Conn: echoPipe(), local: &net.TCPAddr{IP: mapped, Port: 4321}}, nil
+	})))
+
+	conn := dialProxy(t, srv)
+	negotiate(t, conn, 0x00)
+	rep, atyp, bound := request(t, conn, 0x01, socksAddr(t, "192.0.2.1:80"))
+	if rep != 0x00 || atyp != 0x01 {
+		t.Fatalf("reply = %#x with address type %#x, want 0x00 and 0x01", rep, atyp)
+	}
+	if want := []byte{1, 2, 3, 4, 0x10, 0xe1}; string(bound) != string(want) {
+		t.Errorf("bound address = %v, want %v", bound, want)
+	}
+	echo(t, conn, "hello")
+}
@@ -0,0 +1,63 @@
+package gosocks
+
+import (
+	"context"
+	"io"
+	"net"
+)
+
+// methodRequestID is the vendor-specific SOCKS5 method of the request ID
+// extension. It does not authenticate, and is preferred to 0x00 when
+// RequestIDExtension is set. Once it is selected, the client sends
+// [0x01][len][request ID] before its request, without reply, or
+// [0x02][len][W3C traceparent] to make the spans of its request part of its
+// trace.
+const methodRequestID = 0xF0
+
+type clientRequestIDKey struct{}
+
+// ClientRequestID returns the request ID sent by the client with the request
+// ID extension, or "" if there is none.
+func ClientRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(clientRequestIDKey{}).(string)
+	return id
+}
+
+// readClientRequestID reads the sub-negotiation of the request ID extension,
+// and adds the ID to the context and the logs of the session.
+func (s *Server) readClientRequestID(client net.Conn, sess *session) bool {
+	addr := client.RemoteAddr().String()
+
+	var header [2]byte
+	_, err := io.ReadFull(client, header[:])
+	if err != nil {
+		sess.log.Warn("Failed to read the request ID header", "remote_addr", addr, "error", err)
+		return false
+	}
+	if header[0] != 0x01 && header[0] != 0x02 {
+		sess.log.Warn("Unknown request ID version", "remote_addr", addr, "version", header[0])
+		return false
+	}
+	id := make([]byte, header[1])
+	_, err = io.ReadFull(client, id)
+	if err != nil {
+		sess.log.Warn("Failed to read the request ID", "remote_addr", addr, "error", err)
+		return false
+	}
+	// The ID ends up in an HTTP header.
+	for _, b := range id {
+		if b <= ' ' || b >= 0x7F {
+			sess.log.Warn("The request ID must be printable ASCII", "remote_addr", addr)
+			return false
+		}
+	}
+
+	if header[0] == 0x02 {
+		sess.ctx = extractTraceParent(sess.ctx, string(id))
+		return true
+	}
+
+	sess.ctx = context.WithValue(sess.ctx, clientRequestIDKey{}, string(id))
+	sess.log = requestLogger{Logger: s.logger(), id: RequestID(sess.ctx), clientID: string(id)}
+	return true
+}
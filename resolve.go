@@ -0,0 +1,75 @@
+package gosocks
+
+import (
+	"io"
+	"net"
+
+	socks5 "github.com/glacjay/gosocks/client"
+)
+
+// cmdResolve is the RESOLVE SOCKS5 command of Tor, which resolves a host name
+// with the resolver of the server without connecting to it. The address of
+// the success reply is the first IP address of the host allowed by the ACL,
+// with port 0.
+const cmdResolve = 0xF0
+
+// handleResolve answers a RESOLVE request, whose address type is atyp.
+func (s *Server) handleResolve(client net.Conn, atyp byte, sess *session) {
+	addr := client.RemoteAddr().String()
+	sess.command = "resolve"
+
+	if atyp != 0x03 {
+		sess.log.Warn("RESOLVE requires a host name", "remote_addr", addr, "address_type", atyp)
+		writeReply(client, socks5.ReplyAddressTypeNotSupported, nil, 0)
+		return
+	}
+	var hostLen [1]byte
+	_, err := io.ReadFull(client, hostLen[:])
+	if err != nil {
+		sess.log.Warn("Failed to read requested host len", "remote_addr", addr, "error", err)
+		return
+	}
+	hostPort := make([]byte, int(hostLen[0])+2)
+	_, err = io.ReadFull(client, hostPort)
+	if err != nil {
+		sess.log.Warn("Failed to read requested host name", "remote_addr", addr, "error", err)
+		return
+	}
+	host := string(hostPort[:hostLen[0]])
+	sess.target = host
+	sess.log.Info("Requested resolution", "remote_addr", addr, "target_addr", host)
+
+	if !sess.acl.AllowClient(addrIP(client.RemoteAddr())) || !sess.acl.AllowIdentity(sess.identity) ||
+		!sess.acl.AllowTarget(host, nil) {
+		s.rejectV5(client, sess, host)
+		return
+	}
+	ips, err := s.lookupIP(sess, host)
+	if err != nil {
+		sess.outcome = outcomeDNSFail
+		sess.log.Warn("Failed to resolve requested host", "remote_addr", addr, "target_addr", host, "error", err)
+		writeReply(client, socks5.ReplyHostUnreachable, nil, 0)
+		return
+	}
+	var resolved net.IP
+	for _, ip := range ips {
+		if sess.acl.AllowTarget(host, ip) {
+			resolved = ip
+			break
+		}
+	}
+	if resolved == nil {
+		sess.outcome = outcomeDNSFail
+		sess.log.Warn("There is no IP address corresponding to the requested host", "remote_addr", addr, "target_addr", host)
+		writeReply(client, socks5.ReplyHostUnreachable, nil, 0)
+		return
+	}
+
+	err = writeReply(client, socks5.ReplySucceeded, resolved, 0)
+	if err != nil {
+		sess.log.Warn("Failed to write reply", "remote_addr", addr, "error", err)
+		return
+	}
+	sess.outcome = outcomeSuccess
+	sess.log.Debug("Resolved requested host", "remote_addr", addr, "target_addr", host, "ip", resolved)
+}
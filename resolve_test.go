@@ -0,0 +1,44 @@
+package gosocks_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+func TestResolve(t *testing.T) {
+	// Only localhost is resolved by the system, without a DNS query.
+	srv := gosockstest.NewServer(t, func(s *gosocks.Server) {
+		s.Resolver = resolverFunc(func(host string) ([]net.IP, error) {
+			if host == "localhost" {
+				return gosocks.SystemResolver{}.LookupIP(host)
+			}
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		})
+	})
+	tests := []struct {
+		name  string
+		addr  []byte
+		rep   byte
+		bound []byte
+	}{
+		{"localhost", socksAddr(t, "localhost:0"), 0x00, []byte{127, 0, 0, 1, 0, 0}},
+		{"unknown host", socksAddr(t, "nowhere.test:0"), 0x04, nil},
+		{"IP address", socksAddr(t, "127.0.0.1:0"), 0x08, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := dialProxy(t, srv)
+			negotiate(t, conn, 0x00)
+			rep, _, bound := request(t, conn, 0xF0, tt.addr)
+			if rep != tt.rep {
+				t.Fatalf("reply = %#x, want %#x", rep, tt.rep)
+			}
+			if tt.bound != nil && string(bound) != string(tt.bound) {
+				t.Errorf("resolved to %v, want %v", net.IP(bound[:len(bound)-2]), net.IP(tt.bound[:len(tt.bound)-2]))
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package gosocks
+
+import (
+	"net"
+	"slices"
+)
+
+// A RoutingTable picks the Dialer of the CONNECT requests from the IP
+// address of their target, resolved if the client sent a host name, with a
+// longest prefix match: the most specific network containing the address
+// wins.
+type RoutingTable struct {
+	routes []IPRoute
+}
+
+// An IPRoute sends the connections to the addresses of Network through
+// Dialer, or through the Dialer of the Server if it is nil, so that a more
+// specific route can exempt part of a network from a broader one.
+type IPRoute struct {
+	Network *net.IPNet
+	Dialer  Dialer
+}
+
+// NewRoutingTable returns the table of routes, which may overlap. Of routes
+// with the same network, the first one wins.
+func NewRoutingTable(routes []IPRoute) *RoutingTable {
+	t := &RoutingTable{routes: slices.Clone(routes)}
+	slices.SortStableFunc(t.routes, func(a, b IPRoute) int {
+		aOnes, _ := a.Network.Mask.Size()
+		bOnes, _ := b.Network.Mask.Size()
+		return bOnes - aOnes
+	})
+	return t
+}
+
+// Lookup returns the Dialer of the longest prefix containing ip, and whether
+// there is one.
+func (t *RoutingTable) Lookup(ip net.IP) (Dialer, bool) {
+	if t == nil || ip == nil {
+		return nil, false
+	}
+	for _, route := range t.routes {
+		if route.Network.Contains(ip) {
+			return route.Dialer, true
+		}
+	}
+	return nil, false
+}
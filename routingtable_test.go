@@ -0,0 +1,86 @@
+package gosocks_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// namedDialer is a Dialer which only tells the routes apart.
+type namedDialer string
+
+func (d namedDialer) Dial(network, addr string) (net.Conn, error) {
+	return nil, errors.New("not dialing")
+}
+
+// TestRoutingTable checks that the longest prefix containing an address
+// picks its route, with overlapping networks given in any order.
+func TestRoutingTable(t *testing.T) {
+	route := func(cidr string, dialer gosocks.Dialer) gosocks.IPRoute {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("ParseCIDR(%q): %v", cidr, err)
+		}
+		return gosocks.IPRoute{Network: network, Dialer: dialer}
+	}
+	table := gosocks.NewRoutingTable([]gosocks.IPRoute{
+		route("10.1.2.0/24", nil),
+		route("10.0.0.0/8", namedDialer("A")),
+		route("10.1.0.0/16", namedDialer("B")),
+		route("10.0.0.0/8", namedDialer("duplicate")),
+		route("172.16.0.0/12", namedDialer("C")),
+		route("172.20.5.128/25", namedDialer("D")),
+		route("2001:db8::/32", namedDialer("E")),
+		route("2001:db8:1::/48", namedDialer("F")),
+	})
+	tests := []struct {
+		ip     string
+		dialer gosocks.Dialer
+		found  bool
+	}{
+		{"10.200.0.1", namedDialer("A"), true},
+		{"10.1.9.9", namedDialer("B"), true},
+		// The more specific route without a Dialer exempts its network.
+		{"10.1.2.3", nil, true},
+		{"172.31.255.255", namedDialer("C"), true},
+		{"172.20.5.127", namedDialer("C"), true},
+		{"172.20.5.200", namedDialer("D"), true},
+		{"172.32.0.1", nil, false},
+		{"2001:db8:2::1", namedDialer("E"), true},
+		{"2001:db8:1::1", namedDialer("F"), true},
+		{"2001:db9::1", nil, false},
+		{"192.0.2.1", nil, false},
+	}
+	for _, tt := range tests {
+		dialer, found := table.Lookup(net.ParseIP(tt.ip))
+		if dialer != tt.dialer || found != tt.found {
+			t.Errorf("Lookup(%s) = %v, %v, want %v, %v", tt.ip, dialer, found, tt.dialer, tt.found)
+		}
+	}
+	if _, found := table.Lookup(nil); found {
+		t.Error("Lookup(nil) found a route")
+	}
+}
+
+// TestRoutingTableDial checks that the server dials the targets through
+// the Dialer of their route.
+func TestRoutingTableDial(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	routed := &countingDialer{}
+	srv := gosockstest.NewServer(t, func(s *gosocks.Server) {
+		s.Router = gosocks.NewRoutingTable([]gosocks.IPRoute{{Network: loopback, Dialer: routed}})
+	})
+	conn, err := client.Dial(srv.Addr(), "127.0.0.1:9")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	echo(t, conn, "hello")
+	if dials := routed.dials.Load(); dials != 1 {
+		t.Errorf("the route dialed %d times, want 1", dials)
+	}
+}
@@ -0,0 +1,77 @@
+package gosocks
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// A RuleRouter picks the Dialer of the SOCKS5 CONNECT requests by evaluating
+// expressions in the language of github.com/expr-lang/expr, such as
+//
+//	client_user == "alice" && target_host endsWith ".onion"
+//	inCIDR(client_ip, "10.0.0.0/8") && target_port in [80, 443]
+//
+// The variables are the fields of RouteEnv. The requests no rule matches
+// are dialed as if there was no RuleRouter.
+type RuleRouter struct {
+	rules    []RouteRule
+	programs []*vm.Program
+}
+
+// A RouteRule rejects the requests for which When is true if Reject is set,
+// and dials them through Dialer otherwise.
+type RouteRule struct {
+	When   string
+	Reject bool
+	Dialer Dialer
+}
+
+// RouteEnv holds the variables of the RouteRule expressions. TargetHost is
+// empty when the client requested an IP address.
+type RouteEnv struct {
+	ClientIP   string `expr:"client_ip"`
+	ClientUser string `expr:"client_user"`
+	TargetHost string `expr:"target_host"`
+	TargetIP   string `expr:"target_ip"`
+	TargetPort int    `expr:"target_port"`
+}
+
+// NewRuleRouter compiles the expressions of rules, which are tried in order.
+func NewRuleRouter(rules []RouteRule) (*RuleRouter, error) {
+	r := &RuleRouter{rules: rules}
+	for _, rule := range rules {
+		program, err := expr.Compile(rule.When, expr.Env(RouteEnv{}), expr.AsBool(), inCIDR)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule '%s': %w", rule.When, err)
+		}
+		r.programs = append(r.programs, program)
+	}
+	return r, nil
+}
+
+// Route returns the first rule matching env, or nil if there is none.
+func (r *RuleRouter) Route(env RouteEnv) (*RouteRule, error) {
+	for i, program := range r.programs {
+		matched, err := expr.Run(program, env)
+		if err != nil {
+			return nil, fmt.Errorf("rule '%s': %w", r.rules[i].When, err)
+		}
+		if matched.(bool) {
+			return &r.rules[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// inCIDR is the inCIDR(ip, network) function of the expressions.
+var inCIDR = expr.Function("inCIDR", func(params ...any) (any, error) {
+	_, network, err := net.ParseCIDR(params[1].(string))
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(params[0].(string))
+	return ip != nil && network.Contains(ip), nil
+}, new(func(string, string) bool))
@@ -0,0 +1,676 @@
+package gosocks
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after a call to
+// Shutdown.
+var ErrServerClosed = errors.New("gosocks: Server closed")
+
+// ErrNotReady is returned by WaitReady when the server is not listening in
+// time.
+var ErrNotReady = errors.New("gosocks: Server not listening yet")
+
+// A Server defines the parameters for running a SOCKS server. The zero value
+// is a valid configuration which listens on "[::]:1080", serving both IPv4
+// and IPv6 clients, and requires no authentication.
+type Server struct {
+	// Addr is the "host:port" TCP address to listen on, or a
+	// "unix:///path" Unix domain socket, "[::]:1080" if empty. It is
+	// ignored if Listeners is not empty.
+	Addr string
+
+	// Listeners are the addresses to listen on, each with its own policy.
+	Listeners []Listener
+
+	// ListenerFactory opens the listeners of ListenAndServe when non-nil.
+	// Otherwise the "unix://" addresses are opened with UnixFactory, and
+	// the others as TCP addresses.
+	ListenerFactory ListenerFactory
+
+	// Auth enables the username/password method when non-nil. Clients
+	// which do not offer that method are then refused.
+	Auth Authenticator
+
+	// GSSAPI enables the GSSAPI method when non-nil, preferred to the
+	// username/password one.
+	GSSAPI GSSAPIAuthenticator
+
+	// TOTP enables the vendor-specific TOTP method 0xF1 when non-nil,
+	// preferred to the username/password one.
+	TOTP *TOTPAuthenticator
+
+	// CertAuth authenticates the SOCKS5 clients which presented a verified
+	// TLS certificate when non-nil, see NewTLSConfig. Such a client needs
+	// no method if it offers 0x00 (no authentication), even if the others
+	// are enabled.
+	CertAuth CertAuthenticator
+
+	// AuthMethods is the order of preference of the SOCKS5 methods: 0x00
+	// (no authentication), 0x01 (GSSAPI), 0x02 (username/password) and
+	// 0xF1 (TOTP). The methods which are not enabled are skipped. If nil,
+	// GSSAPI is preferred to TOTP, then to username/password, and no
+	// authentication is only accepted if none of them is enabled.
+	AuthMethods []byte
+
+	// TLSConfig makes ListenAndServe accept the clients over TLS when
+	// non-nil. See NewTLSConfig.
+	TLSConfig *tls.Config
+
+	// ProxyProtocol makes the listeners expect a PROXY protocol header,
+	// version 1 or 2, at the start of every connection. The client address
+	// it carries replaces the one of the load balancer. With TLSConfig, the
+	// header comes before the TLS handshake.
+	ProxyProtocol bool
+
+	// TProxy makes the listeners accept the connections redirected by an
+	// iptables TPROXY or REDIRECT rule, and relay them to their original
+	// destination without any SOCKS handshake. It is only supported on
+	// Linux.
+	TProxy bool
+
+	// HTTPConnect makes the listeners also accept HTTP CONNECT requests,
+	// recognized by their first byte.
+	HTTPConnect bool
+
+	// WebSocketOrigins lists the origins, such as "https://example.com",
+	// whose pages may connect to WebSocketHandler besides its own, "*" for
+	// any. The pages of the other origins are refused; the clients which are
+	// not browsers send no origin and are accepted.
+	WebSocketOrigins []string
+
+	// ForwardClientIP adds an X-Forwarded-For header with the client IP to
+	// the first request of the relayed connections which start with an
+	// HTTP/1 request line. Encrypted traffic is left untouched.
+	ForwardClientIP bool
+
+	// HealthCheck makes the server answer the vendor-specific SOCKS5
+	// command 0xFE (PING) with a success reply, before any authentication
+	// or ACL check, so that a monitoring script can check it is up.
+	HealthCheck bool
+
+	// Mirror receives a copy of the relayed TCP traffic when non-nil.
+	Mirror *Mirror
+
+	// RequestIDExtension accepts the vendor-specific SOCKS5 method 0xF0,
+	// with which the clients send a request ID instead of authenticating.
+	// The ID is logged as client_request_id, and sent to the HTTP targets in
+	// an X-Request-Id header. See ClientRequestID.
+	RequestIDExtension bool
+
+	// ExperimentalHello accepts the experimental HELLO preamble, first byte
+	// 0x06, with which the clients advertise their capabilities before the
+	// SOCKS5 handshake, and answers it with the extensions the server
+	// supports. The standard SOCKS5 clients are not affected.
+	ExperimentalHello bool
+
+	// MaxBytesPerConn closes the relayed connections once that many bytes
+	// went through them, in both directions together, see LimitedConn. Zero
+	// means no limit.
+	MaxBytesPerConn int64
+
+	// UserMaxBytesPerConn overrides MaxBytesPerConn for the authenticated
+	// users it lists, zero meaning no limit.
+	UserMaxBytesPerConn map[string]int64
+
+	// Quotas limits the daily traffic of the authenticated users when
+	// non-nil.
+	Quotas *Quotas
+
+	// ACL restricts the clients and the targets, everything is allowed if
+	// nil.
+	ACL *ACL
+
+	// Hook is called before acting on every request when non-nil, and may
+	// refuse it.
+	Hook Hook
+
+	// Events receives the lifecycle events of the connections when
+	// non-nil, see EventBus.
+	Events EventBus
+
+	// Resolver looks up the requested host names, SystemResolver if nil.
+	Resolver Resolver
+
+	// SingleFlightDNS makes the concurrent lookups of the same host name
+	// share one call to Resolver, such as when many clients connect to a
+	// popular host at once. A Resolver returns the addresses of both
+	// families, so the host name alone is the key.
+	SingleFlightDNS bool
+
+	// Dialer opens the upstream connections, DirectDialer if nil. The
+	// upstream proxies which take host names, such as a SOCKS5Dialer, get
+	// the requested host name rather than its resolved address.
+	Dialer Dialer
+
+	// SNIRouter picks the Dialer of the SOCKS5 CONNECT requests from the
+	// TLS server name sent by the client when non-nil.
+	SNIRouter *SNIRouter
+
+	// Rules picks the Dialer of the SOCKS5 CONNECT requests, or rejects
+	// them, when non-nil. A matching rule takes precedence over SNIRouter.
+	Rules *RuleRouter
+
+	// Router picks the Dialer of the SOCKS5 CONNECT requests from the IP
+	// address of their target when non-nil, the first allowed one if the
+	// host name resolved to several. A matching rule of Rules takes
+	// precedence over it, and it takes precedence over SNIRouter.
+	Router *RoutingTable
+
+	// Pool keeps the upstream connections for reuse when non-nil.
+	Pool *Pool
+
+	// Breaker stops dialing the destinations which fail repeatedly when
+	// non-nil.
+	Breaker *CircuitBreaker
+
+	// DialRetries is how many more times an upstream connection dialed
+	// with Dialer is attempted when it is refused or its network is
+	// unreachable, waiting DialRetryBackoff, then twice as long each time.
+	// The client gets the error reply only after the last attempt. A zero
+	// DialRetryBackoff means 100 milliseconds.
+	DialRetries      int
+	DialRetryBackoff time.Duration
+
+	// Metrics collects the Prometheus metrics of the server when non-nil.
+	Metrics *Metrics
+
+	// TracerProvider creates the OpenTelemetry spans of the requests, with
+	// children for their DNS lookup, their dials and their relay, when
+	// non-nil.
+	TracerProvider trace.TracerProvider
+
+	// AccessLog records every finished connection when non-nil.
+	AccessLog *AccessLog
+
+	// Logger receives the events of the server, slog.Default() if nil.
+	Logger Logger
+
+	// BufSize is the size of the relay buffers, 32 KiB if zero.
+	BufSize int
+
+	// HandshakeTimeout limits the negotiation phase of each connection,
+	// until the relay starts. Zero means no timeout.
+	HandshakeTimeout time.Duration
+
+	// ConnectTimeout limits how long the default Dialer waits for the
+	// upstream connection. Zero means no timeout.
+	ConnectTimeout time.Duration
+
+	// IdleTimeout drops a relayed connection after no data has gone
+	// through it for that long. Zero means no timeout.
+	IdleTimeout time.Duration
+
+	// BindTimeout is how long a BIND request waits for the inbound
+	// connection, 2 minutes if zero.
+	BindTimeout time.Duration
+
+	// RateLimit is how many new connections are accepted per second, with
+	// bursts of RateBurst. The accept loop waits when it is exceeded. Zero
+	// means no limit.
+	RateLimit rate.Limit
+	RateBurst int
+
+	// PerIPRate is how many new connections a single client IP may open per
+	// second. The excess connections are delayed. Zero means no limit. With
+	// ProxyProtocol, the IP is the one of the PROXY header.
+	PerIPRate rate.Limit
+
+	// MaxConns is how many connections accepted by the listeners may be
+	// handled at once. While it is reached, the accept loops wait instead
+	// of accepting, leaving the new connections in the backlog of the
+	// listeners. Zero means no limit.
+	MaxConns int
+
+	// TCPReadBuffer and TCPWriteBuffer set the size of the kernel buffers
+	// (SO_RCVBUF and SO_SNDBUF) of the client and the remote TCP
+	// connections. Zero keeps the system default.
+	TCPReadBuffer  int
+	TCPWriteBuffer int
+
+	// DisableTCPNoDelay turns Nagle's algorithm back on for the client and
+	// the remote TCP connections, which Go disables by default.
+	DisableTCPNoDelay bool
+
+	// TCPLinger is how long closing a client or a remote TCP connection
+	// waits for the unsent data (SO_LINGER). Zero keeps the system default,
+	// and a negative value discards the data and resets the connection,
+	// which avoids TIME_WAIT.
+	TCPLinger time.Duration
+
+	// TCPKeepAlive is the interval of the TCP keep-alive probes
+	// (SO_KEEPALIVE) of the client and the remote connections, which keep
+	// the idle ones open through the NAT middleboxes. Zero means 15
+	// seconds, and a negative value disables keep-alive.
+	TCPKeepAlive time.Duration
+
+	// MaxBandwidthPerConn is how many bytes per second each direction of a
+	// relayed connection may carry. Zero means no limit.
+	MaxBandwidthPerConn int64
+
+	// MaxBandwidthTotal is how many bytes per second all the relayed
+	// connections may carry together. Zero means no limit.
+	MaxBandwidthTotal int64
+
+	mu             sync.Mutex
+	listeners      map[net.Listener]*Listener
+	conns          map[net.Conn]struct{}
+	wg             sync.WaitGroup
+	inShutdown     bool
+	bufPool        sync.Pool
+	acceptLimiter  *rate.Limiter
+	connSlots      chan struct{}
+	shutdown       chan struct{}
+	ready          chan struct{}
+	listenAddrs    []net.Addr
+	ipLimiters     *ipLimiters
+	dnsFlight      singleflight.Group
+	udpFrags       udpReassembler
+	latencies      latencyStats
+	totalBandwidth *rate.Limiter
+	ctx            context.Context
+	cancel         context.CancelFunc
+	sessions       map[string]*session
+	finishedSent   int64
+	finishedRecv   int64
+}
+
+// A Listener is an address to listen on, with its own policy.
+type Listener struct {
+	// Addr is the "host:port" TCP address or the "unix:///path" Unix domain
+	// socket to listen on.
+	Addr string
+
+	// Auth replaces the one of the Server for the clients accepted on this
+	// address when non-nil. ACL restricts them further: the ACL of the
+	// Server, as SetACL leaves it, applies to them too.
+	Auth Authenticator
+	ACL  *ACL
+}
+
+// ListenAndServe listens on s.Listeners, or on s.Addr if there is none, and
+// handles incoming connections on all of them, over TLS if s.TLSConfig is
+// set. If one of the listeners fails, the others are closed and its error is
+// returned.
+func (s *Server) ListenAndServe() error {
+	configs := s.Listeners
+	if len(configs) == 0 {
+		addr := s.Addr
+		if addr == "" {
+			addr = "[::]:1080"
+		}
+		configs = []Listener{{Addr: addr}}
+	}
+
+	listeners := make([]net.Listener, 0, len(configs))
+	for _, lc := range configs {
+		l, err := s.listen(lc.Addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+	addrs := make([]net.Addr, len(listeners))
+	for i, l := range listeners {
+		addrs[i] = l.Addr()
+	}
+	s.setReady(addrs)
+
+	errs := make(chan error, len(listeners))
+	for i, l := range listeners {
+		go func(l net.Listener, lc *Listener) {
+			errs <- s.serve(l, lc)
+		}(l, &configs[i])
+	}
+	err := <-errs
+	for _, l := range listeners {
+		l.Close()
+	}
+	for i := 1; i < len(listeners); i++ {
+		<-errs
+	}
+	return err
+}
+
+func (s *Server) listen(addr string) (net.Listener, error) {
+	factory := s.ListenerFactory
+	if factory == nil {
+		factory = s.listenTCP
+		if strings.HasPrefix(addr, unixScheme) {
+			factory = UnixFactory
+		}
+	}
+	return factory(addr)
+}
+
+// Serve accepts incoming connections on l, handling each of them in a new
+// goroutine. Temporary accept errors are retried with an exponential backoff.
+// It always returns a non-nil error and closes l.
+func (s *Server) Serve(l net.Listener) error {
+	s.setReady([]net.Addr{l.Addr()})
+	return s.serve(l, nil)
+}
+
+// WaitReady waits for ListenAndServe to listen on all its addresses, or for
+// Serve to be called, for up to timeout. It returns ErrNotReady if that
+// did not happen in time, and ErrServerClosed if the server was shut down
+// first. The clients may connect once it returns nil.
+func (s *Server) WaitReady(timeout time.Duration) error {
+	s.mu.Lock()
+	ready, shutdown := s.readyChan(), s.shutdownChan()
+	s.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return nil
+	case <-shutdown:
+		return ErrServerClosed
+	case <-timer.C:
+		return ErrNotReady
+	}
+}
+
+// ListenAddrs returns the addresses the server listens on, in the order of
+// s.Listeners followed by those of the Serve calls, so that the ports of
+// ":0" addresses can be known once WaitReady returns.
+func (s *Server) ListenAddrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.listenAddrs)
+}
+
+// setReady records addrs, on which the server listens, and wakes up
+// WaitReady.
+func (s *Server) setReady(addrs []net.Addr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listenAddrs = append(s.listenAddrs, addrs...)
+	ready := s.readyChan()
+	select {
+	case <-ready:
+	default:
+		close(ready)
+	}
+}
+
+func (s *Server) serve(l net.Listener, lc *Listener) error {
+	if !s.trackListener(l, lc, true) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer s.trackListener(l, lc, false)
+	defer l.Close()
+
+	var tempDelay time.Duration
+	for {
+		if !s.acquireConnSlot() {
+			return ErrServerClosed
+		}
+		s.waitAccept()
+		client, err := l.Accept()
+		if err != nil {
+			s.releaseConnSlot()
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			if temporaryAcceptError(err) {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if max := time.Second; tempDelay > max {
+					tempDelay = max
+				}
+				s.logger().Warn("Failed to accept new client connection", "error", err, "retry_in", tempDelay)
+				time.Sleep(tempDelay)
+				continue
+			}
+			return err
+		}
+		tempDelay = 0
+		if !s.trackConn(client, true) {
+			s.releaseConnSlot()
+			client.Close()
+			continue
+		}
+		go func() {
+			defer s.releaseConnSlot()
+			s.serveConn(client, lc)
+		}()
+	}
+}
+
+// temporaryAcceptError reports whether Accept may succeed again after err,
+// which net.Error.Temporary, deprecated, used to tell: a timeout, or running
+// out of file descriptors or memory, or a connection aborted before it was
+// accepted. Any other error is permanent.
+func temporaryAcceptError(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	for _, errno := range []syscall.Errno{syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM, syscall.ECONNABORTED, syscall.ECONNRESET} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveConn handles a client connection already registered with trackConn.
+func (s *Server) serveConn(client net.Conn, lc *Listener) {
+	defer s.trackConn(client, false)
+	ctx := context.WithValue(s.baseContext(), requestIDKey{}, newRequestID())
+	s.handleConn(ctx, client, lc)
+}
+
+// ServeConn serves a single connection accepted by the caller, such as one
+// of a port shared with another protocol, and returns once it is closed.
+// conn is served like the connections of a listener, except that canceling
+// ctx closes it too, and ctx is the parent of the context of its hooks and
+// plugins. ServeConn returns nil if the request of the client was carried
+// out, an *OutcomeError otherwise, and ErrServerClosed after Shutdown.
+func (s *Server) ServeConn(ctx context.Context, conn net.Conn) error {
+	if !s.trackConn(conn, true) {
+		conn.Close()
+		return ErrServerClosed
+	}
+	defer s.trackConn(conn, false)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(s.baseContext(), cancel)
+	defer stop()
+
+	ctx = context.WithValue(ctx, requestIDKey{}, newRequestID())
+	outcome := s.handleConn(ctx, conn, nil)
+	if outcome != outcomeSuccess {
+		return &OutcomeError{Outcome: outcome}
+	}
+	return nil
+}
+
+// An OutcomeError is returned by ServeConn when the request of the client
+// was not carried out.
+type OutcomeError struct {
+	// Outcome is the reason, as in the access log and the metrics:
+	// "handshake_fail", "auth_fail", "dns_fail", "connect_fail",
+	// "bind_fail" or "rejected".
+	Outcome string
+}
+
+func (e *OutcomeError) Error() string {
+	return "gosocks: connection ended with outcome " + e.Outcome
+}
+
+// Shutdown closes all the listeners, then waits for the active connections
+// to finish. If ctx is done before that, the contexts of the remaining
+// connections are canceled, they are closed forcibly and ctx.Err() is
+// returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.inShutdown {
+		s.inShutdown = true
+		close(s.shutdownChan())
+	}
+	for l := range s.listeners {
+		l.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.baseContext()
+		s.cancel()
+		s.mu.Lock()
+		for c := range s.conns {
+			c.Close()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// shutdownChan returns the channel closed by Shutdown. s.mu must be held.
+func (s *Server) shutdownChan() chan struct{} {
+	if s.shutdown == nil {
+		s.shutdown = make(chan struct{})
+	}
+	return s.shutdown
+}
+
+// readyChan returns the channel closed by setReady. s.mu must be held.
+func (s *Server) readyChan() chan struct{} {
+	if s.ready == nil {
+		s.ready = make(chan struct{})
+	}
+	return s.ready
+}
+
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inShutdown
+}
+
+// trackListener records l, opened for lc, nil for the listeners of Serve.
+func (s *Server) trackListener(l net.Listener, lc *Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		if s.inShutdown {
+			return false
+		}
+		if s.listeners == nil {
+			s.listeners = make(map[net.Listener]*Listener)
+		}
+		s.listeners[l] = lc
+	} else {
+		delete(s.listeners, l)
+	}
+	return true
+}
+
+func (s *Server) trackConn(c net.Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		if s.inShutdown {
+			return false
+		}
+		if s.conns == nil {
+			s.conns = make(map[net.Conn]struct{})
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+	} else {
+		delete(s.conns, c)
+		s.wg.Done()
+	}
+	return true
+}
+
+func (s *Server) bindTimeout() time.Duration {
+	if s.BindTimeout > 0 {
+		return s.BindTimeout
+	}
+	return 2 * time.Minute
+}
+
+func (s *Server) getBuffer() *[]byte {
+	if buf, ok := s.bufPool.Get().(*[]byte); ok {
+		return buf
+	}
+	size := s.BufSize
+	if size <= 0 {
+		size = 32 * 1024
+	}
+	buf := make([]byte, size)
+	return &buf
+}
+
+func (s *Server) putBuffer(buf *[]byte) {
+	s.bufPool.Put(buf)
+}
+
+// trackSession registers the sessions being relayed, as listed by the admin
+// API.
+func (s *Server) trackSession(sess *session, add bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := RequestID(sess.ctx)
+	if add {
+		if s.sessions == nil {
+			s.sessions = make(map[string]*session)
+		}
+		s.sessions[id] = sess
+	} else {
+		delete(s.sessions, id)
+		s.finishedSent += sess.sent.Load()
+		s.finishedRecv += sess.recv.Load()
+	}
+}
+
+// SetACL replaces s.ACL for the connections accepted from now on.
+func (s *Server) SetACL(acl *ACL) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ACL = acl
+}
+
+// CurrentACL returns s.ACL, which SetACL may have replaced.
+func (s *Server) CurrentACL() *ACL {
+	return s.acl()
+}
+
+func (s *Server) acl() *ACL {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ACL
+}
@@ -0,0 +1,454 @@
+package gosocks_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// failingListener returns the errors of errs from Accept, then accepts the
+// connections of its Listener.
+type failingListener struct {
+	net.Listener
+
+	mu   sync.Mutex
+	errs []error
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	if len(l.errs) > 0 {
+		err := l.errs[0]
+		l.errs = l.errs[1:]
+		l.mu.Unlock()
+		return nil, err
+	}
+	l.mu.Unlock()
+	return l.Listener.Accept()
+}
+
+func serveListener(t *testing.T, l net.Listener) (*gosocks.Server, chan error) {
+	t.Helper()
+	s := &gosocks.Server{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(l)
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		s.Shutdown(ctx)
+	})
+	return s, done
+}
+
+// TestServeTemporaryAcceptErrors checks that Serve keeps accepting after
+// temporary errors.
+func TestServeTemporaryAcceptErrors(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	l := &failingListener{Listener: inner, errs: []error{
+		&net.OpError{Op: "accept", Net: "tcp", Err: syscall.EMFILE},
+		&net.OpError{Op: "accept", Net: "tcp", Err: syscall.ENFILE},
+		&net.OpError{Op: "accept", Net: "tcp", Err: syscall.ECONNABORTED},
+	}}
+	_, done := serveListener(t, l)
+	target := gosockstest.NewEchoServer(t)
+
+	start := time.Now()
+	conn, err := client.Dial(inner.Addr().String(), target)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	echo(t, conn, "hello")
+	// The retries wait 5, 10 then 20 milliseconds.
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Errorf("accepted after %v, want a backoff of at least 35ms", elapsed)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Serve returned %v", err)
+	default:
+	}
+}
+
+// TestServePermanentAcceptError checks that Serve returns the permanent
+// accept errors, and ErrServerClosed once the server is shut down.
+func TestServePermanentAcceptError(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	permanent := errors.New("permanent")
+	_, done := serveListener(t, &failingListener{Listener: inner, errs: []error{permanent}})
+	select {
+	case err := <-done:
+		if err != permanent {
+			t.Errorf("Serve returned %v, want %v", err, permanent)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return")
+	}
+
+	inner, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	s, done := serveListener(t, inner)
+	s.WaitReady(5 * time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.Shutdown(ctx)
+	select {
+	case err := <-done:
+		if err != gosocks.ErrServerClosed {
+			t.Errorf("Serve returned %v after Shutdown, want ErrServerClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after Shutdown")
+	}
+}
+
+// TestServeConnEarlyReturn checks that ServeConn returns by itself, with the
+// outcome of the session, when the handshake or the dial fails, while the
+// client keeps its connection open.
+func TestServeConnEarlyReturn(t *testing.T) {
+	s := &gosocks.Server{
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Auth:   gosocks.Credentials{"user": "pass"},
+		Dialer: gosockstest.DialerFunc(func(network, addr string) (net.Conn, error) {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: syscall.ECONNREFUSED}
+		}),
+	}
+	auth := "\x05\x01\x02\x01\x04user\x04pass"
+	tests := []struct {
+		name    string
+		script  string
+		outcome string
+	}{
+		{"unknown version", "\x06\x01\x00", "handshake_fail"},
+		{"no acceptable method", "\x05\x01\x00", "auth_fail"},
+		{"wrong password", "\x05\x01\x02\x01\x04user\x05wrong", "auth_fail"},
+		{"unknown command", auth + "\x05\x09\x00\x01\xc0\x00\x02\x01\x00\x50", "handshake_fail"},
+		{"refused", auth + "\x05\x01\x00\x01\xc0\x00\x02\x01\x00\x50", "connect_fail"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, server := net.Pipe()
+			defer conn.Close()
+			go io.Copy(io.Discard, conn)
+			go conn.Write([]byte(tt.script))
+
+			done := make(chan error, 1)
+			go func() {
+				done <- s.ServeConn(context.Background(), server)
+			}()
+			select {
+			case err := <-done:
+				var oe *gosocks.OutcomeError
+				if !errors.As(err, &oe) || oe.Outcome != tt.outcome {
+					t.Errorf("ServeConn returned %v, want the outcome %s", err, tt.outcome)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("ServeConn did not return")
+			}
+		})
+	}
+}
+
+// TestShutdownWaitsForRelays checks that Shutdown stops accepting but lets
+// a relay in mid-transfer complete, and that it closes the relays left when
+// its context is done.
+func TestShutdownWaitsForRelays(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	s, _ := serveListener(t, inner)
+	s.WaitReady(5 * time.Second)
+	target := gosockstest.NewEchoServer(t)
+	first, err := client.Dial(inner.Addr().String(), target)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer first.Close()
+	second, err := client.Dial(inner.Addr().String(), target)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer second.Close()
+	echo(t, first, "before")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.Shutdown(ctx)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("the server still accepts after Shutdown")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The relays carry on while Shutdown waits.
+	echo(t, first, "during the shutdown")
+	echo(t, second, "during the shutdown")
+	first.Close()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v with a relay in flight", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case err := <-shutdown:
+		if err != context.Canceled {
+			t.Errorf("Shutdown returned %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return when its context was done")
+	}
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := second.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read = %d, %v after the forced shutdown, want EOF", n, err)
+	}
+}
+
+// TestListenersPolicies checks that each of the Listeners enforces its own
+// authentication and ACL.
+func TestListenersPolicies(t *testing.T) {
+	acl := &gosocks.ACL{}
+	if err := acl.AddDenyTarget("*.denied.test"); err != nil {
+		t.Fatalf("AddDenyTarget: %v", err)
+	}
+	s := &gosocks.Server{
+		Listeners: []gosocks.Listener{
+			{Addr: "127.0.0.1:0", ACL: acl},
+			{Addr: "127.0.0.1:0", Auth: gosocks.Credentials{"user": "pass"}},
+		},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Resolver: resolverFunc(func(host string) ([]net.IP, error) {
+			return []net.IP{net.IPv4(127, 0, 0, 1)}, nil
+		}),
+	}
+	go s.ListenAndServe()
+	defer func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		s.Shutdown(ctx)
+	}()
+	if err := s.WaitReady(5 * time.Second); err != nil {
+		t.Fatalf("WaitReady: %v", err)
+	}
+	open, authenticated := s.ListenAddrs()[0].String(), s.ListenAddrs()[1].String()
+	target := gosockstest.NewEchoServer(t)
+	_, port, _ := net.SplitHostPort(target)
+	denied := net.JoinHostPort("host.denied.test", port)
+
+	tests := []struct {
+		name     string
+		addr     string
+		username string
+		target   string
+		ok       bool
+	}{
+		{"open", open, "", target, true},
+		{"open denied target", open, "", denied, false},
+		{"authenticated without credentials", authenticated, "", denied, false},
+		{"authenticated", authenticated, "user", denied, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var conn net.Conn
+			var err error
+			if tt.username == "" {
+				conn, err = client.Dial(tt.addr, tt.target)
+			} else {
+				conn, err = client.DialWithAuth(tt.addr, tt.username, "pass", tt.target)
+			}
+			if ok := err == nil; ok != tt.ok {
+				t.Fatalf("Dial succeeded = %v, want %v (%v)", ok, tt.ok, err)
+			}
+			if err == nil {
+				echo(t, conn, "hello")
+				conn.Close()
+			}
+		})
+	}
+}
+
+// TestServeConnCancel checks that canceling the context of ServeConn in the
+// middle of a transfer ends the relay promptly, closing both connections,
+// and that the context of the connection carries its request ID.
+func TestServeConnCancel(t *testing.T) {
+	targetDone := make(chan struct{})
+	target := gosockstest.NewTarget(t, func(conn net.Conn) {
+		defer close(targetDone)
+		chunk := make([]byte, 1024)
+		for {
+			if _, err := conn.Write(chunk); err != nil {
+				return
+			}
+		}
+	})
+	requestIDs := make(chan string, 1)
+	s := &gosocks.Server{
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Hook: hookFunc(func(ctx context.Context, info gosocks.ConnInfo) error {
+			requestIDs <- gosocks.RequestID(ctx)
+			return nil
+		}),
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- s.ServeConn(ctx, conn)
+	}()
+
+	conn, err := client.Dial(l.Addr().String(), target)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, make([]byte, 64*1024)); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if id := <-requestIDs; id == "" {
+		t.Error("the context of the connection has no request ID")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ServeConn did not return after its context was canceled")
+	}
+	select {
+	case <-targetDone:
+	case <-time.After(time.Second):
+		t.Fatal("the connection to the target was not closed")
+	}
+	if _, err := io.Copy(io.Discard, conn); err != nil {
+		t.Errorf("the client connection was not closed: %v", err)
+	}
+}
+
+// TestServeConnPipe checks that ServeConn carries out a CONNECT request over
+// one end of a net.Pipe, returning nil once the client is done, and that it
+// returns ErrServerClosed after Shutdown.
+func TestServeConnPipe(t *testing.T) {
+	s := &gosocks.Server{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	target := gosockstest.NewEchoServer(t)
+	conn, server := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ServeConn(context.Background(), server)
+	}()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := client.Handshake(conn, "", "", target); err != nil {
+		t.Fatalf("Handshake: %v", err)
+	}
+	echo(t, conn, "hello")
+	conn.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("ServeConn returned %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeConn did not return after the client closed")
+	}
+
+	s.Shutdown(context.Background())
+	conn, server = net.Pipe()
+	defer conn.Close()
+	if err := s.ServeConn(context.Background(), server); err != gosocks.ErrServerClosed {
+		t.Errorf("ServeConn after Shutdown returned %v, want ErrServerClosed", err)
+	}
+}
+
+// TestWaitReady checks that WaitReady returns once the ":0" addresses are
+// bound, which ListenAddrs then gives, and that it fails on a server never
+// started or shut down.
+func TestWaitReady(t *testing.T) {
+	s := &gosocks.Server{
+		Listeners: []gosocks.Listener{{Addr: "127.0.0.1:0"}, {Addr: "127.0.0.1:0"}},
+		Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	go s.ListenAndServe()
+	defer func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		s.Shutdown(ctx)
+	}()
+	if err := s.WaitReady(5 * time.Second); err != nil {
+		t.Fatalf("WaitReady: %v", err)
+	}
+	addrs := s.ListenAddrs()
+	if len(addrs) != 2 {
+		t.Fatalf("ListenAddrs = %v, want 2 addresses", addrs)
+	}
+	target := gosockstest.NewEchoServer(t)
+	for _, addr := range addrs {
+		if addr.(*net.TCPAddr).Port == 0 {
+			t.Errorf("ListenAddrs has %v, want a bound port", addr)
+			continue
+		}
+		conn, err := client.Dial(addr.String(), target)
+		if err != nil {
+			t.Fatalf("Dial through %v: %v", addr, err)
+		}
+		echo(t, conn, "hello")
+		conn.Close()
+	}
+
+	idle := &gosocks.Server{}
+	if err := idle.WaitReady(50 * time.Millisecond); err != gosocks.ErrNotReady {
+		t.Errorf("WaitReady of a server never started = %v, want ErrNotReady", err)
+	}
+
+	closed := &gosocks.Server{}
+	errc := make(chan error, 1)
+	go func() { errc <- closed.WaitReady(5 * time.Second) }()
+	time.Sleep(10 * time.Millisecond)
+	closed.Shutdown(context.Background())
+	if err := <-errc; err != gosocks.ErrServerClosed {
+		t.Errorf("WaitReady of a server shut down = %v, want ErrServerClosed", err)
+	}
+}
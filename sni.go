@@ -0,0 +1,183 @@
+package gosocks
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	socks5 "github.com/glacjay/gosocks/client"
+)
+
+// An SNIRouter picks the Dialer of the CONNECT requests from the TLS server
+// name found in the ClientHello the client sends first. The success reply is
+// sent before connecting to peek at it, without decrypting anything, and the
+// peeked bytes are then replayed to the remote.
+type SNIRouter struct {
+	// Routes are tried in order, the first matching one wins.
+	Routes []SNIRoute
+
+	// Default is used when no route matches, or when the client does not
+	// start with a ClientHello. Nil means the Dialer of the Server.
+	Default Dialer
+
+	// PeekTimeout is how long the client has to send its first bytes, 2
+	// seconds if zero, so that the protocols where the server speaks first
+	// still work.
+	PeekTimeout time.Duration
+}
+
+// An SNIRoute sends the connections whose server name matches Pattern, a
+// glob as understood by path.Match, through Dialer.
+type SNIRoute struct {
+	Pattern string
+	Dialer  Dialer
+}
+
+// Route returns the Dialer for serverName, which is empty if the client did
+// not send one.
+func (r *SNIRouter) Route(serverName string) Dialer {
+	if serverName != "" {
+		serverName = strings.ToLower(serverName)
+		for _, route := range r.Routes {
+			if matched, _ := path.Match(strings.ToLower(route.Pattern), serverName); matched {
+				return route.Dialer
+			}
+		}
+	}
+	return r.Default
+}
+
+func (r *SNIRouter) peekTimeout() time.Duration {
+	if r.PeekTimeout > 0 {
+		return r.PeekTimeout
+	}
+	return 2 * time.Second
+}
+
+// connectSNI completes a CONNECT request through s.SNIRouter.
+func (s *Server) connectSNI(client net.Conn, target *net.TCPAddr, targetIPs []net.IP, sess *session) {
+	addr := client.RemoteAddr().String()
+
+	err := writeReply(client, socks5.ReplySucceeded, nil, 0)
+	if err != nil {
+		sess.log.Warn("Failed to write reply", "remote_addr", addr, "error", err)
+		return
+	}
+	hello, serverName := peekClientHello(client, s.SNIRouter.peekTimeout())
+	sess.log.Info("Peeked TLS server name", "remote_addr", addr, "target_addr", target.String(), "server_name", serverName)
+
+	var remote net.Conn
+	if dialer := s.SNIRouter.Route(serverName); dialer != nil {
+		remote, err = s.dialWith(sess.ctx, dialer, target.String())
+	} else if len(targetIPs) > 1 {
+		remote, err = s.dialHappyEyeballs(sess.ctx, targetIPs, target.Port)
+	} else {
+		remote, err = s.dial(sess.ctx, "tcp", target.String())
+	}
+	if err != nil {
+		s.dialFailed(sess, err)
+		sess.log.Warn("Failed to connect to the requested address", "remote_addr", addr, "target_addr", target.String(), "error", err)
+		return
+	}
+	defer remote.Close()
+	s.connected(sess, remote)
+
+	_, err = remote.Write(hello)
+	if err != nil {
+		sess.outcome = outcomeConnectFail
+		sess.log.Warn("Failed to write to the remote", "remote_addr", addr, "target_addr", target.String(), "error", err)
+		return
+	}
+	sess.sent.Add(int64(len(hello)))
+	s.Quotas.add(sess.identity, int64(len(hello)))
+
+	s.relay(client, remote, sess)
+}
+
+// peekClientHello reads the first TLS record sent by the client, and returns
+// the bytes it read along with the server name of the ClientHello, if that
+// is what they are.
+func peekClientHello(client net.Conn, timeout time.Duration) ([]byte, string) {
+	client.SetReadDeadline(time.Now().Add(timeout))
+	defer client.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, 5+0x4000)
+	n, err := io.ReadAtLeast(client, buf, 5)
+	if err != nil || buf[0] != 0x16 {
+		return buf[:n], ""
+	}
+	recordLen := int(binary.BigEndian.Uint16(buf[3:5]))
+	if recordLen > 0x4000 {
+		return buf[:n], ""
+	}
+	if n < 5+recordLen {
+		m, err := io.ReadFull(client, buf[n:5+recordLen])
+		n += m
+		if err != nil {
+			return buf[:n], ""
+		}
+	}
+	return buf[:n], parseServerName(buf[5 : 5+recordLen])
+}
+
+// parseServerName returns the host name of the server_name extension in a
+// ClientHello handshake message, or "".
+func parseServerName(msg []byte) string {
+	// Handshake type, length, client version and random.
+	if len(msg) < 4+2+32 || msg[0] != 0x01 {
+		return ""
+	}
+	b := msg[4+2+32:]
+
+	skip := func(lenBytes int) bool {
+		if len(b) < lenBytes {
+			return false
+		}
+		n := 0
+		for _, c := range b[:lenBytes] {
+			n = n<<8 | int(c)
+		}
+		if len(b) < lenBytes+n {
+			return false
+		}
+		b = b[lenBytes+n:]
+		return true
+	}
+	// Session ID, cipher suites and compression methods.
+	if !skip(1) || !skip(2) || !skip(1) || len(b) < 2 {
+		return ""
+	}
+	extensions := b[2:]
+	if n := int(binary.BigEndian.Uint16(b)); n < len(extensions) {
+		extensions = extensions[:n]
+	}
+
+	for len(extensions) >= 4 {
+		extType := binary.BigEndian.Uint16(extensions)
+		extLen := int(binary.BigEndian.Uint16(extensions[2:]))
+		if len(extensions) < 4+extLen {
+			return ""
+		}
+		ext := extensions[4 : 4+extLen]
+		extensions = extensions[4+extLen:]
+		if extType != 0x0000 || len(ext) < 2 {
+			continue
+		}
+		names := ext[2:]
+		for len(names) >= 3 {
+			nameType := names[0]
+			nameLen := int(binary.BigEndian.Uint16(names[1:]))
+			if len(names) < 3+nameLen {
+				return ""
+			}
+			if nameType == 0x00 {
+				return string(names[3 : 3+nameLen])
+			}
+			names = names[3+nameLen:]
+		}
+	}
+	return ""
+}
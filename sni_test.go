@@ -0,0 +1,90 @@
+package gosocks_test
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// TestSNIRouter checks that the server name of the ClientHello picks the
+// route, and that the peeked bytes are replayed to the remote intact: the
+// TLS target completes the handshake and sees the same server name.
+func TestSNIRouter(t *testing.T) {
+	cert := selfSignedCert(t)
+	serverNames := make(chan string, 1)
+	target := gosockstest.NewTarget(t, func(conn net.Conn) {
+		tlsConn := tls.Server(conn, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+				serverNames <- hello.ServerName
+				return nil, nil
+			},
+		})
+		io.Copy(tlsConn, tlsConn)
+	})
+	var mu sync.Mutex
+	var routed []string
+	internal := gosockstest.DialerFunc(func(network, addr string) (net.Conn, error) {
+		mu.Lock()
+		routed = append(routed, addr)
+		mu.Unlock()
+		return net.Dial(network, addr)
+	})
+	srv := gosockstest.NewServer(t, func(s *gosocks.Server) {
+		s.SNIRouter = &gosocks.SNIRouter{
+			Routes: []gosocks.SNIRoute{{Pattern: "*.INTERNAL", Dialer: internal}},
+		}
+	})
+
+	tests := []struct {
+		serverName string
+		routed     bool
+	}{
+		{"api.internal", true},
+		{"example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			mu.Lock()
+			routed = nil
+			mu.Unlock()
+			conn, err := client.Dial(srv.Addr(), target)
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			tlsConn := tls.Client(conn, &tls.Config{ServerName: tt.serverName, InsecureSkipVerify: true})
+			if err := tlsConn.Handshake(); err != nil {
+				t.Fatalf("Handshake through the router: %v", err)
+			}
+			if got := <-serverNames; got != tt.serverName {
+				t.Errorf("the target got the server name %q, want %q", got, tt.serverName)
+			}
+			echo(t, tlsConn, "hello")
+
+			mu.Lock()
+			defer mu.Unlock()
+			if got := len(routed) == 1 && routed[0] == target; got != tt.routed {
+				t.Errorf("routed through the internal dialer = %v (%q), want %v", got, routed, tt.routed)
+			}
+		})
+	}
+
+	// A client which does not start with a ClientHello goes the default
+	// way, with its first bytes replayed.
+	echoTarget := gosockstest.NewEchoServer(t)
+	conn, err := client.Dial(srv.Addr(), echoTarget)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	echo(t, conn, "not a ClientHello")
+}
@@ -0,0 +1,162 @@
+package gosocks
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// SOCKS4 only defines the CONNECT and BIND commands, and only CONNECT is
+// implemented here; BIND requests are rejected with 0x5B. The SOCKS4a
+// extension (DSTIP of 0.0.0.x followed by a host name) is supported, the host
+// name is resolved on the server side.
+
+const maxV4StringLen = 255
+
+func (s *Server) handleV4(client net.Conn, sess *session) {
+	addr := client.RemoteAddr().String()
+
+	var reply [8]byte
+	reply[0] = 0x00 // VN
+	reply[1] = 0x5B // CD: request rejected or failed
+
+	var requestHeader [7]byte
+	_, err := io.ReadFull(client, requestHeader[:])
+	if err != nil {
+		sess.log.Warn("Failed to read the socks4 request header", "remote_addr", addr, "error", err)
+		return
+	}
+
+	switch requestHeader[0] {
+	case 0x01:
+		sess.command = "connect"
+		sess.requested = time.Now()
+	case 0x02:
+		sess.command = "bind"
+		sess.log.Warn("Only implemented CONNECT command for socks4 currently", "remote_addr", addr)
+		client.Write(reply[:])
+		return
+	default:
+		sess.log.Warn("Unknown socks4 command, not a socks4 request", "remote_addr", addr, "command", requestHeader[0])
+		client.Write(reply[:])
+		return
+	}
+
+	_, err = readNullTerminated(client, maxV4StringLen)
+	if err != nil {
+		sess.log.Warn("Failed to read the socks4 user id", "remote_addr", addr, "error", err)
+		client.Write(reply[:])
+		return
+	}
+
+	s.authenticateCert(client, sess)
+	if !s.anonymousAllowed(sess) {
+		sess.outcome = outcomeAuthFail
+		sess.log.Warn("The server requires an authentication socks4 does not support", "remote_addr", addr)
+		client.Write(reply[:])
+		return
+	}
+
+	remoteAddress := new(net.TCPAddr)
+	remoteAddress.Port = int(requestHeader[1])<<8 + int(requestHeader[2])
+	var targetHost string
+	ip := requestHeader[3:7]
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		host, err := readNullTerminated(client, maxV4StringLen)
+		if err != nil {
+			sess.log.Warn("Failed to read requested host name", "remote_addr", addr, "error", err)
+			client.Write(reply[:])
+			return
+		}
+		targetHost = string(host)
+		if !sess.acl.AllowTarget(targetHost, nil) {
+			sess.outcome = outcomeRejected
+			sess.log.Warn("Connection not allowed by ruleset", "remote_addr", addr, "target_addr", targetHost)
+			client.Write(reply[:])
+			return
+		}
+		ips, err := s.lookupIP(sess, string(host))
+		if err != nil {
+			sess.outcome = outcomeDNSFail
+			sess.log.Warn("Failed to resolve requested host", "remote_addr", addr, "target_addr", string(host), "error", err)
+			client.Write(reply[:])
+			return
+		}
+		for _, ip := range ips {
+			if ip4 := ip.To4(); ip4 != nil {
+				remoteAddress.IP = ip4
+				break
+			}
+		}
+		if remoteAddress.IP == nil {
+			sess.outcome = outcomeDNSFail
+			sess.log.Warn("There is no IPv4 address corresponding to the requested host", "remote_addr", addr, "target_addr", string(host))
+			client.Write(reply[:])
+			return
+		}
+	} else {
+		remoteAddress.IP = net.IPv4(ip[0], ip[1], ip[2], ip[3]).To4()
+	}
+	sess.target = remoteAddress.String()
+	if targetHost != "" {
+		sess.target = net.JoinHostPort(targetHost, strconv.Itoa(remoteAddress.Port))
+	}
+	sess.log.Info("Requested address", "remote_addr", addr, "target_addr", remoteAddress.String())
+
+	if !sess.acl.AllowClient(addrIP(client.RemoteAddr())) || !sess.acl.AllowIdentity(sess.identity) || !sess.acl.AllowTarget(targetHost, remoteAddress.IP) {
+		sess.outcome = outcomeRejected
+		sess.log.Warn("Connection not allowed by ruleset", "remote_addr", addr, "target_addr", remoteAddress.String())
+		client.Write(reply[:])
+		return
+	}
+	if !s.preRelay(client, sess, []net.IP{remoteAddress.IP}) {
+		client.Write(reply[:])
+		return
+	}
+
+	dialAddr := remoteAddress.String()
+	if targetHost != "" && sendsHostNames(s.dialer()) {
+		dialAddr = sess.target
+	}
+	remote, err := s.dial(sess.ctx, "tcp", dialAddr)
+	if err != nil {
+		s.dialFailed(sess, err)
+		sess.log.Warn("Failed to connect to the requested address", "remote_addr", addr, "target_addr", remoteAddress.String(), "error", err)
+		client.Write(reply[:])
+		return
+	}
+	defer remote.Close()
+	s.connected(sess, remote)
+
+	reply[1] = 0x5A // CD: request granted
+	reply[2] = byte(remoteAddress.Port >> 8)
+	reply[3] = byte(remoteAddress.Port % 256)
+	copy(reply[4:8], remoteAddress.IP)
+	_, err = client.Write(reply[:])
+	if err != nil {
+		sess.log.Warn("Failed to write reply", "remote_addr", addr, "error", err)
+		return
+	}
+
+	s.relay(client, remote, sess)
+}
+
+func readNullTerminated(r io.Reader, maxLen int) ([]byte, error) {
+	var buf []byte
+	var b [1]byte
+	for {
+		_, err := io.ReadFull(r, b[:])
+		if err != nil {
+			return nil, err
+		}
+		if b[0] == 0x00 {
+			return buf, nil
+		}
+		if len(buf) == maxLen {
+			return nil, fmt.Errorf("string is longer than %d bytes", maxLen)
+		}
+		buf = append(buf, b[0])
+	}
+}
@@ -0,0 +1,64 @@
+package gosocks_test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+func TestSOCKS4RequiresNoAuthentication(t *testing.T) {
+	srv := gosockstest.NewServer(t, gosockstest.WithAuth("alice", "secret"))
+	target := gosockstest.NewEchoServer(t)
+	_, portString, _ := net.SplitHostPort(target)
+	port, _ := strconv.Atoi(portString)
+
+	conn := dialProxy(t, srv)
+	_, err := conn.Write(append(binary.BigEndian.AppendUint16([]byte{0x04, 0x01}, uint16(port)), 127, 0, 0, 1, 0))
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	var reply [8]byte
+	_, err = io.ReadFull(conn, reply[:])
+	if err != nil {
+		t.Fatalf("Read reply: %v", err)
+	}
+	if reply[1] != 0x5B {
+		t.Fatalf("reply code = %#x, want 0x5b", reply[1])
+	}
+}
+
+// TestSOCKS4aDeniedHost checks that a SOCKS4a host name denied by the ACL is
+// refused without being resolved.
+func TestSOCKS4aDeniedHost(t *testing.T) {
+	var lookups atomic.Int32
+	srv := gosockstest.NewServer(t, func(s *gosocks.Server) {
+		s.ACL = &gosocks.ACL{DenyHosts: []string{"*.denied.test"}}
+		s.Resolver = resolverFunc(func(host string) ([]net.IP, error) {
+			lookups.Add(1)
+			return []net.IP{net.IPv4(127, 0, 0, 1)}, nil
+		})
+	})
+
+	conn := dialProxy(t, srv)
+	_, err := conn.Write(append([]byte{0x04, 0x01, 0x00, 0x50, 0, 0, 0, 1, 0}, "www.denied.test\x00"...))
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	var reply [8]byte
+	_, err = io.ReadFull(conn, reply[:])
+	if err != nil {
+		t.Fatalf("Read reply: %v", err)
+	}
+	if reply[1] != 0x5B {
+		t.Errorf("reply code = %#x, want 0x5b", reply[1])
+	}
+	if n := lookups.Load(); n != 0 {
+		t.Errorf("resolved the denied host %d times", n)
+	}
+}
@@ -0,0 +1,99 @@
+package gosocks
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// A DNSRule sends the lookups of the host names matching Pattern to
+// Resolver. A pattern is either a host name, or "*." followed by a domain
+// which matches all the names below it; "*." alone matches every name.
+type DNSRule struct {
+	Pattern  string
+	Resolver Resolver
+}
+
+// SplitResolver is a Resolver picking another one per host name, for split
+// horizon DNS. The rule with the longest matching pattern wins.
+type SplitResolver struct {
+	Rules []DNSRule
+
+	// Default resolves the names no rule matches, SystemResolver if nil.
+	Default Resolver
+}
+
+func (r *SplitResolver) LookupIP(host string) ([]net.IP, error) {
+	return r.LookupIPContext(context.Background(), host)
+}
+
+func (r *SplitResolver) LookupIPContext(ctx context.Context, host string) ([]net.IP, error) {
+	return lookupIP(ctx, r.Route(host), host)
+}
+
+// Route returns the Resolver of host.
+func (r *SplitResolver) Route(host string) Resolver {
+	name := fqdn(host)
+	var best Resolver
+	bestLen := -1
+	for _, rule := range r.Rules {
+		pattern := fqdn(rule.Pattern)
+		matched := name == pattern
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok && strings.HasPrefix(suffix, ".") {
+			matched = strings.HasSuffix(name, suffix)
+		}
+		if matched && len(pattern) > bestLen {
+			best, bestLen = rule.Resolver, len(pattern)
+		}
+	}
+	if best != nil {
+		return best
+	}
+	if r.Default != nil {
+		return r.Default
+	}
+	return SystemResolver{}
+}
+
+func fqdn(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, ".")) + "."
+}
+
+// DNSServerResolver sends the lookups to the DNS server at Addr, a
+// "host:port" address, with the pure Go resolver of the net package.
+type DNSServerResolver struct {
+	Addr string
+}
+
+func (r DNSServerResolver) LookupIP(host string) ([]net.IP, error) {
+	return r.LookupIPContext(context.Background(), host)
+}
+
+func (r DNSServerResolver) LookupIPContext(ctx context.Context, host string) ([]net.IP, error) {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, r.Addr)
+		},
+	}
+	return resolver.LookupIP(ctx, "ip", host)
+}
+
+// ParseDNSRules parses a comma-separated list of 'pattern=host:port' rules,
+// such as "*.corp.example.com=192.168.1.53:53,*.=8.8.8.8:53".
+func ParseDNSRules(value string) ([]DNSRule, error) {
+	var rules []DNSRule
+	for _, field := range strings.Split(value, ",") {
+		pattern, addr, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("expected 'pattern=host:port', got '%s'", field)
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("invalid DNS server in '%s': %v", field, err)
+		}
+		rules = append(rules, DNSRule{Pattern: pattern, Resolver: DNSServerResolver{Addr: addr}})
+	}
+	return rules, nil
+}
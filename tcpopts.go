@@ -0,0 +1,77 @@
+package gosocks
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// tuneConn applies the TCP options of s to conn, an accepted client or a
+// dialed remote connection.
+func (s *Server) tuneConn(conn net.Conn) {
+	tcp := tcpConn(conn)
+	if tcp == nil {
+		return
+	}
+	if s.TCPReadBuffer > 0 {
+		err := tcp.SetReadBuffer(s.TCPReadBuffer)
+		if err != nil {
+			s.logger().Warn("Failed to set the TCP receive buffer", "remote_addr", conn.RemoteAddr().String(), "error", err)
+		}
+	}
+	if s.TCPWriteBuffer > 0 {
+		err := tcp.SetWriteBuffer(s.TCPWriteBuffer)
+		if err != nil {
+			s.logger().Warn("Failed to set the TCP send buffer", "remote_addr", conn.RemoteAddr().String(), "error", err)
+		}
+	}
+	if s.DisableTCPNoDelay {
+		err := tcp.SetNoDelay(false)
+		if err != nil {
+			s.logger().Warn("Failed to enable Nagle's algorithm", "remote_addr", conn.RemoteAddr().String(), "error", err)
+		}
+	}
+	if s.TCPKeepAlive < 0 {
+		err := tcp.SetKeepAlive(false)
+		if err != nil {
+			s.logger().Warn("Failed to disable TCP keep-alive", "remote_addr", conn.RemoteAddr().String(), "error", err)
+		}
+	} else {
+		period := s.TCPKeepAlive
+		if period == 0 {
+			period = 15 * time.Second
+		}
+		err := tcp.SetKeepAlive(true)
+		if err == nil {
+			err = tcp.SetKeepAlivePeriod(period)
+		}
+		if err != nil {
+			s.logger().Warn("Failed to enable TCP keep-alive", "remote_addr", conn.RemoteAddr().String(), "error", err)
+		}
+	}
+	if s.TCPLinger != 0 {
+		sec := int(s.TCPLinger / time.Second)
+		if s.TCPLinger < 0 {
+			sec = 0
+		}
+		err := tcp.SetLinger(sec)
+		if err != nil {
+			s.logger().Warn("Failed to set the TCP linger timeout", "remote_addr", conn.RemoteAddr().String(), "error", err)
+		}
+	}
+}
+
+// tcpConn returns the TCP connection under conn, or nil.
+func tcpConn(conn net.Conn) *net.TCPConn {
+	switch c := conn.(type) {
+	case *net.TCPConn:
+		return c
+	case *tls.Conn:
+		return tcpConn(c.NetConn())
+	case *proxyConn:
+		return tcpConn(c.Conn)
+	case *poolConn:
+		return tcpConn(c.Conn)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package gosocks_test
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// waitClosed returns how long conn took to be closed by its peer, failing
+// if it sent data or took more than limit.
+func waitClosed(t *testing.T, conn net.Conn, limit time.Duration) time.Duration {
+	t.Helper()
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(limit))
+	n, err := conn.Read(make([]byte, 1))
+	if n > 0 || err != io.EOF {
+		t.Fatalf("Read = %d, %v, want the connection closed", n, err)
+	}
+	return time.Since(start)
+}
+
+// TestHandshakeTimeout checks that a client which stalls in the negotiation
+// is dropped.
+func TestHandshakeTimeout(t *testing.T) {
+	srv := gosockstest.NewServer(t, func(s *gosocks.Server) {
+		s.HandshakeTimeout = 100 * time.Millisecond
+	})
+	conn := dialProxy(t, srv)
+	conn.Write([]byte{0x05, 0x01})
+	if elapsed := waitClosed(t, conn, 5*time.Second); elapsed < 50*time.Millisecond {
+		t.Errorf("dropped after %v, before the timeout", elapsed)
+	}
+}
+
+// TestIdleTimeout checks that a relay in which nothing happens is dropped,
+// and that the traffic pushes its deadline forward.
+func TestIdleTimeout(t *testing.T) {
+	srv := gosockstest.NewServer(t, func(s *gosocks.Server) {
+		s.IdleTimeout = 200 * time.Millisecond
+	})
+	target := gosockstest.NewEchoServer(t)
+	conn, err := client.Dial(srv.Addr(), target)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	// The traffic keeps the relay alive past the timeout.
+	for range 4 {
+		time.Sleep(100 * time.Millisecond)
+		echo(t, conn, "hello")
+	}
+	if elapsed := waitClosed(t, conn, 5*time.Second); elapsed < 100*time.Millisecond {
+		t.Errorf("dropped after %v idle, before the timeout", elapsed)
+	}
+}
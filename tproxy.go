@@ -0,0 +1,84 @@
+package gosocks
+
+import (
+	"net"
+	"time"
+)
+
+// handleTProxy relays a connection redirected by the firewall to its
+// original destination, as if the client had sent a SOCKS5 CONNECT request
+// for it.
+func (s *Server) handleTProxy(client net.Conn, sess *session) {
+	addr := client.RemoteAddr().String()
+
+	target, err := originalDst(client)
+	if err != nil || target == nil {
+		sess.log.Warn("Failed to read the original destination", "remote_addr", addr, "error", err)
+		return
+	}
+	sess.command = "connect"
+	sess.requested = time.Now()
+	sess.target = target.String()
+	sess.log.Info("Requested address", "remote_addr", addr, "target_addr", sess.target)
+
+	if s.listensOn(client, target) {
+		sess.outcome = outcomeRejected
+		sess.log.Warn("The original destination is the proxy itself", "remote_addr", addr, "target_addr", sess.target)
+		return
+	}
+	if !sess.acl.AllowClient(addrIP(client.RemoteAddr())) || !sess.acl.AllowTarget("", target.IP) {
+		sess.outcome = outcomeRejected
+		sess.log.Warn("Connection not allowed by ruleset", "remote_addr", addr, "target_addr", sess.target)
+		return
+	}
+	if !s.preRelay(client, sess, []net.IP{target.IP}) {
+		return
+	}
+
+	remote, err := s.dial(sess.ctx, "tcp", sess.target)
+	if err != nil {
+		s.dialFailed(sess, err)
+		sess.log.Warn("Failed to connect to the requested address", "remote_addr", addr, "target_addr", sess.target, "error", err)
+		return
+	}
+	defer remote.Close()
+	s.connected(sess, remote)
+
+	s.relay(client, remote, sess)
+}
+
+// listensOn reports whether target is an address the server listens on, the
+// local address of client or one of ListenAddrs, as when a client connects
+// to the transparent proxy port directly instead of being redirected to it.
+// Relaying to target would then connect the proxy to itself in a loop. With
+// TPROXY the local address is the original destination, so it only counts if
+// its IP address belongs to this host.
+func (s *Server) listensOn(client net.Conn, target *net.TCPAddr) bool {
+	matched := false
+	for _, a := range append([]net.Addr{client.LocalAddr()}, s.ListenAddrs()...) {
+		l, ok := a.(*net.TCPAddr)
+		if ok && l.Port == target.Port && (l.IP.Equal(target.IP) || l.IP.IsUnspecified()) {
+			matched = true
+			break
+		}
+	}
+	return matched && hostIP(target.IP)
+}
+
+// hostIP reports whether ip is an address of one of the interfaces of this
+// host.
+func hostIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package gosocks
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+)
+
+const (
+	ipv6Transparent   = 75 // IPV6_TRANSPARENT
+	soOriginalDst     = 80 // SO_ORIGINAL_DST
+	ip6tSoOriginalDst = 80 // IP6T_SO_ORIGINAL_DST
+)
+
+// transparentControl sets IP_TRANSPARENT on the listening socket, so that it
+// accepts the connections redirected by an iptables TPROXY rule.
+func transparentControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_IP, syscall.IP_TRANSPARENT, 1)
+		if sockErr == nil && network == "tcp6" {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_IPV6, ipv6Transparent, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// originalDst returns the destination the client connected to before being
+// redirected. With TPROXY it is the local address of the connection; with a
+// REDIRECT rule it is read from SO_ORIGINAL_DST.
+func originalDst(conn net.Conn) (*net.TCPAddr, error) {
+	local, _ := conn.LocalAddr().(*net.TCPAddr)
+	tcp := tcpConn(conn)
+	if tcp == nil {
+		return local, nil
+	}
+	raw, err := tcp.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var dst *net.TCPAddr
+	err = raw.Control(func(fd uintptr) {
+		if local != nil && local.IP.To4() == nil {
+			info, err := syscall.GetsockoptIPv6MTUInfo(int(fd), syscall.SOL_IPV6, ip6tSoOriginalDst)
+			if err == nil {
+				// The port is kept in network byte order.
+				var port [2]byte
+				binary.NativeEndian.PutUint16(port[:], info.Addr.Port)
+				dst = &net.TCPAddr{IP: net.IP(info.Addr.Addr[:]), Port: int(binary.BigEndian.Uint16(port[:]))}
+			}
+			return
+		}
+		mreq, err := syscall.GetsockoptIPv6Mreq(int(fd), syscall.SOL_IP, soOriginalDst)
+		if err == nil {
+			// The buffer holds a sockaddr_in.
+			b := mreq.Multiaddr
+			dst = &net.TCPAddr{IP: net.IPv4(b[4], b[5], b[6], b[7]).To4(), Port: int(b[2])<<8 | int(b[3])}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if dst == nil {
+		return local, nil
+	}
+	return dst, nil
+}
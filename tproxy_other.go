@@ -0,0 +1,18 @@
+//go:build !linux
+
+package gosocks
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+func transparentControl(network, address string, c syscall.RawConn) error {
+	return errors.New("transparent proxy mode is only supported on Linux")
+}
+
+func originalDst(conn net.Conn) (*net.TCPAddr, error) {
+	local, _ := conn.LocalAddr().(*net.TCPAddr)
+	return local, nil
+}
@@ -0,0 +1,42 @@
+package gosocks
+
+import (
+	"net"
+	"testing"
+)
+
+type localAddrConn struct {
+	net.Conn
+	local net.Addr
+}
+
+func (c localAddrConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func TestListensOn(t *testing.T) {
+	s := &Server{}
+	s.setReady([]net.Addr{&net.TCPAddr{IP: net.IPv6unspecified, Port: 1080}})
+
+	tests := []struct {
+		name   string
+		local  string
+		target string
+		want   bool
+	}{
+		{"direct connection", "127.0.0.1:1080", "127.0.0.1:1080", true},
+		{"other listening address", "127.0.0.1:1081", "127.0.0.1:1080", true},
+		{"redirected", "127.0.0.1:1080", "127.0.0.1:8080", false},
+		{"TPROXY", "192.0.2.1:80", "192.0.2.1:80", false},
+		{"TPROXY to the listening port", "192.0.2.1:1080", "192.0.2.1:1080", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, _ := net.ResolveTCPAddr("tcp", tt.local)
+			target, _ := net.ResolveTCPAddr("tcp", tt.target)
+			if got := s.listensOn(localAddrConn{local: local}, target); got != tt.want {
+				t.Errorf("listensOn(%v, %v) = %v, want %v", tt.local, tt.target, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package gosocks
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName is the instrumentation scope of the spans.
+const tracerName = "github.com/glacjay/gosocks"
+
+func (s *Server) tracer() trace.Tracer {
+	if s.TracerProvider != nil {
+		return s.TracerProvider.Tracer(tracerName)
+	}
+	return noop.NewTracerProvider().Tracer(tracerName)
+}
+
+// startRequestSpan starts the span of the request of sess, the parent of
+// those of its DNS lookup, its dial and its relay. It is the child of the
+// trace context the client sent with the request ID extension, if any, and a
+// root span otherwise. endRequestSpan ends it.
+func (s *Server) startRequestSpan(sess *session) {
+	sess.ctx, sess.span = s.tracer().Start(sess.ctx, "socks.request", trace.WithSpanKind(trace.SpanKindServer))
+}
+
+// endRequestSpan ends the span of startRequestSpan, if it was started, with
+// the attributes of the request.
+func (s *Server) endRequestSpan(sess *session) {
+	if sess.span == nil {
+		return
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("socks.version", sess.version),
+		attribute.String("socks.command", sess.command),
+		attribute.String("socks.outcome", sess.outcome),
+		attribute.Int64("socks.bytes_sent", sess.sent.Load()),
+		attribute.Int64("socks.bytes_recv", sess.recv.Load()),
+	}
+	sess.span.SetAttributes(append(attrs, peerAttributes(sess.target)...)...)
+	if sess.outcome != outcomeSuccess {
+		sess.span.SetStatus(codes.Error, sess.outcome)
+	}
+	sess.span.End()
+}
+
+// startSpan starts a child span of the one of ctx, ended by the returned
+// function with err as its status.
+func (s *Server) startSpan(ctx context.Context, name, target string) (context.Context, func(err error)) {
+	ctx, span := s.tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(peerAttributes(target)...))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// peerAttributes returns the net.peer.name and net.peer.port attributes of
+// a "host:port" target, or a host name alone.
+func peerAttributes(target string) []attribute.KeyValue {
+	if target == "" {
+		return nil
+	}
+	host, portString, err := net.SplitHostPort(target)
+	if err != nil {
+		return []attribute.KeyValue{attribute.String("net.peer.name", target)}
+	}
+	attrs := []attribute.KeyValue{attribute.String("net.peer.name", host)}
+	if port, err := strconv.Atoi(portString); err == nil {
+		attrs = append(attrs, attribute.Int("net.peer.port", port))
+	}
+	return attrs
+}
+
+// extractTraceParent adds the W3C traceparent sent by the client to ctx.
+func extractTraceParent(ctx context.Context, traceParent string) context.Context {
+	return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": traceParent})
+}
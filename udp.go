@@ -0,0 +1,192 @@
+package gosocks
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	socks5 "github.com/glacjay/gosocks/client"
+)
+
+const (
+	// udpLookupTimeout limits the resolution of a host name requested in
+	// a UDP datagram.
+	udpLookupTimeout = 5 * time.Second
+
+	// udpMaxLookups is how many host names a UDP association resolves at
+	// once; the datagrams beyond are dropped.
+	udpMaxLookups = 16
+)
+
+func (s *Server) handleUDPAssociate(client net.Conn, hint *net.TCPAddr, sess *session) {
+	addr := client.RemoteAddr().String()
+
+	localIP := addrIP(client.LocalAddr())
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP})
+	if err != nil {
+		sess.log.Error("Failed to open the UDP relay socket", "remote_addr", addr, "error", err)
+		writeReply(client, socks5.ReplyGeneralFailure, nil, 0)
+		return
+	}
+	defer relay.Close()
+	stop := context.AfterFunc(sess.ctx, func() {
+		relay.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	bound := relay.LocalAddr().(*net.UDPAddr)
+	defer s.udpFrags.forget(bound.String())
+	err = writeReply(client, socks5.ReplySucceeded, bound.IP, bound.Port)
+	if err != nil {
+		sess.log.Warn("Failed to write reply", "remote_addr", addr, "error", err)
+		return
+	}
+	sess.outcome = outcomeSuccess
+	sess.log.Info("UDP relay listening", "remote_addr", addr, "bind_addr", bound.String())
+	client.SetDeadline(time.Time{})
+
+	// The association terminates when the control connection is closed.
+	go func() {
+		var buf [1]byte
+		for {
+			_, err := client.Read(buf[:])
+			if err != nil {
+				break
+			}
+		}
+		relay.Close()
+	}()
+
+	clientIP := addrIP(client.RemoteAddr())
+	var clientAddr *net.UDPAddr
+	if hint.Port != 0 {
+		clientAddr = &net.UDPAddr{IP: clientIP, Port: hint.Port}
+	}
+
+	// The host names are resolved apart from this loop, up to
+	// udpMaxLookups at once.
+	lookups := make(chan struct{}, udpMaxLookups)
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := relay.ReadFromUDP(buf)
+		if err != nil {
+			sess.log.Warn("UDP relay stopped", "remote_addr", addr, "error", err)
+			return
+		}
+
+		if clientAddr == nil && from.IP.Equal(clientIP) {
+			clientAddr = from
+		}
+		if clientAddr == nil {
+			continue
+		}
+
+		if from.IP.Equal(clientAddr.IP) && from.Port == clientAddr.Port {
+			datagram := buf[:n]
+			if n > 2 && datagram[2] != 0x00 {
+				var ok bool
+				datagram, ok, err = s.udpFrags.add(udpFragKey{client: from.String(), assoc: bound.String()}, datagram, time.Now())
+				if err != nil {
+					sess.log.Warn("Dropped UDP fragment from the client", "remote_addr", addr, "error", err)
+				}
+				if !ok {
+					continue
+				}
+			}
+			target, host, data, err := parseUDPHeader(datagram)
+			if err != nil {
+				sess.log.Warn("Dropped UDP datagram from the client", "remote_addr", addr, "error", err)
+				continue
+			}
+			if host == "" {
+				s.forwardUDP(relay, sess, target, host, data)
+				continue
+			}
+			if !sess.acl.AllowTarget(host, nil) {
+				sess.log.Warn("Dropped UDP datagram not allowed by ruleset", "remote_addr", addr, "target_addr", host)
+				continue
+			}
+			select {
+			case lookups <- struct{}{}:
+			default:
+				sess.log.Warn("Dropped UDP datagram, too many host names being resolved", "remote_addr", addr, "target_addr", host)
+				continue
+			}
+			go func(data []byte) {
+				defer func() { <-lookups }()
+				ctx, cancel := context.WithTimeout(sess.ctx, udpLookupTimeout)
+				defer cancel()
+				ips, err := s.lookupIPContext(ctx, host)
+				if err == nil && len(ips) == 0 {
+					err = fmt.Errorf("there is no IP address corresponding to host '%s'", host)
+				}
+				if err != nil {
+					sess.log.Warn("Dropped UDP datagram from the client", "remote_addr", addr, "target_addr", host, "error", err)
+					return
+				}
+				target.IP = ips[0]
+				s.forwardUDP(relay, sess, target, host, data)
+			}(slices.Clone(data))
+		} else {
+			datagram := appendAddr([]byte{0x00, 0x00, 0x00}, from.IP, from.Port)
+			datagram = append(datagram, buf[:n]...)
+			_, err = relay.WriteToUDP(datagram, clientAddr)
+			if err != nil {
+				sess.log.Warn("Failed to write to the client", "remote_addr", addr, "error", err)
+			}
+		}
+	}
+}
+
+// forwardUDP sends data from the client to target, requested as host if it is
+// not empty, if the ACL of sess allows it.
+func (s *Server) forwardUDP(relay *net.UDPConn, sess *session, target *net.UDPAddr, host string, data []byte) {
+	if !sess.acl.AllowTarget(host, target.IP) {
+		sess.log.Warn("Dropped UDP datagram not allowed by ruleset", "remote_addr", sess.clientAddr, "target_addr", target.String())
+		return
+	}
+	_, err := relay.WriteToUDP(data, target)
+	if err != nil {
+		sess.log.Warn("Failed to write to the remote", "remote_addr", sess.clientAddr, "target_addr", target.String(), "error", err)
+	}
+}
+
+// parseUDPHeader returns the target of a datagram from the client and its
+// data. When the client requested a host name, it is returned too and the IP
+// of the target is left nil.
+func parseUDPHeader(datagram []byte) (*net.UDPAddr, string, []byte, error) {
+	if len(datagram) < 4 {
+		return nil, "", nil, fmt.Errorf("datagram too short: %d bytes", len(datagram))
+	}
+	if datagram[2] != 0x00 {
+		return nil, "", nil, fmt.Errorf("fragmentation is not supported: %X", datagram[2])
+	}
+
+	target := new(net.UDPAddr)
+	var host string
+	rest := datagram[4:]
+	switch datagram[3] {
+	case 0x01, 0x04:
+		ipLen := 4
+		if datagram[3] == 0x04 {
+			ipLen = 16
+		}
+		if len(rest) < ipLen+2 {
+			return nil, "", nil, fmt.Errorf("datagram too short for the address")
+		}
+		target.IP = net.IP(rest[:ipLen])
+		rest = rest[ipLen:]
+	case 0x03:
+		if len(rest) < 1 || len(rest) < 1+int(rest[0])+2 {
+			return nil, "", nil, fmt.Errorf("datagram too short for the host name")
+		}
+		host = string(rest[1 : 1+rest[0]])
+		rest = rest[1+rest[0]:]
+	default:
+		return nil, "", nil, fmt.Errorf("unknown address type: %X", datagram[3])
+	}
+	target.Port = int(rest[0])<<8 + int(rest[1])
+	return target, host, rest[2:], nil
+}
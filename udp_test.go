@@ -0,0 +1,85 @@
+package gosocks_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// newUDPEchoServer returns the address of a UDP server which sends the
+// datagrams back, closed when t completes.
+func newUDPEchoServer(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(buf[:n], from)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// TestUDPHostNames checks that the datagrams for a host name are relayed,
+// and that a slow lookup does not hold back the other datagrams.
+func TestUDPHostNames(t *testing.T) {
+	target := newUDPEchoServer(t)
+	_, port, _ := net.SplitHostPort(target)
+	slow := make(chan struct{})
+	defer close(slow)
+	srv := gosockstest.NewServer(t, func(s *gosocks.Server) {
+		s.Resolver = resolverFunc(func(host string) ([]net.IP, error) {
+			if host == "slow.test" {
+				<-slow
+			}
+			return []net.IP{net.IPv4(127, 0, 0, 1)}, nil
+		})
+	})
+
+	conn := dialProxy(t, srv)
+	negotiate(t, conn, 0x00)
+	rep, _, bound := request(t, conn, 0x03, socksAddr(t, "0.0.0.0:0"))
+	if rep != 0x00 {
+		t.Fatalf("reply = %#x, want 0x00", rep)
+	}
+	relay := &net.UDPAddr{IP: net.IP(bound[:4]), Port: int(binary.BigEndian.Uint16(bound[4:]))}
+	udp, err := net.DialUDP("udp", nil, relay)
+	if err != nil {
+		t.Fatalf("DialUDP: %v", err)
+	}
+	defer udp.Close()
+	udp.SetDeadline(time.Now().Add(5 * time.Second))
+
+	for _, host := range []string{"slow.test", "127.0.0.1", "echo.test"} {
+		_, err = udp.Write(append(append([]byte{0x00, 0x00, 0x00}, socksAddr(t, net.JoinHostPort(host, port))...), host...))
+		if err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	buf := make([]byte, 512)
+	for _, want := range []string{"127.0.0.1", "echo.test"} {
+		n, err := udp.Read(buf)
+		if err != nil {
+			t.Fatalf("Read the answer to %s: %v", want, err)
+		}
+		// The answers come from the IP address of the target.
+		if header := append([]byte{0x00, 0x00, 0x00}, socksAddr(t, target)...); n < len(header) || string(buf[:len(header)]) != string(header) {
+			t.Fatalf("answer %q, want the header %q", buf[:n], header)
+		}
+		if got := string(buf[10:n]); got != want {
+			t.Errorf("answer = %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package gosocks
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// udpReassemblyTimeout is how long the fragments of an incomplete
+	// datagram are kept, the minimum of RFC 1928 section 7.
+	udpReassemblyTimeout = 5 * time.Second
+
+	// udpFragEnd is the FRAG bit marking the last fragment of a datagram.
+	udpFragEnd = 0x80
+
+	// maxUDPPayload is the largest payload of an IPv4 UDP datagram, which the
+	// reassembled datagrams must fit in to be relayed.
+	maxUDPPayload = 65507
+)
+
+// udpFragKey identifies a chain of fragments: those of a client of a UDP
+// association, the association being identified by its relay address.
+type udpFragKey struct {
+	client string
+	assoc  string
+}
+
+// udpFragChain holds the fragments of a datagram received so far, by their
+// position from 1 to 127.
+type udpFragChain struct {
+	started time.Time
+	header  []byte // of the first fragment, with FRAG 0
+	frags   map[byte][]byte
+	last    byte // position of the end of the datagram, 0 until it arrives
+	size    int
+}
+
+// udpReassembler reassembles the fragmented SOCKS5 UDP datagrams of RFC 1928
+// section 7. The fragments may arrive in any order, and the chains which are
+// not completed within udpReassemblyTimeout are discarded. The zero value is
+// ready to use.
+type udpReassembler struct {
+	mu     sync.Mutex
+	chains map[udpFragKey]*udpFragChain
+}
+
+// add buffers the fragment datagram, a SOCKS5 UDP datagram with a non-zero
+// FRAG field, received at now. When it completes its chain, add returns the
+// reassembled datagram: the header of the first fragment with FRAG 0
+// followed by the data of all the fragments in order.
+func (r *udpReassembler) add(key udpFragKey, datagram []byte, now time.Time) ([]byte, bool, error) {
+	headerLen, err := udpHeaderLen(datagram)
+	if err != nil {
+		return nil, false, err
+	}
+	frag := datagram[2]
+	position := frag &^ udpFragEnd
+	if position == 0 {
+		return nil, false, fmt.Errorf("invalid fragment position: %X", frag)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire(now)
+	if r.chains == nil {
+		r.chains = make(map[udpFragKey]*udpFragChain)
+	}
+	chain := r.chains[key]
+	if chain == nil {
+		chain = &udpFragChain{started: now, frags: make(map[byte][]byte)}
+		r.chains[key] = chain
+	}
+	if _, ok := chain.frags[position]; ok {
+		return nil, false, nil
+	}
+	var ends bool
+	if frag&udpFragEnd != 0 {
+		ends = chain.last != 0
+		chain.last = position
+	}
+	if ends || chain.last != 0 && chain.after(chain.last, position) {
+		delete(r.chains, key)
+		return nil, false, fmt.Errorf("fragment after the end of the datagram")
+	}
+	if position == 1 {
+		chain.header = append([]byte(nil), datagram[:headerLen]...)
+		chain.header[2] = 0x00
+	}
+	data := datagram[headerLen:]
+	chain.size += len(data)
+	if chain.size > maxUDPPayload {
+		delete(r.chains, key)
+		return nil, false, fmt.Errorf("reassembled datagram too long: more than %d bytes", maxUDPPayload)
+	}
+	chain.frags[position] = append([]byte(nil), data...)
+
+	if chain.last == 0 || len(chain.frags) < int(chain.last) {
+		return nil, false, nil
+	}
+	delete(r.chains, key)
+	datagram = append(make([]byte, 0, len(chain.header)+chain.size), chain.header...)
+	for i := byte(1); i <= chain.last; i++ {
+		datagram = append(datagram, chain.frags[i]...)
+	}
+	return datagram, true, nil
+}
+
+// after returns whether position, or the position of one of the fragments
+// of c, is past last.
+func (c *udpFragChain) after(last, position byte) bool {
+	if position > last {
+		return true
+	}
+	for p := range c.frags {
+		if p > last {
+			return true
+		}
+	}
+	return false
+}
+
+// expire discards the chains started more than udpReassemblyTimeout before
+// now.
+func (r *udpReassembler) expire(now time.Time) {
+	for key, chain := range r.chains {
+		if now.Sub(chain.started) >= udpReassemblyTimeout {
+			delete(r.chains, key)
+		}
+	}
+}
+
+// forget discards the chains of the association assoc, which ended.
+func (r *udpReassembler) forget(assoc string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key := range r.chains {
+		if key.assoc == assoc {
+			delete(r.chains, key)
+		}
+	}
+}
+
+// udpHeaderLen returns the length of the header of a SOCKS5 UDP datagram,
+// up to the port of its destination.
+func udpHeaderLen(datagram []byte) (int, error) {
+	if len(datagram) < 4 {
+		return 0, fmt.Errorf("datagram too short: %d bytes", len(datagram))
+	}
+	var n int
+	switch datagram[3] {
+	case 0x01:
+		n = 4 + 4 + 2
+	case 0x04:
+		n = 4 + 16 + 2
+	case 0x03:
+		if len(datagram) < 5 {
+			return 0, fmt.Errorf("datagram too short for the host name")
+		}
+		n = 4 + 1 + int(datagram[4]) + 2
+	default:
+		return 0, fmt.Errorf("unknown address type: %X", datagram[3])
+	}
+	if len(datagram) < n {
+		return 0, fmt.Errorf("datagram too short for the address")
+	}
+	return n, nil
+}
@@ -0,0 +1,87 @@
+package gosocks
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// fragment returns a SOCKS5 UDP datagram for 192.0.2.1:80 with frag and data.
+func fragment(frag byte, data string) []byte {
+	return append([]byte{0x00, 0x00, frag, 0x01, 192, 0, 2, 1, 0x00, 0x50}, data...)
+}
+
+func TestUDPReassemblerOutOfOrder(t *testing.T) {
+	var r udpReassembler
+	key := udpFragKey{client: "192.0.2.7:5000", assoc: "127.0.0.1:6000"}
+	now := time.Now()
+
+	for _, frag := range [][]byte{fragment(3|udpFragEnd, "ghi"), fragment(1, "abc"), fragment(1, "abc")} {
+		datagram, done, err := r.add(key, frag, now)
+		if err != nil || done || datagram != nil {
+			t.Fatalf("add(%q) = %q, %v, %v, want an incomplete datagram", frag, datagram, done, err)
+		}
+	}
+	datagram, done, err := r.add(key, fragment(2, "def"), now)
+	if err != nil || !done {
+		t.Fatalf("add of the last fragment = %v, %v, want the datagram", done, err)
+	}
+	if want := fragment(0, "abcdefghi"); !bytes.Equal(datagram, want) {
+		t.Errorf("datagram = %q, want %q", datagram, want)
+	}
+	if len(r.chains) != 0 {
+		t.Errorf("%d chains left, want none", len(r.chains))
+	}
+}
+
+func TestUDPReassemblerExpire(t *testing.T) {
+	var r udpReassembler
+	key := udpFragKey{client: "192.0.2.7:5000", assoc: "127.0.0.1:6000"}
+	start := time.Now()
+	r.add(key, fragment(1, "abc"), start)
+	r.add(key, fragment(2, "def"), start)
+
+	// The fragments of the stale chain are gone: the last one starts a new
+	// chain instead of completing it.
+	later := start.Add(udpReassemblyTimeout)
+	datagram, done, err := r.add(key, fragment(3|udpFragEnd, "ghi"), later)
+	if err != nil || done {
+		t.Fatalf("add after the timeout = %q, %v, %v, want an incomplete datagram", datagram, done, err)
+	}
+	if chain := r.chains[key]; chain == nil || len(chain.frags) != 1 || !chain.started.Equal(later) {
+		t.Errorf("chain = %+v, want a new one with the last fragment only", chain)
+	}
+
+	r.add(key, fragment(1, "jkl"), later)
+	datagram, done, err = r.add(key, fragment(2, "mno"), later)
+	if err != nil || !done || !bytes.Equal(datagram, fragment(0, "jklmnoghi")) {
+		t.Errorf("new chain = %q, %v, %v, want %q", datagram, done, err, fragment(0, "jklmnoghi"))
+	}
+}
+
+func TestUDPReassemblerErrors(t *testing.T) {
+	key := udpFragKey{client: "192.0.2.7:5000", assoc: "127.0.0.1:6000"}
+	long := string(make([]byte, maxUDPPayload/2+1))
+	tests := []struct {
+		name  string
+		frags [][]byte
+	}{
+		{"position 0", [][]byte{fragment(udpFragEnd, "abc")}},
+		{"after the end", [][]byte{fragment(2|udpFragEnd, "abc"), fragment(3, "def")}},
+		{"two ends", [][]byte{fragment(2|udpFragEnd, "abc"), fragment(3|udpFragEnd, "def")}},
+		{"too long", [][]byte{fragment(1, long), fragment(2, long)}},
+		{"truncated", [][]byte{fragment(1, "")[:6]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r udpReassembler
+			var err error
+			for _, frag := range tt.frags {
+				_, _, err = r.add(key, frag, time.Now())
+			}
+			if err == nil {
+				t.Error("add succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package gosocks_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/client"
+	"github.com/glacjay/gosocks/gosockstest"
+)
+
+// TestSOCKS4Upstream checks that the connections go through a SOCKS4
+// upstream proxy, and that only a SOCKS4a one gets the host name.
+func TestSOCKS4Upstream(t *testing.T) {
+	target := gosockstest.NewEchoServer(t)
+	_, port, _ := net.SplitHostPort(target)
+	localhost := resolverFunc(func(host string) ([]net.IP, error) {
+		return []net.IP{net.IPv4(127, 0, 0, 1)}, nil
+	})
+	tests := []struct {
+		scheme string
+		names  []string
+	}{
+		{"socks4", nil},
+		{"socks4a", []string{"target.test"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scheme, func(t *testing.T) {
+			var mu sync.Mutex
+			var names []string
+			upstream := gosockstest.NewServer(t, func(s *gosocks.Server) {
+				s.Resolver = resolverFunc(func(host string) ([]net.IP, error) {
+					mu.Lock()
+					names = append(names, host)
+					mu.Unlock()
+					return localhost.LookupIP(host)
+				})
+			})
+			dialer, err := gosocks.ParseUpstream(tt.scheme+"://"+upstream.Addr(), nil)
+			if err != nil {
+				t.Fatalf("ParseUpstream: %v", err)
+			}
+			srv := gosockstest.NewServer(t, gosockstest.WithDialer(dialer), func(s *gosocks.Server) {
+				s.Resolver = localhost
+			})
+
+			conn, err := client.Dial(srv.Addr(), net.JoinHostPort("target.test", port))
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			defer conn.Close()
+			echo(t, conn, "hello")
+
+			mu.Lock()
+			defer mu.Unlock()
+			if !slices.Equal(names, tt.names) {
+				t.Errorf("upstream resolved %q, want %q", names, tt.names)
+			}
+		})
+	}
+}
+
+// newConnectProxy returns an httptest.Server acting as an HTTP CONNECT proxy
+// which requires the Proxy-Authorization of username and password, and
+// connects to the localhost port of the requested address. The requested
+// addresses are sent on requests.
+func newConnectProxy(t *testing.T, username, password string, requests chan<- string) *httptest.Server {
+	t.Helper()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.Host
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		if u, p, ok := parseProxyAuth(r.Header.Get("Proxy-Authorization")); !ok || u != username || p != password {
+			w.Header().Set("Proxy-Authenticate", "Basic")
+			http.Error(w, "invalid credentials", http.StatusProxyAuthRequired)
+			return
+		}
+		_, port, _ := net.SplitHostPort(r.Host)
+		remote, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer remote.Close()
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go io.Copy(remote, conn)
+		io.Copy(conn, remote)
+	}))
+	t.Cleanup(proxy.Close)
+	return proxy
+}
+
+// parseProxyAuth decodes the credentials of a Basic Proxy-Authorization
+// header.
+func parseProxyAuth(header string) (username, password string, ok bool) {
+	r := &http.Request{Header: http.Header{"Authorization": {header}}}
+	return r.BasicAuth()
+}
+
+// TestHTTPConnectUpstream checks that the connections go through an HTTP
+// CONNECT upstream proxy with Basic authentication, for the host name of the
+// request, and that a refusal of the proxy fails the request.
+func TestHTTPConnectUpstream(t *testing.T) {
+	target := gosockstest.NewEchoServer(t)
+	_, port, _ := net.SplitHostPort(target)
+	requests := make(chan string, 1)
+	proxy := newConnectProxy(t, "user", "pass", requests)
+	proxyAddr := strings.TrimPrefix(proxy.URL, "http://")
+	tests := []struct {
+		name     string
+		upstream string
+		ok       bool
+	}{
+		{"valid credentials", "http://user:pass@" + proxyAddr, true},
+		{"wrong password", "http://user:wrong@" + proxyAddr, false},
+		{"no credentials", "http://" + proxyAddr, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer, err := gosocks.ParseUpstream(tt.upstream, nil)
+			if err != nil {
+				t.Fatalf("ParseUpstream: %v", err)
+			}
+			srv := gosockstest.NewServer(t, gosockstest.WithDialer(dialer), func(s *gosocks.Server) {
+				s.Resolver = resolverFunc(func(host string) ([]net.IP, error) {
+					return []net.IP{net.IPv4(127, 0, 0, 1)}, nil
+				})
+			})
+
+			addr := net.JoinHostPort("target.test", port)
+			conn, err := client.Dial(srv.Addr(), addr)
+			if ok := err == nil; ok != tt.ok {
+				t.Fatalf("Dial succeeded = %v, want %v (%v)", ok, tt.ok, err)
+			}
+			if got := <-requests; got != addr {
+				t.Errorf("the proxy was asked for %s, want %s", got, addr)
+			}
+			if err == nil {
+				defer conn.Close()
+				echo(t, conn, "hello")
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package gosocks
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocketHandler returns an HTTP handler which upgrades the requests to
+// WebSocket, then serves the SOCKS protocol over the binary messages as if
+// they were a byte stream. This lets the browsers and the CDNs which only
+// speak WebSocket reach the server. The pages of another origin than the
+// handler are refused, unless listed in s.WebSocketOrigins.
+func (s *Server) WebSocketHandler() http.Handler {
+	upgrader := websocket.Upgrader{CheckOrigin: s.checkWebSocketOrigin}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			s.logger().Warn("Failed to upgrade to WebSocket", "remote_addr", r.RemoteAddr, "error", err)
+			return
+		}
+		conn := &wsConn{Conn: ws}
+		if !s.trackConn(conn, true) {
+			conn.Close()
+			return
+		}
+		s.serveConn(conn, nil)
+	})
+}
+
+// checkWebSocketOrigin accepts the requests without an Origin header, those
+// from the same host, as gorilla/websocket does by default, and those from
+// s.WebSocketOrigins.
+func (s *Server) checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return slices.Contains(s.WebSocketOrigins, "*") || slices.Contains(s.WebSocketOrigins, origin)
+}
+
+// wsConn adapts a WebSocket connection to net.Conn.
+type wsConn struct {
+	*websocket.Conn
+	reader io.Reader
+}
+
+func (c *wsConn) Read(b []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			mt, r, err := c.NextReader()
+			if err != nil {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					return 0, io.EOF
+				}
+				return 0, err
+			}
+			if mt != websocket.BinaryMessage && mt != websocket.TextMessage {
+				continue
+			}
+			c.reader = r
+		}
+		n, err := c.reader.Read(b)
+		if err == io.EOF {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *wsConn) Write(b []byte) (int, error) {
+	err := c.WriteMessage(websocket.BinaryMessage, b)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *wsConn) CloseWrite() error {
+	return c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+}
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	err := c.SetReadDeadline(t)
+	if err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+var _ net.Conn = (*wsConn)(nil)
@@ -0,0 +1,96 @@
+package gosocks_test
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glacjay/gosocks"
+	"github.com/glacjay/gosocks/gosockstest"
+	"github.com/gorilla/websocket"
+)
+
+// readWS reads n bytes from the binary messages of ws.
+func readWS(t *testing.T, ws *websocket.Conn, n int) []byte {
+	t.Helper()
+	var b []byte
+	for len(b) < n {
+		_, message, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		b = append(b, message...)
+	}
+	return b
+}
+
+func TestWebSocketConnect(t *testing.T) {
+	target := gosockstest.NewEchoServer(t)
+	srv := &gosocks.Server{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	ts := httptest.NewServer(srv.WebSocketHandler())
+	defer ts.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/socks5", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	ws.WriteMessage(websocket.BinaryMessage, []byte{0x05, 0x01, 0x00})
+	if method := readWS(t, ws, 2); string(method) != "\x05\x00" {
+		t.Fatalf("method reply = %q, want no authentication", method)
+	}
+	ws.WriteMessage(websocket.BinaryMessage, append([]byte{0x05, 0x01, 0x00}, socksAddr(t, target)...))
+	if reply := readWS(t, ws, 10); reply[1] != 0x00 {
+		t.Fatalf("reply = %#x, want 0x00", reply[1])
+	}
+	ws.WriteMessage(websocket.BinaryMessage, []byte("hello"))
+	if got := readWS(t, ws, 5); string(got) != "hello" {
+		t.Errorf("echo = %q, want %q", got, "hello")
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins []string
+		origin  string
+		ok      bool
+	}{
+		{"no origin", nil, "", true},
+		{"same origin", nil, "http://HOST", true},
+		{"other origin", nil, "https://example.com", false},
+		{"allowed origin", []string{"https://example.com"}, "https://example.com", true},
+		{"any origin", []string{"*"}, "https://example.com", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &gosocks.Server{
+				Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+				WebSocketOrigins: tt.origins,
+			}
+			ts := httptest.NewServer(srv.WebSocketHandler())
+			defer ts.Close()
+
+			header := http.Header{}
+			if tt.origin != "" {
+				header.Set("Origin", strings.Replace(tt.origin, "HOST", ts.Listener.Addr().String(), 1))
+			}
+			ws, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/socks5", header)
+			if err == nil {
+				ws.Close()
+			}
+			if ok := err == nil; ok != tt.ok {
+				t.Errorf("upgrade succeeded = %v, want %v (%v)", ok, tt.ok, err)
+			}
+			if !tt.ok && resp != nil && resp.StatusCode != http.StatusForbidden {
+				t.Errorf("status = %d, want 403", resp.StatusCode)
+			}
+		})
+	}
+}